@@ -0,0 +1,696 @@
+/*
+ * dedupfs.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/winfsp/cgofuse/examples/shared"
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+/*
+ * Dedupfs is an in-memory file system that stores file data in fixed-size chunks
+ * keyed by their SHA-256 hash. Identical chunks are stored once and shared across
+ * files using reference counts; all-zero chunks are not stored at all.
+ */
+
+const (
+	chunksize = 4096
+	capacity  = 1024 * 1024 * 1024 // bytes of unique chunk data
+)
+
+func trace(vals ...interface{}) func(vals ...interface{}) {
+	uid, gid, _ := fuse.Getcontext()
+	return shared.Trace(1, fmt.Sprintf("[uid=%v,gid=%v]", uid, gid), vals...)
+}
+
+func split(path string) []string {
+	return strings.Split(path, "/")
+}
+
+type chunk_t struct {
+	hash   [sha256.Size]byte
+	data   []byte
+	refcnt int
+}
+
+type store_t struct {
+	chunks map[[sha256.Size]byte]*chunk_t
+}
+
+var zerochunk [chunksize]byte
+
+// intern returns a referenced chunk with contents data or nil if data is all zeroes.
+// It fails with -ENOSPC if a new chunk would exceed the capacity of the store.
+func (self *store_t) intern(data []byte) (*chunk_t, int) {
+	if string(zerochunk[:]) == string(data) {
+		return nil, 0
+	}
+	hash := sha256.Sum256(data)
+	chunk := self.chunks[hash]
+	if nil == chunk {
+		if int64(len(self.chunks)+1)*chunksize > capacity {
+			return nil, -fuse.ENOSPC
+		}
+		chunk = &chunk_t{hash: hash, data: append([]byte{}, data...)}
+		self.chunks[hash] = chunk
+	}
+	chunk.refcnt++
+	return chunk, 0
+}
+
+func (self *store_t) release(chunk *chunk_t) {
+	if nil == chunk {
+		return
+	}
+	chunk.refcnt--
+	if 0 == chunk.refcnt {
+		delete(self.chunks, chunk.hash)
+	}
+}
+
+type node_t struct {
+	stat    fuse.Stat_t
+	xatr    map[string][]byte
+	chld    map[string]*node_t
+	chunks  []*chunk_t // file data; nil entries are all-zero chunks
+	link    string     // symlink target
+	opencnt int
+}
+
+func newNode(dev uint64, ino uint64, mode uint32, uid uint32, gid uint32) *node_t {
+	tmsp := fuse.Now()
+	self := node_t{
+		stat: fuse.Stat_t{
+			Dev:      dev,
+			Ino:      ino,
+			Mode:     mode,
+			Nlink:    1,
+			Uid:      uid,
+			Gid:      gid,
+			Atim:     tmsp,
+			Mtim:     tmsp,
+			Ctim:     tmsp,
+			Birthtim: tmsp,
+			Blksize:  chunksize,
+		},
+	}
+	if fuse.S_IFDIR == self.stat.Mode&fuse.S_IFMT {
+		self.chld = map[string]*node_t{}
+	}
+	return &self
+}
+
+type Dedupfs struct {
+	fuse.FileSystemBase
+	lock    sync.Mutex
+	ino     uint64
+	root    *node_t
+	openmap map[uint64]*node_t
+	store   store_t
+	nodecnt uint64
+}
+
+// readChunk copies the contents of chunk i of node to buff.
+func (self *Dedupfs) readChunk(node *node_t, i int, buff []byte) {
+	if len(node.chunks) > i && nil != node.chunks[i] {
+		copy(buff, node.chunks[i].data)
+	} else {
+		copy(buff, zerochunk[:])
+	}
+}
+
+// writeChunk replaces chunk i of node with data. If node holds the only reference to
+// the old chunk, the old chunk is released first so that its space can be reused;
+// otherwise a full store could not overwrite a file in place.
+func (self *Dedupfs) writeChunk(node *node_t, i int, data []byte) int {
+	if old := node.chunks[i]; nil != old && 1 == old.refcnt {
+		self.store.release(old)
+		node.chunks[i] = nil
+	}
+	chunk, errc := self.store.intern(data)
+	if 0 != errc {
+		return errc
+	}
+	self.store.release(node.chunks[i])
+	node.chunks[i] = chunk
+	return 0
+}
+
+// resize sets the size of the file data of node. On failure the file is unchanged.
+func (self *Dedupfs) resize(node *node_t, size int64) int {
+	count := int((size + chunksize - 1) / chunksize)
+	if rem := size % chunksize; 0 != rem && size < node.stat.Size {
+		// zero the tail of the last chunk
+		data := make([]byte, chunksize)
+		self.readChunk(node, count-1, data)
+		copy(data[rem:], zerochunk[rem:])
+		if errc := self.writeChunk(node, count-1, data); 0 != errc {
+			return errc
+		}
+	}
+	for i := count; len(node.chunks) > i; i++ {
+		self.store.release(node.chunks[i])
+	}
+	if count <= len(node.chunks) {
+		node.chunks = node.chunks[:count]
+	} else {
+		node.chunks = append(node.chunks, make([]*chunk_t, count-len(node.chunks))...)
+	}
+	node.stat.Size = size
+	self.allocated(node)
+	return 0
+}
+
+// allocated updates the block count of node to its non-zero chunks.
+func (self *Dedupfs) allocated(node *node_t) {
+	node.stat.Blocks = 0
+	for _, chunk := range node.chunks {
+		if nil != chunk {
+			node.stat.Blocks += chunksize / 512
+		}
+	}
+}
+
+func (self *Dedupfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	defer trace(path)(&errc, stat)
+	defer self.synchronize()()
+	used := uint64(len(self.store.chunks))
+	total := uint64(capacity / chunksize)
+	*stat = fuse.Statfs_t{
+		Bsize:   chunksize,
+		Frsize:  chunksize,
+		Blocks:  total,
+		Bfree:   total - used,
+		Bavail:  total - used,
+		Files:   self.nodecnt,
+		Ffree:   0,
+		Favail:  0,
+		Namemax: 255,
+	}
+	return 0
+}
+
+func (self *Dedupfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	defer trace(path, mode, dev)(&errc)
+	defer self.synchronize()()
+	return self.makeNode(path, mode, dev, "")
+}
+
+func (self *Dedupfs) Mkdir(path string, mode uint32) (errc int) {
+	defer trace(path, mode)(&errc)
+	defer self.synchronize()()
+	return self.makeNode(path, fuse.S_IFDIR|(mode&07777), 0, "")
+}
+
+func (self *Dedupfs) Unlink(path string) (errc int) {
+	defer trace(path)(&errc)
+	defer self.synchronize()()
+	return self.removeNode(path, false)
+}
+
+func (self *Dedupfs) Rmdir(path string) (errc int) {
+	defer trace(path)(&errc)
+	defer self.synchronize()()
+	return self.removeNode(path, true)
+}
+
+func (self *Dedupfs) Link(oldpath string, newpath string) (errc int) {
+	defer trace(oldpath, newpath)(&errc)
+	defer self.synchronize()()
+	_, _, oldnode := self.lookupNode(oldpath, nil)
+	if nil == oldnode {
+		return -fuse.ENOENT
+	}
+	newprnt, newname, newnode := self.lookupNode(newpath, nil)
+	if nil == newprnt {
+		return -fuse.ENOENT
+	}
+	if nil != newnode {
+		return -fuse.EEXIST
+	}
+	oldnode.stat.Nlink++
+	newprnt.chld[newname] = oldnode
+	tmsp := fuse.Now()
+	oldnode.stat.Ctim = tmsp
+	newprnt.stat.Ctim = tmsp
+	newprnt.stat.Mtim = tmsp
+	return 0
+}
+
+func (self *Dedupfs) Symlink(target string, newpath string) (errc int) {
+	defer trace(target, newpath)(&errc)
+	defer self.synchronize()()
+	return self.makeNode(newpath, fuse.S_IFLNK|00777, 0, target)
+}
+
+func (self *Dedupfs) Readlink(path string) (errc int, target string) {
+	defer trace(path)(&errc, &target)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT, ""
+	}
+	if fuse.S_IFLNK != node.stat.Mode&fuse.S_IFMT {
+		return -fuse.EINVAL, ""
+	}
+	return 0, node.link
+}
+
+func (self *Dedupfs) Rename(oldpath string, newpath string) (errc int) {
+	defer trace(oldpath, newpath)(&errc)
+	defer self.synchronize()()
+	oldprnt, oldname, oldnode := self.lookupNode(oldpath, nil)
+	if nil == oldnode {
+		return -fuse.ENOENT
+	}
+	newprnt, newname, newnode := self.lookupNode(newpath, oldnode)
+	if nil == newprnt {
+		return -fuse.ENOENT
+	}
+	if "" == newname {
+		// guard against directory loop creation
+		return -fuse.EINVAL
+	}
+	if oldprnt == newprnt && oldname == newname {
+		return 0
+	}
+	if nil != newnode {
+		errc = self.removeNode(newpath, fuse.S_IFDIR == oldnode.stat.Mode&fuse.S_IFMT)
+		if 0 != errc {
+			return errc
+		}
+	}
+	delete(oldprnt.chld, oldname)
+	newprnt.chld[newname] = oldnode
+	return 0
+}
+
+func (self *Dedupfs) Chmod(path string, mode uint32) (errc int) {
+	defer trace(path, mode)(&errc)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	node.stat.Mode = (node.stat.Mode & fuse.S_IFMT) | mode&07777
+	node.stat.Ctim = fuse.Now()
+	return 0
+}
+
+func (self *Dedupfs) Chown(path string, uid uint32, gid uint32) (errc int) {
+	defer trace(path, uid, gid)(&errc)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	if ^uint32(0) != uid {
+		node.stat.Uid = uid
+	}
+	if ^uint32(0) != gid {
+		node.stat.Gid = gid
+	}
+	node.stat.Ctim = fuse.Now()
+	return 0
+}
+
+func (self *Dedupfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	defer trace(path, tmsp)(&errc)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	node.stat.Ctim = fuse.Now()
+	if nil == tmsp {
+		tmsp0 := node.stat.Ctim
+		tmsa := [2]fuse.Timespec{tmsp0, tmsp0}
+		tmsp = tmsa[:]
+	}
+	node.stat.Atim = tmsp[0]
+	node.stat.Mtim = tmsp[1]
+	return 0
+}
+
+func (self *Dedupfs) Open(path string, flags int) (errc int, fh uint64) {
+	defer trace(path, flags)(&errc, &fh)
+	defer self.synchronize()()
+	return self.openNode(path, false)
+}
+
+func (self *Dedupfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
+	defer trace(path, fh)(&errc, stat)
+	defer self.synchronize()()
+	node := self.getNode(path, fh)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	*stat = node.stat
+	return 0
+}
+
+func (self *Dedupfs) Truncate(path string, size int64, fh uint64) (errc int) {
+	defer trace(path, size, fh)(&errc)
+	defer self.synchronize()()
+	node := self.getNode(path, fh)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	if errc = self.resize(node, size); 0 != errc {
+		return errc
+	}
+	tmsp := fuse.Now()
+	node.stat.Ctim = tmsp
+	node.stat.Mtim = tmsp
+	return 0
+}
+
+func (self *Dedupfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	defer trace(path, buff, ofst, fh)(&n)
+	defer self.synchronize()()
+	node := self.getNode(path, fh)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	endofst := ofst + int64(len(buff))
+	if endofst > node.stat.Size {
+		endofst = node.stat.Size
+	}
+	if endofst < ofst {
+		return 0
+	}
+	data := make([]byte, chunksize)
+	for ofst < endofst {
+		i, rem := int(ofst/chunksize), ofst%chunksize
+		self.readChunk(node, i, data)
+		c := copy(buff[n:endofst-ofst+int64(n)], data[rem:])
+		n += c
+		ofst += int64(c)
+	}
+	node.stat.Atim = fuse.Now()
+	return
+}
+
+func (self *Dedupfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	defer trace(path, buff, ofst, fh)(&n)
+	defer self.synchronize()()
+	node := self.getNode(path, fh)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	endofst := ofst + int64(len(buff))
+	if count := int((endofst + chunksize - 1) / chunksize); len(node.chunks) < count {
+		node.chunks = append(node.chunks, make([]*chunk_t, count-len(node.chunks))...)
+	}
+	data := make([]byte, chunksize)
+	errc := 0
+	for ofst < endofst {
+		i, rem := int(ofst/chunksize), ofst%chunksize
+		self.readChunk(node, i, data)
+		c := copy(data[rem:], buff[n:])
+		if errc = self.writeChunk(node, i, data); 0 != errc {
+			break
+		}
+		n += c
+		ofst += int64(c)
+	}
+	// the size only covers data that was stored; on failure report a short write
+	// and drop the chunks that were added past the end of the file
+	if 0 < n && ofst > node.stat.Size {
+		node.stat.Size = ofst
+	}
+	self.resize(node, node.stat.Size)
+	if 0 == n {
+		return errc
+	}
+	tmsp := fuse.Now()
+	node.stat.Ctim = tmsp
+	node.stat.Mtim = tmsp
+	return
+}
+
+func (self *Dedupfs) Release(path string, fh uint64) (errc int) {
+	defer trace(path, fh)(&errc)
+	defer self.synchronize()()
+	return self.closeNode(fh)
+}
+
+func (self *Dedupfs) Opendir(path string) (errc int, fh uint64) {
+	defer trace(path)(&errc, &fh)
+	defer self.synchronize()()
+	return self.openNode(path, true)
+}
+
+func (self *Dedupfs) Readdir(path string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+	defer trace(path, fill, ofst, fh)(&errc)
+	defer self.synchronize()()
+	node := self.openmap[fh]
+	fill(".", &node.stat, 0)
+	fill("..", nil, 0)
+	for name, chld := range node.chld {
+		if !fill(name, &chld.stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func (self *Dedupfs) Releasedir(path string, fh uint64) (errc int) {
+	defer trace(path, fh)(&errc)
+	defer self.synchronize()()
+	return self.closeNode(fh)
+}
+
+func (self *Dedupfs) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	defer trace(path, name, value, flags)(&errc)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	if "com.apple.ResourceFork" == name {
+		return -fuse.ENOTSUP
+	}
+	if fuse.XATTR_CREATE == flags {
+		if _, ok := node.xatr[name]; ok {
+			return -fuse.EEXIST
+		}
+	} else if fuse.XATTR_REPLACE == flags {
+		if _, ok := node.xatr[name]; !ok {
+			return -fuse.ENOATTR
+		}
+	}
+	xatr := make([]byte, len(value))
+	copy(xatr, value)
+	if nil == node.xatr {
+		node.xatr = map[string][]byte{}
+	}
+	node.xatr[name] = xatr
+	return 0
+}
+
+func (self *Dedupfs) Getxattr(path string, name string) (errc int, xatr []byte) {
+	defer trace(path, name)(&errc, &xatr)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT, nil
+	}
+	if "com.apple.ResourceFork" == name {
+		return -fuse.ENOTSUP, nil
+	}
+	xatr, ok := node.xatr[name]
+	if !ok {
+		return -fuse.ENOATTR, nil
+	}
+	return 0, xatr
+}
+
+func (self *Dedupfs) Removexattr(path string, name string) (errc int) {
+	defer trace(path, name)(&errc)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	if "com.apple.ResourceFork" == name {
+		return -fuse.ENOTSUP
+	}
+	if _, ok := node.xatr[name]; !ok {
+		return -fuse.ENOATTR
+	}
+	delete(node.xatr, name)
+	return 0
+}
+
+func (self *Dedupfs) Listxattr(path string, fill func(name string) bool) (errc int) {
+	defer trace(path, fill)(&errc)
+	defer self.synchronize()()
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	for name := range node.xatr {
+		if !fill(name) {
+			return -fuse.ERANGE
+		}
+	}
+	return 0
+}
+
+func (self *Dedupfs) lookupNode(path string, ancestor *node_t) (prnt *node_t, name string, node *node_t) {
+	prnt = self.root
+	name = ""
+	node = self.root
+	for _, c := range split(path) {
+		if "" != c {
+			if 255 < len(c) {
+				panic(fuse.Error(-fuse.ENAMETOOLONG))
+			}
+			prnt, name = node, c
+			if node == nil {
+				return
+			}
+			node = node.chld[c]
+			if nil != ancestor && node == ancestor {
+				name = "" // special case loop condition
+				return
+			}
+		}
+	}
+	return
+}
+
+func (self *Dedupfs) makeNode(path string, mode uint32, dev uint64, link string) int {
+	prnt, name, node := self.lookupNode(path, nil)
+	if nil == prnt {
+		return -fuse.ENOENT
+	}
+	if nil != node {
+		return -fuse.EEXIST
+	}
+	self.ino++
+	uid, gid, _ := fuse.Getcontext()
+	node = newNode(dev, self.ino, mode, uid, gid)
+	if "" != link {
+		node.link = link
+		node.stat.Size = int64(len(link))
+	}
+	prnt.chld[name] = node
+	prnt.stat.Ctim = node.stat.Ctim
+	prnt.stat.Mtim = node.stat.Ctim
+	self.nodecnt++
+	return 0
+}
+
+func (self *Dedupfs) removeNode(path string, dir bool) int {
+	prnt, name, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	if !dir && fuse.S_IFDIR == node.stat.Mode&fuse.S_IFMT {
+		return -fuse.EISDIR
+	}
+	if dir && fuse.S_IFDIR != node.stat.Mode&fuse.S_IFMT {
+		return -fuse.ENOTDIR
+	}
+	if 0 < len(node.chld) {
+		return -fuse.ENOTEMPTY
+	}
+	node.stat.Nlink--
+	delete(prnt.chld, name)
+	tmsp := fuse.Now()
+	node.stat.Ctim = tmsp
+	prnt.stat.Ctim = tmsp
+	prnt.stat.Mtim = tmsp
+	self.freeNode(node)
+	return 0
+}
+
+// freeNode releases the chunks of node when it is no longer linked or open.
+func (self *Dedupfs) freeNode(node *node_t) {
+	if 0 == node.stat.Nlink && 0 == node.opencnt {
+		self.resize(node, 0)
+		self.nodecnt--
+	}
+}
+
+func (self *Dedupfs) openNode(path string, dir bool) (int, uint64) {
+	_, _, node := self.lookupNode(path, nil)
+	if nil == node {
+		return -fuse.ENOENT, ^uint64(0)
+	}
+	if !dir && fuse.S_IFDIR == node.stat.Mode&fuse.S_IFMT {
+		return -fuse.EISDIR, ^uint64(0)
+	}
+	if dir && fuse.S_IFDIR != node.stat.Mode&fuse.S_IFMT {
+		return -fuse.ENOTDIR, ^uint64(0)
+	}
+	node.opencnt++
+	if 1 == node.opencnt {
+		self.openmap[node.stat.Ino] = node
+	}
+	return 0, node.stat.Ino
+}
+
+func (self *Dedupfs) closeNode(fh uint64) int {
+	node := self.openmap[fh]
+	node.opencnt--
+	if 0 == node.opencnt {
+		delete(self.openmap, node.stat.Ino)
+		self.freeNode(node)
+	}
+	return 0
+}
+
+func (self *Dedupfs) getNode(path string, fh uint64) *node_t {
+	if ^uint64(0) == fh {
+		_, _, node := self.lookupNode(path, nil)
+		return node
+	} else {
+		return self.openmap[fh]
+	}
+}
+
+func (self *Dedupfs) synchronize() func() {
+	self.lock.Lock()
+	return func() {
+		self.lock.Unlock()
+	}
+}
+
+func NewDedupfs() *Dedupfs {
+	self := Dedupfs{}
+	defer self.synchronize()()
+	self.ino++
+	self.root = newNode(0, self.ino, fuse.S_IFDIR|00777, 0, 0)
+	self.openmap = map[uint64]*node_t{}
+	self.store.chunks = map[[sha256.Size]byte]*chunk_t{}
+	self.nodecnt = 1
+	return &self
+}
+
+func main() {
+	dedupfs := NewDedupfs()
+	host := fuse.NewFileSystemHost(dedupfs)
+	host.Mount("", os.Args[1:])
+}
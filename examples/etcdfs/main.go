@@ -15,5 +15,7 @@ func main() {
 	}
 
 	host := fuse.NewFileSystemHost(fs)
+	// Readdir fills in full stat information for each entry
+	host.SetCapReaddirPlus(true)
 	host.Mount("", args[1:])
 }
@@ -57,11 +57,12 @@ func resize(slice []byte, size int64, zeroinit bool) []byte {
 }
 
 type node_t struct {
-	stat    fuse.Stat_t
-	xatr    map[string][]byte
-	chld    map[string]*node_t
-	data    []byte
-	opencnt int
+	stat      fuse.Stat_t
+	xatr      map[string][]byte
+	chld      map[string]*node_t
+	data      []byte
+	opencnt   int
+	lookupcnt uint64
 }
 
 func newNode(dev uint64, ino uint64, mode uint32, uid uint32, gid uint32) *node_t {
@@ -83,6 +84,7 @@ func newNode(dev uint64, ino uint64, mode uint32, uid uint32, gid uint32) *node_
 		nil,
 		nil,
 		nil,
+		0,
 		0}
 	if fuse.S_IFDIR == self.stat.Mode&fuse.S_IFMT {
 		self.chld = map[string]*node_t{}
@@ -96,6 +98,7 @@ type Memfs struct {
 	ino     uint64
 	root    *node_t
 	openmap map[uint64]*node_t
+	nodemap map[uint64]*node_t
 }
 
 func (self *Memfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
@@ -257,6 +260,52 @@ func (self *Memfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int)
 	return 0
 }
 
+func (self *Memfs) Lookup(parent uint64, name string, stat *fuse.Stat_t) (errc int, ino uint64) {
+	defer trace(parent, name)(&errc, &ino, stat)
+	defer self.synchronize()()
+	prnt := self.getNodeById(parent, ^uint64(0))
+	if nil == prnt {
+		return -fuse.ENOENT, ^uint64(0)
+	}
+	if 255 < len(name) {
+		return -fuse.ENAMETOOLONG, ^uint64(0)
+	}
+	node := prnt.chld[name]
+	if nil == node {
+		return -fuse.ENOENT, ^uint64(0)
+	}
+	node.lookupcnt++
+	if 1 == node.lookupcnt {
+		self.nodemap[node.stat.Ino] = node
+	}
+	*stat = node.stat
+	return 0, node.stat.Ino
+}
+
+func (self *Memfs) Forget(ino uint64, nlookup uint64) {
+	defer trace(ino, nlookup)()
+	defer self.synchronize()()
+	node := self.nodemap[ino]
+	if nil == node {
+		return
+	}
+	node.lookupcnt -= nlookup
+	if 0 == node.lookupcnt {
+		delete(self.nodemap, ino)
+	}
+}
+
+func (self *Memfs) GetattrNode(ino uint64, stat *fuse.Stat_t, fh uint64) (errc int) {
+	defer trace(ino, fh)(&errc, stat)
+	defer self.synchronize()()
+	node := self.getNodeById(ino, fh)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	*stat = node.stat
+	return 0
+}
+
 func (self *Memfs) Truncate(path string, size int64, fh uint64) (errc int) {
 	defer trace(path, size, fh)(&errc)
 	defer self.synchronize()()
@@ -339,6 +388,26 @@ func (self *Memfs) Readdir(path string,
 	return 0
 }
 
+func (self *Memfs) ReaddirNode(ino uint64,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+	defer trace(ino, fill, ofst, fh)(&errc)
+	defer self.synchronize()()
+	node := self.getNodeById(ino, fh)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+	fill(".", &node.stat, 0)
+	fill("..", nil, 0)
+	for name, chld := range node.chld {
+		if !fill(name, &chld.stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
 func (self *Memfs) Releasedir(path string, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc)
 	defer self.synchronize()()
@@ -561,6 +630,18 @@ func (self *Memfs) getNode(path string, fh uint64) *node_t {
 	}
 }
 
+func (self *Memfs) getNodeById(ino uint64, fh uint64) *node_t {
+	if ^uint64(0) == fh {
+		// the root is not looked up and has node ID FUSE_ROOT_ID regardless of its Ino
+		if fuse.FUSE_ROOT_ID == ino {
+			return self.root
+		}
+		return self.nodemap[ino]
+	} else {
+		return self.openmap[fh]
+	}
+}
+
 func (self *Memfs) synchronize() func() {
 	self.lock.Lock()
 	return func() {
@@ -574,9 +655,11 @@ func NewMemfs() *Memfs {
 	self.ino++
 	self.root = newNode(0, self.ino, fuse.S_IFDIR|00777, 0, 0)
 	self.openmap = map[uint64]*node_t{}
+	self.nodemap = map[uint64]*node_t{}
 	return &self
 }
 
+var _ fuse.FileSystemNode = (*Memfs)(nil)
 var _ fuse.FileSystemChflags = (*Memfs)(nil)
 var _ fuse.FileSystemSetcrtime = (*Memfs)(nil)
 var _ fuse.FileSystemSetchgtime = (*Memfs)(nil)
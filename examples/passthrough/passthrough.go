@@ -206,6 +206,21 @@ func (self *Ptfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int)
 	return n
 }
 
+func (self *Ptfs) ReadBuf(path string, size int, ofst int64, fh uint64) (errc int, fd int, pos int64) {
+	defer trace(path, size, ofst, fh)(&errc, &fd, &pos)
+	return 0, int(fh), ofst
+}
+
+func (self *Ptfs) WriteBuf(path string, size int, ofst int64, fh uint64) (errc int, fd int, pos int64) {
+	defer trace(path, size, ofst, fh)(&errc, &fd, &pos)
+	return 0, int(fh), ofst
+}
+
+func (self *Ptfs) Passthrough(path string, fh uint64) (fd int) {
+	defer trace(path, fh)(&fd)
+	return int(fh)
+}
+
 func (self *Ptfs) Release(path string, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc)
 	return errno(syscall.Close(int(fh)))
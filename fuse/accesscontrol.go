@@ -0,0 +1,649 @@
+/*
+ * accesscontrol.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	pathutil "path"
+	"strconv"
+	"strings"
+)
+
+// AccessControlOptions contains options for AccessControl.
+type AccessControlOptions struct {
+	// Getcontext returns the user ID, group ID and process ID of the current operation.
+	// If nil the package function Getcontext is used.
+	Getcontext func() (uid uint32, gid uint32, pid int)
+
+	// Groups returns the supplementary group IDs of the current operation. If nil the
+	// groups are read from /proc/PID/status when available or else looked up from the
+	// user database.
+	Groups func(uid uint32, gid uint32, pid int) []uint32
+
+	// If true, POSIX ACLs stored in the system.posix_acl_access and
+	// system.posix_acl_default extended attributes are enforced and maintained.
+	Acl bool
+
+	// Umask returns the umask of the current operation. If nil the package function
+	// Getumask is used. It is only used if Acl is set.
+	Umask func() uint32
+}
+
+type accessControlFs struct {
+	FileSystemInterface
+	opts AccessControlOptions
+}
+
+// accessContext contains the credentials of the current operation.
+type accessContext struct {
+	fs     *accessControlFs
+	uid    uint32
+	gid    uint32
+	pid    int
+	groups []uint32
+}
+
+// AccessControl returns a file system that enforces POSIX permission checks on fs in
+// the same way as the default_permissions mount option: the mode, owner and group
+// reported by Getattr are checked against the user ID, group ID and supplementary
+// groups of the caller. Search permission is checked on all directories leading to a
+// path; write and search permission is checked on the parent directory of files being
+// created, removed or renamed; restricted deletion (sticky) directories are honored;
+// and Chmod, Chown, Utimens and extended attribute operations are restricted as on
+// Linux. The user ID 0 is granted all permissions except execute permission on files
+// that have no execute bit set.
+//
+// If the Acl option is set, access ACLs are checked in place of the permission bits,
+// new files and directories inherit the default ACL of their parent directory, Chmod
+// updates the access ACL of a file and setting the access ACL updates its permission
+// bits. Only the owner of a file may change its ACLs.
+//
+// POSIX requires that the umask is ignored when a file inherits a default ACL. For this
+// reason, if the Acl option is set, a FileSystemHost that hosts the returned file
+// system directly asks the kernel not to apply the umask and the umask is applied by
+// the file system instead, unless a default ACL is inherited. If the returned file
+// system is wrapped by another file system, SetCapDontMask must be called on the host
+// for this to work; without it the kernel applies the umask to inherited permissions.
+//
+// Operations on open file handles are not checked, because permissions are checked
+// when a file is opened.
+func AccessControl(fs FileSystemInterface, opts AccessControlOptions) FileSystemInterface {
+	if nil == opts.Getcontext {
+		opts.Getcontext = Getcontext
+	}
+	if nil == opts.Groups {
+		opts.Groups = accessGroups
+	}
+	if nil == opts.Umask {
+		opts.Umask = Getumask
+	}
+	return &accessControlFs{fs, opts}
+}
+
+func (fs *accessControlFs) dontMask() bool {
+	return fs.opts.Acl
+}
+
+// accessGroups returns the supplementary groups of process pid or user uid.
+func accessGroups(uid uint32, gid uint32, pid int) []uint32 {
+	if f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/status"); nil == err {
+		defer f.Close()
+		scan := bufio.NewScanner(f)
+		for scan.Scan() {
+			line := scan.Text()
+			if strings.HasPrefix(line, "Groups:") {
+				groups := []uint32{}
+				for _, s := range strings.Fields(line[len("Groups:"):]) {
+					if g, err := strconv.ParseUint(s, 10, 32); nil == err {
+						groups = append(groups, uint32(g))
+					}
+				}
+				return groups
+			}
+		}
+	}
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if nil != err {
+		return nil
+	}
+	ids, err := u.GroupIds()
+	if nil != err {
+		return nil
+	}
+	groups := []uint32{}
+	for _, s := range ids {
+		if g, err := strconv.ParseUint(s, 10, 32); nil == err {
+			groups = append(groups, uint32(g))
+		}
+	}
+	return groups
+}
+
+func (fs *accessControlFs) context() *accessContext {
+	uid, gid, pid := fs.opts.Getcontext()
+	return &accessContext{fs: fs, uid: uid, gid: gid, pid: pid}
+}
+
+// member reports whether the caller is a member of group gid.
+func (ctx *accessContext) member(gid uint32) bool {
+	if ctx.gid == gid {
+		return true
+	}
+	if nil == ctx.groups {
+		ctx.groups = ctx.fs.opts.Groups(ctx.uid, ctx.gid, ctx.pid)
+		if nil == ctx.groups {
+			ctx.groups = []uint32{}
+		}
+	}
+	for _, g := range ctx.groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// acl returns the ACL stored in the extended attribute name of path or nil.
+func (fs *accessControlFs) acl(path string, name string) Acl {
+	if !fs.opts.Acl {
+		return nil
+	}
+	errc, value := fs.FileSystemInterface.Getxattr(path, name)
+	if 0 != errc {
+		return nil
+	}
+	acl, err := AclDecode(value)
+	if nil != err {
+		return nil
+	}
+	return acl
+}
+
+// permits checks the access mask (a combination of R_OK, W_OK, X_OK) against the
+// attributes and access ACL of the file at path.
+func (ctx *accessContext) permits(path string, stat *Stat_t, mask uint32) int {
+	mask &= R_OK | W_OK | X_OK
+	if 0 == ctx.uid {
+		if 0 != mask&X_OK && S_IFDIR != stat.Mode&S_IFMT && 0 == stat.Mode&0111 {
+			return -EACCES
+		}
+		return 0
+	}
+	if acl := ctx.fs.acl(path, XATTR_POSIX_ACL_ACCESS); acl.extended() {
+		if !acl.permits(ctx, stat.Uid, stat.Gid, mask) {
+			return -EACCES
+		}
+		return 0
+	}
+	bits := stat.Mode
+	if ctx.uid == stat.Uid {
+		bits >>= 6
+	} else if ctx.member(stat.Gid) {
+		bits >>= 3
+	}
+	if 0 != mask&^bits&7 {
+		return -EACCES
+	}
+	return 0
+}
+
+// owns reports whether the caller owns a file or is the user ID 0.
+func (ctx *accessContext) owns(stat *Stat_t) bool {
+	return 0 == ctx.uid || ctx.uid == stat.Uid
+}
+
+// lookup checks search permission on the directories leading to path and returns
+// the attributes of path.
+func (ctx *accessContext) lookup(path string, stat *Stat_t) int {
+	if "/" != path {
+		dir := Stat_t{}
+		if errc := ctx.lookup(pathutil.Dir(path), &dir); 0 != errc {
+			return errc
+		}
+		if S_IFDIR != dir.Mode&S_IFMT {
+			return -ENOTDIR
+		}
+		if errc := ctx.permits(pathutil.Dir(path), &dir, X_OK); 0 != errc {
+			return errc
+		}
+	}
+	return ctx.fs.FileSystemInterface.Getattr(path, stat, ^uint64(0))
+}
+
+// check checks search permission on the directories leading to path and mask on
+// path itself.
+func (ctx *accessContext) check(path string, mask uint32) (int, *Stat_t) {
+	stat := &Stat_t{}
+	if errc := ctx.lookup(path, stat); 0 != errc {
+		return errc, nil
+	}
+	return ctx.permits(path, stat, mask), stat
+}
+
+// checkParent checks write and search permission on the parent directory of path.
+func (ctx *accessContext) checkParent(path string) (int, *Stat_t) {
+	return ctx.check(pathutil.Dir(path), W_OK|X_OK)
+}
+
+// checkRemove checks that path may be removed from or replaced in its parent
+// directory. If the path does not exist and mustExist is false, only the parent
+// directory is checked.
+func (ctx *accessContext) checkRemove(path string, mustExist bool) int {
+	errc, dir := ctx.checkParent(path)
+	if 0 != errc {
+		return errc
+	}
+	stat := Stat_t{}
+	errc = ctx.fs.FileSystemInterface.Getattr(path, &stat, ^uint64(0))
+	if -ENOENT == errc && !mustExist {
+		return 0
+	}
+	if 0 != errc {
+		return errc
+	}
+	if 0 != dir.Mode&S_ISVTX && !ctx.owns(&stat) && ctx.uid != dir.Uid {
+		return -EPERM
+	}
+	return 0
+}
+
+func (ctx *accessContext) checkOwner(path string) (int, *Stat_t) {
+	errc, stat := ctx.check(path, 0)
+	if 0 == errc && !ctx.owns(stat) {
+		errc = -EPERM
+	}
+	return errc, stat
+}
+
+// createMode returns the mode with which the file or directory path is to be created
+// and the default ACL that it inherits. The umask is applied unless there is a
+// default ACL.
+func (fs *accessControlFs) createMode(path string, mode uint32) (uint32, Acl) {
+	if !fs.opts.Acl {
+		return mode, nil
+	}
+	dacl := fs.acl(pathutil.Dir(path), XATTR_POSIX_ACL_DEFAULT)
+	if nil == dacl {
+		mode &^= fs.opts.Umask() & 0777
+	}
+	return mode, dacl
+}
+
+// inherit applies the default ACL dacl to the newly created file or directory path.
+func (fs *accessControlFs) inherit(path string, mode uint32, dacl Acl) {
+	if nil == dacl {
+		return
+	}
+	acl, amode := dacl.inherit(mode)
+	if amode&0777 != mode&0777 {
+		fs.FileSystemInterface.Chmod(path, amode)
+	}
+	if acl.extended() {
+		fs.FileSystemInterface.Setxattr(path, XATTR_POSIX_ACL_ACCESS, acl.Encode(), 0)
+	}
+	if S_IFDIR == mode&S_IFMT {
+		fs.FileSystemInterface.Setxattr(path, XATTR_POSIX_ACL_DEFAULT, dacl.Encode(), 0)
+	}
+}
+
+func (fs *accessControlFs) Mknod(path string, mode uint32, dev uint64) int {
+	if errc, _ := fs.context().checkParent(path); 0 != errc {
+		return errc
+	}
+	mode, dacl := fs.createMode(path, mode)
+	errc := fs.FileSystemInterface.Mknod(path, mode, dev)
+	if 0 == errc {
+		fs.inherit(path, mode, dacl)
+	}
+	return errc
+}
+
+func (fs *accessControlFs) Mkdir(path string, mode uint32) int {
+	if errc, _ := fs.context().checkParent(path); 0 != errc {
+		return errc
+	}
+	mode, dacl := fs.createMode(path, mode)
+	errc := fs.FileSystemInterface.Mkdir(path, mode)
+	if 0 == errc {
+		fs.inherit(path, S_IFDIR|mode, dacl)
+	}
+	return errc
+}
+
+func (fs *accessControlFs) Unlink(path string) int {
+	if errc := fs.context().checkRemove(path, true); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Unlink(path)
+}
+
+func (fs *accessControlFs) Rmdir(path string) int {
+	if errc := fs.context().checkRemove(path, true); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Rmdir(path)
+}
+
+func (fs *accessControlFs) Link(oldpath string, newpath string) int {
+	ctx := fs.context()
+	if errc, _ := ctx.check(oldpath, 0); 0 != errc {
+		return errc
+	}
+	if errc, _ := ctx.checkParent(newpath); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (fs *accessControlFs) Symlink(target string, newpath string) int {
+	if errc, _ := fs.context().checkParent(newpath); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (fs *accessControlFs) Readlink(path string) (int, string) {
+	if errc, _ := fs.context().check(path, 0); 0 != errc {
+		return errc, ""
+	}
+	return fs.FileSystemInterface.Readlink(path)
+}
+
+func (fs *accessControlFs) Rename(oldpath string, newpath string) int {
+	ctx := fs.context()
+	if errc := ctx.checkRemove(oldpath, true); 0 != errc {
+		return errc
+	}
+	if errc := ctx.checkRemove(newpath, false); 0 != errc {
+		return errc
+	}
+	if pathutil.Dir(oldpath) != pathutil.Dir(newpath) {
+		// moving a directory to a new parent modifies its ".." entry
+		stat := Stat_t{}
+		if 0 == fs.FileSystemInterface.Getattr(oldpath, &stat, ^uint64(0)) &&
+			S_IFDIR == stat.Mode&S_IFMT {
+			if errc := ctx.permits(oldpath, &stat, W_OK); 0 != errc {
+				return errc
+			}
+		}
+	}
+	return fs.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (fs *accessControlFs) Chmod(path string, mode uint32) int {
+	ctx := fs.context()
+	errc, stat := ctx.checkOwner(path)
+	if 0 != errc {
+		return errc
+	}
+	if 0 != ctx.uid && !ctx.member(stat.Gid) {
+		mode &^= S_ISGID
+	}
+	errc = fs.FileSystemInterface.Chmod(path, mode)
+	if 0 == errc {
+		if acl := fs.acl(path, XATTR_POSIX_ACL_ACCESS); nil != acl {
+			fs.FileSystemInterface.Setxattr(path, XATTR_POSIX_ACL_ACCESS, acl.Chmod(mode).Encode(), 0)
+		}
+	}
+	return errc
+}
+
+func (fs *accessControlFs) Chown(path string, uid uint32, gid uint32) int {
+	ctx := fs.context()
+	errc, stat := ctx.check(path, 0)
+	if 0 != errc {
+		return errc
+	}
+	if 0 != ctx.uid {
+		if ^uint32(0) != uid && stat.Uid != uid {
+			return -EPERM
+		}
+		if ^uint32(0) != gid && stat.Gid != gid && (ctx.uid != stat.Uid || !ctx.member(gid)) {
+			return -EPERM
+		}
+	}
+	return fs.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (fs *accessControlFs) Utimens(path string, tmsp []Timespec) int {
+	ctx := fs.context()
+	errc, stat := ctx.check(path, 0)
+	if 0 != errc {
+		return errc
+	}
+	if !ctx.owns(stat) {
+		// setting the times to the current time only requires write permission
+		if nil != tmsp && !(2 == len(tmsp) &&
+			accessUtimeNow == tmsp[0].Nsec && accessUtimeNow == tmsp[1].Nsec) {
+			return -EPERM
+		}
+		if errc := ctx.permits(path, stat, W_OK); 0 != errc {
+			return errc
+		}
+	}
+	return fs.FileSystemInterface.Utimens(path, tmsp)
+}
+
+// accessUtimeNow is the value of UTIME_NOW on Linux.
+const accessUtimeNow = 1<<30 - 1
+
+func (fs *accessControlFs) Access(path string, mask uint32) int {
+	if errc, _ := fs.context().check(path, mask); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Access(path, mask)
+}
+
+func (fs *accessControlFs) Create(path string, flags int, mode uint32) (int, uint64) {
+	ctx := fs.context()
+	errc, stat := ctx.check(path, accessOpenMask(flags))
+	if -ENOENT == errc {
+		errc, _ = ctx.checkParent(path)
+	} else if 0 == errc && S_IFDIR == stat.Mode&S_IFMT {
+		errc = -EISDIR
+	}
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	mode, dacl := fs.createMode(path, mode)
+	errc, fh := fs.FileSystemInterface.Create(path, flags, mode)
+	if 0 == errc {
+		fs.inherit(path, S_IFREG|mode, dacl)
+	}
+	return errc, fh
+}
+
+func accessOpenMask(flags int) uint32 {
+	mask := uint32(0)
+	switch flags & O_ACCMODE {
+	case O_RDONLY:
+		mask = R_OK
+	case O_WRONLY:
+		mask = W_OK
+	default:
+		mask = R_OK | W_OK
+	}
+	if 0 != flags&O_TRUNC {
+		mask |= W_OK
+	}
+	return mask
+}
+
+func (fs *accessControlFs) Open(path string, flags int) (int, uint64) {
+	if errc, _ := fs.context().check(path, accessOpenMask(flags)); 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
+func (fs *accessControlFs) Getattr(path string, stat *Stat_t, fh uint64) int {
+	if ^uint64(0) == fh && "/" != path {
+		dir := Stat_t{}
+		ctx := fs.context()
+		if errc := ctx.lookup(pathutil.Dir(path), &dir); 0 != errc {
+			return errc
+		}
+		if errc := ctx.permits(pathutil.Dir(path), &dir, X_OK); 0 != errc {
+			return errc
+		}
+	}
+	return fs.FileSystemInterface.Getattr(path, stat, fh)
+}
+
+func (fs *accessControlFs) Truncate(path string, size int64, fh uint64) int {
+	if ^uint64(0) == fh {
+		if errc, _ := fs.context().check(path, W_OK); 0 != errc {
+			return errc
+		}
+	}
+	return fs.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (fs *accessControlFs) Opendir(path string) (int, uint64) {
+	if errc, _ := fs.context().check(path, R_OK); 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return fs.FileSystemInterface.Opendir(path)
+}
+
+// checkXattr checks access to the extended attribute name of path. Attributes in the
+// "user." namespace require read or write permission; attributes in the "trusted."
+// and "security." namespaces can only be modified by the user ID 0.
+func (fs *accessControlFs) checkXattr(path string, name string, write bool) int {
+	ctx := fs.context()
+	mask := uint32(R_OK)
+	if write {
+		mask = W_OK
+	}
+	switch {
+	case fs.opts.Acl && (XATTR_POSIX_ACL_ACCESS == name || XATTR_POSIX_ACL_DEFAULT == name):
+		if !write {
+			errc, _ := ctx.check(path, 0)
+			return errc
+		}
+		errc, _ := ctx.checkOwner(path)
+		return errc
+	case strings.HasPrefix(name, "user."):
+		errc, _ := ctx.check(path, mask)
+		return errc
+	case strings.HasPrefix(name, "trusted."):
+		errc, _ := ctx.check(path, 0)
+		if 0 == errc && 0 != ctx.uid {
+			errc = -EPERM
+		}
+		return errc
+	case strings.HasPrefix(name, "security."):
+		errc, _ := ctx.check(path, 0)
+		if 0 == errc && write && 0 != ctx.uid {
+			errc = -EPERM
+		}
+		return errc
+	default:
+		errc, _ := ctx.check(path, 0)
+		return errc
+	}
+}
+
+func (fs *accessControlFs) Setxattr(path string, name string, value []byte, flags int) int {
+	if errc := fs.checkXattr(path, name, true); 0 != errc {
+		return errc
+	}
+	if fs.opts.Acl && (XATTR_POSIX_ACL_ACCESS == name || XATTR_POSIX_ACL_DEFAULT == name) {
+		return fs.setacl(path, name, value, flags)
+	}
+	return fs.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+// setacl validates and sets the ACL in the extended attribute name of path. Setting the
+// access ACL also sets the permission bits of path.
+func (fs *accessControlFs) setacl(path string, name string, value []byte, flags int) int {
+	acl, err := AclDecode(value)
+	if nil != err {
+		return -EINVAL
+	}
+	stat := Stat_t{}
+	if errc := fs.FileSystemInterface.Getattr(path, &stat, ^uint64(0)); 0 != errc {
+		return errc
+	}
+	if XATTR_POSIX_ACL_DEFAULT == name {
+		if S_IFDIR != stat.Mode&S_IFMT {
+			return -EACCES
+		}
+		return fs.FileSystemInterface.Setxattr(path, name, acl.Encode(), flags)
+	}
+	if mode := stat.Mode&^0777 | acl.Mode(); mode != stat.Mode {
+		if errc := fs.FileSystemInterface.Chmod(path, mode); 0 != errc {
+			return errc
+		}
+	}
+	if !acl.extended() {
+		// the ACL is fully represented by the permission bits
+		errc := fs.FileSystemInterface.Removexattr(path, name)
+		if -ENOATTR == errc {
+			errc = 0
+		}
+		return errc
+	}
+	return fs.FileSystemInterface.Setxattr(path, name, acl.Encode(), flags)
+}
+
+func (fs *accessControlFs) Getxattr(path string, name string) (int, []byte) {
+	if errc := fs.checkXattr(path, name, false); 0 != errc {
+		return errc, nil
+	}
+	return fs.FileSystemInterface.Getxattr(path, name)
+}
+
+func (fs *accessControlFs) Removexattr(path string, name string) int {
+	if errc := fs.checkXattr(path, name, true); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Removexattr(path, name)
+}
+
+func (fs *accessControlFs) Listxattr(path string, fill func(name string) bool) int {
+	if errc, _ := fs.context().check(path, 0); 0 != errc {
+		return errc
+	}
+	return fs.FileSystemInterface.Listxattr(path, fill)
+}
+
+func (fs *accessControlFs) Chflags(path string, flags uint32) int {
+	if errc, _ := fs.context().checkOwner(path); 0 != errc {
+		return errc
+	}
+	return fsChflags(fs.FileSystemInterface, path, flags)
+}
+
+func (fs *accessControlFs) Setcrtime(path string, tmsp Timespec) int {
+	if errc, _ := fs.context().checkOwner(path); 0 != errc {
+		return errc
+	}
+	return fsSetcrtime(fs.FileSystemInterface, path, tmsp)
+}
+
+func (fs *accessControlFs) Setchgtime(path string, tmsp Timespec) int {
+	if errc, _ := fs.context().checkOwner(path); 0 != errc {
+		return errc
+	}
+	return fsSetchgtime(fs.FileSystemInterface, path, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*accessControlFs)(nil)
+	_ FileSystemChflags    = (*accessControlFs)(nil)
+	_ FileSystemSetcrtime  = (*accessControlFs)(nil)
+	_ FileSystemSetchgtime = (*accessControlFs)(nil)
+)
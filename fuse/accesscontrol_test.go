@@ -0,0 +1,141 @@
+/*
+ * accesscontrol_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"testing"
+)
+
+func TestAccessControl(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/home", S_IFDIR|0755, "")
+	tfs.create("/home/alice", S_IFDIR|0750, "")
+	tfs.create("/home/alice/file", S_IFREG|0640, "hello")
+	tfs.create("/home/alice/script", S_IFREG|0750, "")
+	tfs.create("/tmp", S_IFDIR|S_ISVTX|0777, "")
+	tfs.create("/tmp/bob", S_IFREG|0666, "")
+	tfs.create("/tmp/alice", S_IFREG|0666, "")
+	tfs.Chown("/home/alice", 1000, 1000)
+	tfs.Chown("/home/alice/file", 1000, 100)
+	tfs.Chown("/home/alice/script", 1000, 1000)
+	tfs.Chown("/tmp/bob", 1001, 1001)
+	tfs.Chown("/tmp/alice", 1000, 1000)
+
+	uid, gid := uint32(0), uint32(0)
+	groups := map[uint32][]uint32{1001: {100}}
+	fs := AccessControl(tfs, AccessControlOptions{
+		Getcontext: func() (uint32, uint32, int) {
+			return uid, gid, 1
+		},
+		Groups: func(uid uint32, gid uint32, pid int) []uint32 {
+			return groups[uid]
+		},
+	})
+
+	as := func(u uint32) {
+		uid, gid = u, u
+	}
+
+	// alice
+	as(1000)
+	stat := Stat_t{}
+	if errc := fs.Getattr("/home/alice/file", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(fs, "/home/alice/file"); 0 != errc || "hello" != data {
+		t.Error(errc, data)
+	}
+	if errc := fs.Access("/home/alice/script", X_OK); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Mkdir("/home/alice/dir", 0755); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Mkdir("/home/dir", 0755); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Unlink("/tmp/bob"); -EPERM != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Rename("/tmp/alice", "/tmp/bob"); -EPERM != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Chown("/home/alice/file", 1001, ^uint32(0)); -EPERM != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Chown("/home/alice/file", ^uint32(0), 1001); -EPERM != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Chmod("/tmp/bob", 0777); -EPERM != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Utimens("/tmp/bob", nil); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Utimens("/tmp/bob", []Timespec{{}, {}}); -EPERM != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Setxattr("/tmp/bob", "trusted.x", nil, 0); -EPERM != errc {
+		t.Error(errc)
+	}
+
+	// bob: member of group 100 through supplementary groups
+	as(1001)
+	if errc, data := testReadFile(fs, "/home/alice/file"); -EACCES != errc {
+		t.Error(errc, data)
+	}
+	if errc := fs.Getattr("/home/alice/file", &stat, ^uint64(0)); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Chmod("/home/alice", 0755); -EPERM != errc {
+		t.Error(errc)
+	}
+	as(1000)
+	if errc := fs.Chmod("/home/alice", 0751); 0 != errc {
+		t.Error(errc)
+	}
+	as(1001)
+	if errc, data := testReadFile(fs, "/home/alice/file"); 0 != errc || "hello" != data {
+		t.Error(errc, data)
+	}
+	if errc := testWriteFile(fs, "/home/alice/file", "x", 0); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Truncate("/home/alice/file", 0, ^uint64(0)); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc, _ := testReaddir(fs, "/home/alice"); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Access("/home/alice/file", R_OK|W_OK); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Unlink("/tmp/bob"); 0 != errc {
+		t.Error(errc)
+	}
+
+	// root
+	as(0)
+	if errc := fs.Access("/home/alice/file", R_OK|W_OK); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Access("/home/alice/file", X_OK); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Chown("/home/alice/file", 1001, 1001); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Unlink("/tmp/alice"); 0 != errc {
+		t.Error(errc)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * acl.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Names of the extended attributes that hold POSIX ACLs on Linux.
+const (
+	XATTR_POSIX_ACL_ACCESS  = "system.posix_acl_access"
+	XATTR_POSIX_ACL_DEFAULT = "system.posix_acl_default"
+)
+
+// POSIX ACL entry tags.
+const (
+	ACL_USER_OBJ  = 0x01
+	ACL_USER      = 0x02
+	ACL_GROUP_OBJ = 0x04
+	ACL_GROUP     = 0x08
+	ACL_MASK      = 0x10
+	ACL_OTHER     = 0x20
+)
+
+// ACL_UNDEFINED_ID is the Id of ACL entries other than ACL_USER and ACL_GROUP.
+const ACL_UNDEFINED_ID = ^uint32(0)
+
+const aclXattrVersion = 2
+
+// AclEntry is an entry of a POSIX ACL.
+type AclEntry struct {
+	// Entry tag (ACL_USER_OBJ, ACL_USER, etc.)
+	Tag uint16
+
+	// Permissions (a combination of R_OK, W_OK, X_OK).
+	Perm uint16
+
+	// User or group ID for ACL_USER and ACL_GROUP entries; ACL_UNDEFINED_ID otherwise.
+	Id uint32
+}
+
+// Acl is a POSIX ACL.
+type Acl []AclEntry
+
+// AclDecode decodes a POSIX ACL from the binary format used in the Linux
+// system.posix_acl_access and system.posix_acl_default extended attributes.
+// The ACL is validated and returned sorted.
+func AclDecode(value []byte) (Acl, error) {
+	if 4 > len(value) || 0 != (len(value)-4)%8 {
+		return nil, errors.New("AclDecode: invalid size")
+	}
+	if aclXattrVersion != binary.LittleEndian.Uint32(value) {
+		return nil, errors.New("AclDecode: invalid version")
+	}
+	acl := Acl{}
+	for p := value[4:]; 0 < len(p); p = p[8:] {
+		acl = append(acl, AclEntry{
+			Tag:  binary.LittleEndian.Uint16(p),
+			Perm: binary.LittleEndian.Uint16(p[2:]),
+			Id:   binary.LittleEndian.Uint32(p[4:]),
+		})
+	}
+	acl.sort()
+	if err := acl.Valid(); nil != err {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// Encode encodes the ACL in the binary format used in the Linux
+// system.posix_acl_access and system.posix_acl_default extended attributes.
+func (acl Acl) Encode() []byte {
+	value := make([]byte, 4+8*len(acl))
+	binary.LittleEndian.PutUint32(value, aclXattrVersion)
+	p := value[4:]
+	for _, e := range acl {
+		binary.LittleEndian.PutUint16(p, e.Tag)
+		binary.LittleEndian.PutUint16(p[2:], e.Perm)
+		binary.LittleEndian.PutUint32(p[4:], e.Id)
+		p = p[8:]
+	}
+	return value
+}
+
+func (acl Acl) sort() {
+	sort.SliceStable(acl, func(i, j int) bool {
+		if acl[i].Tag != acl[j].Tag {
+			return acl[i].Tag < acl[j].Tag
+		}
+		return acl[i].Id < acl[j].Id
+	})
+}
+
+// Valid checks that a sorted ACL contains exactly one ACL_USER_OBJ, ACL_GROUP_OBJ and
+// ACL_OTHER entry, unique ACL_USER and ACL_GROUP entries, and an ACL_MASK entry if
+// there are any ACL_USER or ACL_GROUP entries.
+func (acl Acl) Valid() error {
+	count := map[uint16]int{}
+	named := false
+	for i, e := range acl {
+		if 0 != e.Perm&^7 {
+			return errors.New("Acl: invalid permissions")
+		}
+		switch e.Tag {
+		case ACL_USER, ACL_GROUP:
+			if ACL_UNDEFINED_ID == e.Id ||
+				(0 < i && acl[i-1].Tag == e.Tag && acl[i-1].Id == e.Id) {
+				return errors.New("Acl: invalid user or group entry")
+			}
+			named = true
+		case ACL_USER_OBJ, ACL_GROUP_OBJ, ACL_MASK, ACL_OTHER:
+			count[e.Tag]++
+		default:
+			return errors.New("Acl: invalid tag")
+		}
+	}
+	if 1 != count[ACL_USER_OBJ] || 1 != count[ACL_GROUP_OBJ] || 1 != count[ACL_OTHER] ||
+		1 < count[ACL_MASK] || (named && 0 == count[ACL_MASK]) {
+		return errors.New("Acl: invalid entries")
+	}
+	return nil
+}
+
+// AclFromMode returns the minimal ACL that is equivalent to the permission bits of mode.
+func AclFromMode(mode uint32) Acl {
+	return Acl{
+		{ACL_USER_OBJ, uint16(mode>>6) & 7, ACL_UNDEFINED_ID},
+		{ACL_GROUP_OBJ, uint16(mode>>3) & 7, ACL_UNDEFINED_ID},
+		{ACL_OTHER, uint16(mode) & 7, ACL_UNDEFINED_ID},
+	}
+}
+
+// Mode returns the permission bits that correspond to the ACL. The group bits are
+// those of the ACL_MASK entry if present or of the ACL_GROUP_OBJ entry otherwise.
+func (acl Acl) Mode() uint32 {
+	var user, group, mask, other uint32
+	hasMask := false
+	for _, e := range acl {
+		switch e.Tag {
+		case ACL_USER_OBJ:
+			user = uint32(e.Perm)
+		case ACL_GROUP_OBJ:
+			group = uint32(e.Perm)
+		case ACL_MASK:
+			mask, hasMask = uint32(e.Perm), true
+		case ACL_OTHER:
+			other = uint32(e.Perm)
+		}
+	}
+	if hasMask {
+		group = mask
+	}
+	return user<<6 | group<<3 | other
+}
+
+// Chmod returns a copy of the ACL whose ACL_USER_OBJ, ACL_OTHER and ACL_MASK (or
+// ACL_GROUP_OBJ if there is no ACL_MASK) entries are set from the permission bits of
+// mode.
+func (acl Acl) Chmod(mode uint32) Acl {
+	hasMask := false
+	for _, e := range acl {
+		if ACL_MASK == e.Tag {
+			hasMask = true
+		}
+	}
+	res := append(Acl{}, acl...)
+	for i, e := range res {
+		switch {
+		case ACL_USER_OBJ == e.Tag:
+			res[i].Perm = uint16(mode>>6) & 7
+		case ACL_MASK == e.Tag || (ACL_GROUP_OBJ == e.Tag && !hasMask):
+			res[i].Perm = uint16(mode>>3) & 7
+		case ACL_OTHER == e.Tag:
+			res[i].Perm = uint16(mode) & 7
+		}
+	}
+	return res
+}
+
+// inherit returns the access ACL and permission bits of a file created with mode in
+// a directory with the default ACL acl.
+func (acl Acl) inherit(mode uint32) (Acl, uint32) {
+	hasMask := false
+	for _, e := range acl {
+		if ACL_MASK == e.Tag {
+			hasMask = true
+		}
+	}
+	res := append(Acl{}, acl...)
+	for i, e := range res {
+		switch {
+		case ACL_USER_OBJ == e.Tag:
+			res[i].Perm &= uint16(mode>>6) & 7
+		case ACL_MASK == e.Tag || (ACL_GROUP_OBJ == e.Tag && !hasMask):
+			res[i].Perm &= uint16(mode>>3) & 7
+		case ACL_OTHER == e.Tag:
+			res[i].Perm &= uint16(mode) & 7
+		}
+	}
+	return res, mode&^0777 | res.Mode()
+}
+
+// extended reports whether the ACL contains entries that cannot be represented by
+// permission bits.
+func (acl Acl) extended() bool {
+	return 3 < len(acl)
+}
+
+// permits checks the access mask against the ACL for the owner uid and group gid of
+// a file, using the POSIX ACL access check algorithm.
+func (acl Acl) permits(ctx *accessContext, uid uint32, gid uint32, mask uint32) bool {
+	mask &= R_OK | W_OK | X_OK
+	perm := uint32(7)
+	for _, e := range acl {
+		if ACL_MASK == e.Tag {
+			perm = uint32(e.Perm)
+		}
+	}
+	for _, e := range acl {
+		switch e.Tag {
+		case ACL_USER_OBJ:
+			if ctx.uid == uid {
+				return 0 == mask&^uint32(e.Perm)
+			}
+		case ACL_USER:
+			if ctx.uid == e.Id {
+				return 0 == mask&^(uint32(e.Perm)&perm)
+			}
+		}
+	}
+	found := false
+	for _, e := range acl {
+		var id uint32
+		switch e.Tag {
+		case ACL_GROUP_OBJ:
+			id = gid
+		case ACL_GROUP:
+			id = e.Id
+		default:
+			continue
+		}
+		if ctx.member(id) {
+			if 0 == mask&^(uint32(e.Perm)&perm) {
+				return true
+			}
+			found = true
+		}
+	}
+	if found {
+		return false
+	}
+	for _, e := range acl {
+		if ACL_OTHER == e.Tag {
+			return 0 == mask&^uint32(e.Perm)
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+/*
+ * acl_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAclEncode(t *testing.T) {
+	value := []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x06, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0x00, 0x04, 0x00, 0xe9, 0x03, 0x00, 0x00,
+		0x04, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x10, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff,
+	}
+	acl, err := AclDecode(value)
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := Acl{
+		{ACL_USER_OBJ, 6, ACL_UNDEFINED_ID},
+		{ACL_USER, 4, 1001},
+		{ACL_GROUP_OBJ, 4, ACL_UNDEFINED_ID},
+		{ACL_MASK, 4, ACL_UNDEFINED_ID},
+		{ACL_OTHER, 0, ACL_UNDEFINED_ID},
+	}
+	if len(expect) != len(acl) {
+		t.Fatal(acl)
+	}
+	for i := range acl {
+		if expect[i] != acl[i] {
+			t.Error(i, acl[i])
+		}
+	}
+	if !bytes.Equal(value, acl.Encode()) {
+		t.Error(acl.Encode())
+	}
+	if 0640 != acl.Mode() {
+		t.Errorf("%o", acl.Mode())
+	}
+	if 0751 != acl.Chmod(0751).Mode() {
+		t.Errorf("%o", acl.Chmod(0751).Mode())
+	}
+	if 0644 != AclFromMode(0644).Mode() {
+		t.Errorf("%o", AclFromMode(0644).Mode())
+	}
+
+	for _, bad := range []Acl{
+		{},
+		{{ACL_USER_OBJ, 6, ACL_UNDEFINED_ID}, {ACL_GROUP_OBJ, 4, ACL_UNDEFINED_ID}},
+		{{ACL_USER_OBJ, 6, ACL_UNDEFINED_ID}, {ACL_USER, 4, 1001},
+			{ACL_GROUP_OBJ, 4, ACL_UNDEFINED_ID}, {ACL_OTHER, 0, ACL_UNDEFINED_ID}},
+		{{ACL_USER_OBJ, 8, ACL_UNDEFINED_ID}, {ACL_GROUP_OBJ, 4, ACL_UNDEFINED_ID},
+			{ACL_OTHER, 0, ACL_UNDEFINED_ID}},
+	} {
+		if _, err := AclDecode(bad.Encode()); nil == err {
+			t.Error(bad)
+		}
+	}
+	if _, err := AclDecode(value[:len(value)-1]); nil == err {
+		t.Error()
+	}
+}
+
+func TestAclAccessControl(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	tfs.create("/dir/file", S_IFREG|0600, "hello")
+	tfs.create("/plain", S_IFDIR|0777, "")
+	tfs.Chown("/dir", 1000, 1000)
+	tfs.Chown("/dir/file", 1000, 1000)
+
+	uid := uint32(1000)
+	fs := AccessControl(tfs, AccessControlOptions{
+		Getcontext: func() (uint32, uint32, int) {
+			return uid, uid, 1
+		},
+		Groups: func(uid uint32, gid uint32, pid int) []uint32 {
+			return nil
+		},
+		Acl: true,
+		Umask: func() uint32 {
+			return 022
+		},
+	})
+
+	acl := Acl{
+		{ACL_USER_OBJ, 6, ACL_UNDEFINED_ID},
+		{ACL_USER, 6, 1001},
+		{ACL_GROUP_OBJ, 0, ACL_UNDEFINED_ID},
+		{ACL_MASK, 4, ACL_UNDEFINED_ID},
+		{ACL_OTHER, 0, ACL_UNDEFINED_ID},
+	}
+	uid = 1001
+	if errc := fs.Setxattr("/dir/file", XATTR_POSIX_ACL_ACCESS, acl.Encode(), 0); -EPERM != errc {
+		t.Error(errc)
+	}
+	uid = 1000
+	if errc := fs.Setxattr("/dir/file", XATTR_POSIX_ACL_ACCESS, []byte{1, 2, 3}, 0); -EINVAL != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Setxattr("/dir/file", XATTR_POSIX_ACL_ACCESS, acl.Encode(), 0); 0 != errc {
+		t.Error(errc)
+	}
+	stat := Stat_t{}
+	if errc := fs.Getattr("/dir/file", &stat, ^uint64(0)); 0 != errc || S_IFREG|0640 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+
+	uid = 1001
+	if errc := fs.Access("/dir/file", R_OK); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Access("/dir/file", W_OK); -EACCES != errc {
+		t.Error(errc)
+	}
+	uid = 1002
+	if errc := fs.Access("/dir/file", R_OK); -EACCES != errc {
+		t.Error(errc)
+	}
+
+	uid = 1000
+	if errc := fs.Chmod("/dir/file", 0600); 0 != errc {
+		t.Error(errc)
+	}
+	uid = 1001
+	if errc, _ := fs.Open("/dir/file", O_RDONLY); -EACCES != errc {
+		t.Error(errc)
+	}
+
+	dacl := Acl{
+		{ACL_USER_OBJ, 7, ACL_UNDEFINED_ID},
+		{ACL_USER, 7, 1001},
+		{ACL_GROUP_OBJ, 5, ACL_UNDEFINED_ID},
+		{ACL_MASK, 7, ACL_UNDEFINED_ID},
+		{ACL_OTHER, 0, ACL_UNDEFINED_ID},
+	}
+	uid = 1000
+	if errc := fs.Setxattr("/dir/file", XATTR_POSIX_ACL_DEFAULT, dacl.Encode(), 0); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Setxattr("/dir", XATTR_POSIX_ACL_DEFAULT, dacl.Encode(), 0); 0 != errc {
+		t.Error(errc)
+	}
+	errc, fh := fs.Create("/dir/new", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Release("/dir/new", fh)
+	if errc := fs.Getattr("/dir/new", &stat, ^uint64(0)); 0 != errc || S_IFREG|0640 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+	if errc := fs.Mkdir("/dir/sub", 0777); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, value := fs.Getxattr("/dir/sub", XATTR_POSIX_ACL_DEFAULT); 0 != errc ||
+		!bytes.Equal(dacl.Encode(), value) {
+		t.Error(errc, value)
+	}
+
+	// the umask is ignored when a default ACL is inherited and applied otherwise
+	if errc := fs.Getattr("/dir/sub", &stat, ^uint64(0)); 0 != errc || S_IFDIR|0770 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+	if errc := fs.Mkdir("/plain/sub", 0777); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/plain/sub", &stat, ^uint64(0)); 0 != errc || S_IFDIR|0755 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+	uid = 1001
+	if errc := fs.Access("/dir/new", R_OK); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Access("/dir/new", W_OK); -EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Access("/dir/sub", R_OK|W_OK|X_OK); 0 != errc {
+		t.Error(errc)
+	}
+}
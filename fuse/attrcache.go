@@ -0,0 +1,391 @@
+/*
+ * attrcache.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	pathutil "path"
+	"sync"
+	"time"
+)
+
+// AttrCacheOptions contains options for an AttrCache.
+type AttrCacheOptions struct {
+	// Time for which file attributes are cached. Zero means 1 second; negative
+	// disables caching of file attributes.
+	AttrTimeout time.Duration
+
+	// Time for which directory listings are cached. Zero means 1 second; negative
+	// disables caching of directory listings.
+	DirTimeout time.Duration
+
+	// Time for which nonexistent paths are cached. Zero or negative disables
+	// negative caching.
+	NegativeTimeout time.Duration
+}
+
+type attrCacheEntry struct {
+	errc   int // 0 or -ENOENT
+	stat   Stat_t
+	expire time.Time
+}
+
+type dirCacheEntry struct {
+	name string
+	stat *Stat_t
+}
+
+type dirCache struct {
+	entries []dirCacheEntry
+	expire  time.Time
+}
+
+// AttrCache is a file system that caches the file attributes and directory listings
+// of another file system. File attributes returned by Getattr, nonexistent paths and
+// complete directory listings returned by Readdir are cached per path for configurable
+// times.
+//
+// Mutating operations through the AttrCache invalidate the affected paths. Changes
+// made to the underlying file system by other means must be reported by calling
+// Invalidate.
+type AttrCache struct {
+	FileSystemInterface
+	opts  AttrCacheOptions
+	lock  sync.Mutex
+	attrs map[string]*attrCacheEntry
+	dirs  map[string]*dirCache
+	kids  map[string]map[string]bool // paths below which entries are cached
+	gen   uint64
+	sweep int
+}
+
+// NewAttrCache creates an AttrCache for the file system fs.
+func NewAttrCache(fs FileSystemInterface, opts AttrCacheOptions) *AttrCache {
+	if 0 == opts.AttrTimeout {
+		opts.AttrTimeout = time.Second
+	}
+	if 0 == opts.DirTimeout {
+		opts.DirTimeout = time.Second
+	}
+	return &AttrCache{
+		FileSystemInterface: fs,
+		opts:                opts,
+		attrs:               map[string]*attrCacheEntry{},
+		dirs:                map[string]*dirCache{},
+		kids:                map[string]map[string]bool{},
+		sweep:               1024,
+	}
+}
+
+// Invalidate removes the cached attributes and directory listing of path and of
+// everything below it, as well as the cached directory listing of its parent.
+func (cache *AttrCache) Invalidate(path string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.invalidate(path, true)
+	cache.invalidate(pathutil.Dir(path), false)
+}
+
+func (cache *AttrCache) invalidate(path string, tree bool) {
+	cache.gen++
+	delete(cache.attrs, path)
+	delete(cache.dirs, path)
+	if tree {
+		cache.invalidateKids(path)
+		if "/" != path {
+			delete(cache.kids[pathutil.Dir(path)], path)
+		}
+	}
+}
+
+func (cache *AttrCache) invalidateKids(path string) {
+	for p := range cache.kids[path] {
+		delete(cache.attrs, p)
+		delete(cache.dirs, p)
+		cache.invalidateKids(p)
+	}
+	delete(cache.kids, path)
+}
+
+// index records that an entry for path is cached. It must be called with the cache
+// locked.
+func (cache *AttrCache) index(path string) {
+	for "/" != path {
+		prnt := pathutil.Dir(path)
+		kids := cache.kids[prnt]
+		if nil == kids {
+			kids = map[string]bool{}
+			cache.kids[prnt] = kids
+		}
+		if kids[path] {
+			return
+		}
+		kids[path] = true
+		path = prnt
+	}
+}
+
+// changed invalidates path after a change to its attributes, as well as the cached
+// directory listing of its parent, which includes its attributes.
+func (cache *AttrCache) changed(path string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.invalidate(path, false)
+	delete(cache.dirs, pathutil.Dir(path))
+}
+
+// linked invalidates path and its parent after path is created or removed.
+func (cache *AttrCache) linked(path string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.invalidate(path, true)
+	cache.invalidate(pathutil.Dir(path), false)
+}
+
+// expunge removes expired entries once the cache has grown enough since it was last
+// swept. It must be called with the cache locked.
+func (cache *AttrCache) expunge(now time.Time) {
+	if len(cache.attrs)+len(cache.dirs) < cache.sweep {
+		return
+	}
+	for p, e := range cache.attrs {
+		if now.After(e.expire) {
+			delete(cache.attrs, p)
+		}
+	}
+	for p, d := range cache.dirs {
+		if now.After(d.expire) {
+			delete(cache.dirs, p)
+		}
+	}
+	cache.kids = map[string]map[string]bool{}
+	for p := range cache.attrs {
+		cache.index(p)
+	}
+	for p := range cache.dirs {
+		cache.index(p)
+	}
+	cache.sweep = 2*(len(cache.attrs)+len(cache.dirs)) + 1024
+}
+
+func (cache *AttrCache) Getattr(path string, stat *Stat_t, fh uint64) int {
+	cache.lock.Lock()
+	now := time.Now()
+	if e := cache.attrs[path]; nil != e && now.Before(e.expire) {
+		if 0 == e.errc {
+			*stat = e.stat
+		}
+		cache.lock.Unlock()
+		return e.errc
+	}
+	gen := cache.gen
+	cache.lock.Unlock()
+
+	errc := cache.FileSystemInterface.Getattr(path, stat, fh)
+
+	var timeout time.Duration
+	switch errc {
+	case 0:
+		timeout = cache.opts.AttrTimeout
+	case -ENOENT:
+		timeout = cache.opts.NegativeTimeout
+	}
+	if 0 < timeout {
+		cache.lock.Lock()
+		if gen == cache.gen {
+			cache.expunge(now)
+			cache.attrs[path] = &attrCacheEntry{errc: errc, stat: *stat, expire: now.Add(timeout)}
+			cache.index(path)
+		}
+		cache.lock.Unlock()
+	}
+	return errc
+}
+
+func (cache *AttrCache) Readdir(path string,
+	fill func(name string, stat *Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) int {
+	if 0 != ofst || 0 > cache.opts.DirTimeout {
+		return cache.FileSystemInterface.Readdir(path, fill, ofst, fh)
+	}
+
+	cache.lock.Lock()
+	now := time.Now()
+	if d := cache.dirs[path]; nil != d && now.Before(d.expire) {
+		cache.lock.Unlock()
+		for _, e := range d.entries {
+			var stat *Stat_t
+			if nil != e.stat {
+				s := *e.stat
+				stat = &s
+			}
+			if !fill(e.name, stat, 0) {
+				break
+			}
+		}
+		return 0
+	}
+	gen := cache.gen
+	cache.lock.Unlock()
+
+	entries := []dirCacheEntry{}
+	complete := true
+	errc := cache.FileSystemInterface.Readdir(path,
+		func(name string, stat *Stat_t, ofst int64) bool {
+			if 0 != ofst {
+				// offsets are not cached
+				complete = false
+			}
+			if complete {
+				var s *Stat_t
+				if nil != stat {
+					s = &Stat_t{}
+					*s = *stat
+				}
+				entries = append(entries, dirCacheEntry{name, s})
+			}
+			if !fill(name, stat, ofst) {
+				complete = false
+				return false
+			}
+			return true
+		},
+		ofst,
+		fh)
+	if 0 == errc && complete {
+		cache.lock.Lock()
+		if gen == cache.gen {
+			cache.expunge(now)
+			cache.dirs[path] = &dirCache{entries: entries, expire: now.Add(cache.opts.DirTimeout)}
+			cache.index(path)
+		}
+		cache.lock.Unlock()
+	}
+	return errc
+}
+
+func (cache *AttrCache) Mknod(path string, mode uint32, dev uint64) int {
+	defer cache.linked(path)
+	return cache.FileSystemInterface.Mknod(path, mode, dev)
+}
+
+func (cache *AttrCache) Mkdir(path string, mode uint32) int {
+	defer cache.linked(path)
+	return cache.FileSystemInterface.Mkdir(path, mode)
+}
+
+func (cache *AttrCache) Unlink(path string) int {
+	defer cache.linked(path)
+	return cache.FileSystemInterface.Unlink(path)
+}
+
+func (cache *AttrCache) Rmdir(path string) int {
+	defer cache.linked(path)
+	return cache.FileSystemInterface.Rmdir(path)
+}
+
+func (cache *AttrCache) Link(oldpath string, newpath string) int {
+	defer cache.linked(newpath)
+	defer cache.changed(oldpath)
+	return cache.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (cache *AttrCache) Symlink(target string, newpath string) int {
+	defer cache.linked(newpath)
+	return cache.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (cache *AttrCache) Rename(oldpath string, newpath string) int {
+	defer cache.linked(newpath)
+	defer cache.linked(oldpath)
+	return cache.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (cache *AttrCache) Chmod(path string, mode uint32) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Chmod(path, mode)
+}
+
+func (cache *AttrCache) Chown(path string, uid uint32, gid uint32) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (cache *AttrCache) Utimens(path string, tmsp []Timespec) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Utimens(path, tmsp)
+}
+
+func (cache *AttrCache) Create(path string, flags int, mode uint32) (int, uint64) {
+	defer cache.linked(path)
+	return cache.FileSystemInterface.Create(path, flags, mode)
+}
+
+func (cache *AttrCache) Open(path string, flags int) (int, uint64) {
+	if 0 != flags&(O_CREAT|O_TRUNC) {
+		defer cache.linked(path)
+	}
+	return cache.FileSystemInterface.Open(path, flags)
+}
+
+func (cache *AttrCache) Truncate(path string, size int64, fh uint64) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (cache *AttrCache) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Write(path, buff, ofst, fh)
+}
+
+func (cache *AttrCache) Flush(path string, fh uint64) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Flush(path, fh)
+}
+
+func (cache *AttrCache) Release(path string, fh uint64) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Release(path, fh)
+}
+
+func (cache *AttrCache) Setxattr(path string, name string, value []byte, flags int) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+func (cache *AttrCache) Removexattr(path string, name string) int {
+	defer cache.changed(path)
+	return cache.FileSystemInterface.Removexattr(path, name)
+}
+
+func (cache *AttrCache) Chflags(path string, flags uint32) int {
+	defer cache.changed(path)
+	return fsChflags(cache.FileSystemInterface, path, flags)
+}
+
+func (cache *AttrCache) Setcrtime(path string, tmsp Timespec) int {
+	defer cache.changed(path)
+	return fsSetcrtime(cache.FileSystemInterface, path, tmsp)
+}
+
+func (cache *AttrCache) Setchgtime(path string, tmsp Timespec) int {
+	defer cache.changed(path)
+	return fsSetchgtime(cache.FileSystemInterface, path, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*AttrCache)(nil)
+	_ FileSystemChflags    = (*AttrCache)(nil)
+	_ FileSystemSetcrtime  = (*AttrCache)(nil)
+	_ FileSystemSetchgtime = (*AttrCache)(nil)
+)
@@ -0,0 +1,173 @@
+/*
+ * attrcache_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttrCache(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	tfs.create("/dir/file", S_IFREG|0644, "hello")
+	fs := NewAttrCache(tfs, AttrCacheOptions{AttrTimeout: time.Hour, DirTimeout: time.Hour})
+
+	stat := Stat_t{}
+	for i := 0; 3 > i; i++ {
+		if errc := fs.Getattr("/dir/file", &stat, ^uint64(0)); 0 != errc || 5 != stat.Size {
+			t.Error(errc, stat.Size)
+		}
+		if errc, names := testReaddir(fs, "/dir"); 0 != errc || 1 != len(names) {
+			t.Error(errc, names)
+		}
+	}
+	if 1 != tfs.count("Getattr") || 1 != tfs.count("Readdir") {
+		t.Error(tfs.count("Getattr"), tfs.count("Readdir"))
+	}
+
+	if errc := testWriteFile(fs, "/dir/file", "world!", 0); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/dir/file", &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+
+	if errc := fs.Mkdir("/dir/sub", 0755); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, names := testReaddir(fs, "/dir"); 0 != errc || 2 != len(names) {
+		t.Error(errc, names)
+	}
+	if errc := fs.Rename("/dir", "/new"); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/dir/file", &stat, ^uint64(0)); -ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc, _ := testReaddir(fs, "/dir"); -ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/new/file", &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Chmod("/new/file", 0600); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/new/file", &stat, ^uint64(0)); 0 != errc || S_IFREG|0600 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+
+	if errc, names := testReaddir(fs, "/new"); 0 != errc || 2 != len(names) {
+		t.Error(errc, names)
+	}
+	tfs.create("/new/other", S_IFREG|0644, "")
+	if errc, names := testReaddir(fs, "/new"); 0 != errc || 2 != len(names) {
+		t.Error(errc, names)
+	}
+	fs.Invalidate("/new/other")
+	if errc, names := testReaddir(fs, "/new"); 0 != errc || 3 != len(names) {
+		t.Error(errc, names)
+	}
+}
+
+func TestAttrCacheReaddirStat(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	tfs.create("/dir/file", S_IFREG|0644, "hello")
+	fs := NewAttrCache(tfs, AttrCacheOptions{AttrTimeout: time.Hour, DirTimeout: time.Hour})
+
+	readdir := func() (mode uint32, size int64) {
+		fs.Readdir("/dir", func(name string, stat *Stat_t, ofst int64) bool {
+			if "file" == name && nil != stat {
+				mode, size = stat.Mode, stat.Size
+			}
+			return true
+		}, 0, ^uint64(0))
+		return
+	}
+	if mode, size := readdir(); S_IFREG|0644 != mode || 5 != size {
+		t.Errorf("%o %d", mode, size)
+	}
+	fs.Chmod("/dir/file", 0600)
+	if mode, _ := readdir(); S_IFREG|0600 != mode {
+		t.Errorf("%o", mode)
+	}
+	testWriteFile(fs, "/dir/file", "hello world", 0)
+	if _, size := readdir(); 11 != size {
+		t.Error(size)
+	}
+
+	// entries below uncached directories are invalidated along with their ancestors
+	tfs.create("/dir/sub", S_IFDIR|0755, "")
+	tfs.create("/dir/sub/deep", S_IFREG|0644, "")
+	stat := Stat_t{}
+	fs.Getattr("/dir/sub/deep", &stat, ^uint64(0))
+	if errc := fs.Rename("/dir", "/new"); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/dir/sub/deep", &stat, ^uint64(0)); -ENOENT != errc {
+		t.Error(errc)
+	}
+	if 0 != len(fs.kids["/dir"]) || fs.kids["/"]["/dir"] {
+		t.Error(fs.kids)
+	}
+}
+
+func TestAttrCacheNegative(t *testing.T) {
+	tfs := newTestFs()
+	fs := NewAttrCache(tfs, AttrCacheOptions{NegativeTimeout: time.Hour})
+
+	stat := Stat_t{}
+	for i := 0; 3 > i; i++ {
+		if errc := fs.Getattr("/file", &stat, ^uint64(0)); -ENOENT != errc {
+			t.Error(errc)
+		}
+	}
+	if 1 != tfs.count("Getattr") {
+		t.Error(tfs.count("Getattr"))
+	}
+	errc, fh := fs.Create("/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Error(errc)
+	}
+	fs.Release("/file", fh)
+	if errc := fs.Getattr("/file", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+
+	tfs.Unlink("/file")
+	if errc := fs.Getattr("/file", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+	fs.Invalidate("/")
+	if errc := fs.Getattr("/file", &stat, ^uint64(0)); -ENOENT != errc {
+		t.Error(errc)
+	}
+}
+
+func TestAttrCacheTimeout(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/file", S_IFREG|0644, "")
+	fs := NewAttrCache(tfs, AttrCacheOptions{AttrTimeout: time.Millisecond, DirTimeout: -1})
+
+	stat := Stat_t{}
+	fs.Getattr("/file", &stat, ^uint64(0))
+	time.Sleep(10 * time.Millisecond)
+	fs.Getattr("/file", &stat, ^uint64(0))
+	testReaddir(fs, "/")
+	testReaddir(fs, "/")
+	if 2 != tfs.count("Getattr") || 2 != tfs.count("Readdir") {
+		t.Error(tfs.count("Getattr"), tfs.count("Readdir"))
+	}
+}
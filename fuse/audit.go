@@ -0,0 +1,454 @@
+/*
+ * audit.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	pathutil "path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditRecord is a record of a mutating operation written by an Audit file system.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	Newpath string    `json:"newpath,omitempty"` // Link, Rename
+	Target  string    `json:"target,omitempty"`  // Symlink
+	Name    string    `json:"name,omitempty"`    // Setxattr, Removexattr
+	Mode    uint32    `json:"mode,omitempty"`    // Mknod, Mkdir, Chmod, Create
+	Flags   int       `json:"flags,omitempty"`   // Create, Open, Setxattr, Chflags
+	Owner   *uint32   `json:"owner,omitempty"`   // Chown
+	Group   *uint32   `json:"group,omitempty"`   // Chown
+	Ofst    int64     `json:"ofst,omitempty"`    // Write
+	Size    int64     `json:"size,omitempty"`    // Truncate, Write
+	Uid     uint32    `json:"uid"`
+	Gid     uint32    `json:"gid"`
+	Pid     int       `json:"pid"`
+	Exe     string    `json:"exe,omitempty"`
+	Errc    int       `json:"errc"`
+}
+
+// AuditOptions contains options for an Audit file system.
+type AuditOptions struct {
+	// Writer to which records are written. If nil records are appended to File.
+	Writer io.Writer
+
+	// Path of the file to which records are appended if Writer is nil.
+	File string
+
+	// Size at which File is rotated. Rotated files are named File.1, File.2, etc.
+	// Zero disables rotation.
+	MaxSize int64
+
+	// Number of rotated files kept. Zero means 1.
+	MaxFiles int
+
+	// Operations to record (e.g. "Write", "Unlink"). If empty all mutating operations
+	// are recorded.
+	Ops []string
+
+	// Path patterns (as in path.Match) of files whose operations are not recorded.
+	// Link and Rename are not recorded only if both paths are excluded.
+	Exclude []string
+
+	// Filter is called for every record that passes Ops and Exclude; the record is
+	// written only if Filter returns true. It may modify the record.
+	Filter func(rec *AuditRecord) bool
+
+	// Getcontext returns the user ID, group ID and process ID of the current operation.
+	// If nil the package function Getcontext is used.
+	Getcontext func() (uid uint32, gid uint32, pid int)
+
+	// Exe returns the executable of process pid. If nil the executable is resolved from
+	// /proc/PID/exe when available.
+	Exe func(pid int) string
+}
+
+// Audit is a file system that records the mutating operations performed on another
+// file system as JSON lines. Each record contains the operation and its arguments, the
+// user ID, group ID, process ID and executable of the caller, the result and the time
+// the operation started. Data and file handles are not recorded.
+type Audit struct {
+	FileSystemInterface
+	opts   AuditOptions
+	ops    map[string]bool
+	lock   sync.Mutex
+	writer io.Writer
+	file   *os.File
+	size   int64
+	err    error
+	closed bool
+}
+
+// NewAudit creates an Audit file system for the file system fs.
+func NewAudit(fs FileSystemInterface, opts AuditOptions) (*Audit, error) {
+	if nil == opts.Writer && "" == opts.File {
+		return nil, errors.New("NewAudit: no Writer or File")
+	}
+	for _, pattern := range opts.Exclude {
+		if _, err := pathutil.Match(pattern, ""); nil != err {
+			return nil, errors.New("NewAudit: invalid Exclude pattern " + pattern)
+		}
+	}
+	if nil == opts.Getcontext {
+		opts.Getcontext = Getcontext
+	}
+	if nil == opts.Exe {
+		opts.Exe = auditExe
+	}
+	if 0 >= opts.MaxFiles {
+		opts.MaxFiles = 1
+	}
+	audit := &Audit{
+		FileSystemInterface: fs,
+		opts:                opts,
+		writer:              opts.Writer,
+	}
+	if 0 < len(opts.Ops) {
+		audit.ops = map[string]bool{}
+		for _, op := range opts.Ops {
+			audit.ops[op] = true
+		}
+	}
+	if nil == audit.writer {
+		if err := audit.open(); nil != err {
+			return nil, err
+		}
+	}
+	return audit, nil
+}
+
+func auditExe(pid int) string {
+	exe, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+	if nil != err {
+		return ""
+	}
+	return exe
+}
+
+func (audit *Audit) open() error {
+	file, err := os.OpenFile(audit.opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if nil != err {
+		return err
+	}
+	info, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return err
+	}
+	audit.file, audit.writer, audit.size = file, file, info.Size()
+	return nil
+}
+
+// rotate renames File to File.1, File.1 to File.2, etc. and opens a new File. If File
+// cannot be renamed it is reopened and records continue to be appended to it. It must
+// be called with the audit locked.
+func (audit *Audit) rotate() error {
+	audit.file.Close()
+	audit.file, audit.writer = nil, nil
+	name := audit.opts.File
+	os.Remove(name + "." + strconv.Itoa(audit.opts.MaxFiles))
+	for i := audit.opts.MaxFiles - 1; 0 < i; i-- {
+		os.Rename(name+"."+strconv.Itoa(i), name+"."+strconv.Itoa(i+1))
+	}
+	err := os.Rename(name, name+".1")
+	if oerr := audit.open(); nil == err {
+		err = oerr
+	}
+	return err
+}
+
+// Close closes the audit log file. It does not close a Writer passed in AuditOptions.
+func (audit *Audit) Close() error {
+	audit.lock.Lock()
+	defer audit.lock.Unlock()
+	if nil == audit.file {
+		return nil
+	}
+	err := audit.file.Close()
+	audit.file, audit.writer = nil, nil
+	audit.closed = true
+	return err
+}
+
+// Err returns the last error encountered while writing records, if any.
+func (audit *Audit) Err() error {
+	audit.lock.Lock()
+	defer audit.lock.Unlock()
+	return audit.err
+}
+
+// excluded returns true if all paths are excluded by Exclude.
+func (audit *Audit) excluded(paths ...string) bool {
+	for _, path := range paths {
+		match := false
+		for _, pattern := range audit.opts.Exclude {
+			if ok, _ := pathutil.Match(pattern, path); ok {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// record starts a record for op on paths, the first of which is recorded as Path. It
+// returns nil if the operation is not recorded.
+func (audit *Audit) record(op string, paths ...string) *AuditRecord {
+	if nil != audit.ops && !audit.ops[op] {
+		return nil
+	}
+	if 0 < len(audit.opts.Exclude) && audit.excluded(paths...) {
+		return nil
+	}
+	rec := &AuditRecord{Time: time.Now(), Op: op, Path: paths[0]}
+	rec.Uid, rec.Gid, rec.Pid = audit.opts.Getcontext()
+	return rec
+}
+
+// done completes and writes the record rec.
+func (audit *Audit) done(rec *AuditRecord, errc int) {
+	if nil == rec {
+		return
+	}
+	rec.Errc = errc
+	rec.Exe = audit.opts.Exe(rec.Pid)
+	if nil != audit.opts.Filter && !audit.opts.Filter(rec) {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if nil != err {
+		return
+	}
+	line = append(line, '\n')
+
+	audit.lock.Lock()
+	defer audit.lock.Unlock()
+	if nil == audit.writer && "" != audit.opts.File && !audit.closed {
+		// the log file could not be reopened earlier
+		if err := audit.open(); nil != err {
+			audit.err = err
+		}
+	}
+	if nil != audit.file && 0 < audit.opts.MaxSize &&
+		0 < audit.size && audit.size+int64(len(line)) > audit.opts.MaxSize {
+		if err := audit.rotate(); nil != err {
+			audit.err = err
+		}
+	}
+	if nil == audit.writer {
+		return
+	}
+	n, err := audit.writer.Write(line)
+	audit.size += int64(n)
+	if nil != err {
+		audit.err = err
+	}
+}
+
+func (audit *Audit) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	rec := audit.record("Mknod", path)
+	if nil != rec {
+		rec.Mode = mode
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Mknod(path, mode, dev)
+}
+
+func (audit *Audit) Mkdir(path string, mode uint32) (errc int) {
+	rec := audit.record("Mkdir", path)
+	if nil != rec {
+		rec.Mode = mode
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Mkdir(path, mode)
+}
+
+func (audit *Audit) Unlink(path string) (errc int) {
+	rec := audit.record("Unlink", path)
+	if nil != rec {
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Unlink(path)
+}
+
+func (audit *Audit) Rmdir(path string) (errc int) {
+	rec := audit.record("Rmdir", path)
+	if nil != rec {
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Rmdir(path)
+}
+
+func (audit *Audit) Link(oldpath string, newpath string) (errc int) {
+	rec := audit.record("Link", oldpath, newpath)
+	if nil != rec {
+		rec.Newpath = newpath
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (audit *Audit) Symlink(target string, newpath string) (errc int) {
+	rec := audit.record("Symlink", newpath)
+	if nil != rec {
+		rec.Target = target
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (audit *Audit) Rename(oldpath string, newpath string) (errc int) {
+	rec := audit.record("Rename", oldpath, newpath)
+	if nil != rec {
+		rec.Newpath = newpath
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (audit *Audit) Chmod(path string, mode uint32) (errc int) {
+	rec := audit.record("Chmod", path)
+	if nil != rec {
+		rec.Mode = mode
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Chmod(path, mode)
+}
+
+func (audit *Audit) Chown(path string, uid uint32, gid uint32) (errc int) {
+	rec := audit.record("Chown", path)
+	if nil != rec {
+		if ^uint32(0) != uid {
+			rec.Owner = &uid
+		}
+		if ^uint32(0) != gid {
+			rec.Group = &gid
+		}
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (audit *Audit) Utimens(path string, tmsp []Timespec) (errc int) {
+	rec := audit.record("Utimens", path)
+	if nil != rec {
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Utimens(path, tmsp)
+}
+
+func (audit *Audit) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	rec := audit.record("Create", path)
+	if nil != rec {
+		rec.Flags, rec.Mode = flags, mode
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Create(path, flags, mode)
+}
+
+func (audit *Audit) Open(path string, flags int) (errc int, fh uint64) {
+	// only opens that may modify the file are recorded
+	if O_RDONLY != flags&O_ACCMODE || 0 != flags&O_TRUNC {
+		rec := audit.record("Open", path)
+		if nil != rec {
+			rec.Flags = flags
+			defer func() { audit.done(rec, errc) }()
+		}
+	}
+	return audit.FileSystemInterface.Open(path, flags)
+}
+
+func (audit *Audit) Truncate(path string, size int64, fh uint64) (errc int) {
+	rec := audit.record("Truncate", path)
+	if nil != rec {
+		rec.Size = size
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (audit *Audit) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	rec := audit.record("Write", path)
+	if nil != rec {
+		rec.Ofst, rec.Size = ofst, int64(len(buff))
+		defer func() {
+			if 0 > n {
+				audit.done(rec, n)
+			} else {
+				rec.Size = int64(n)
+				audit.done(rec, 0)
+			}
+		}()
+	}
+	return audit.FileSystemInterface.Write(path, buff, ofst, fh)
+}
+
+func (audit *Audit) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	rec := audit.record("Setxattr", path)
+	if nil != rec {
+		rec.Name, rec.Flags = name, flags
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+func (audit *Audit) Removexattr(path string, name string) (errc int) {
+	rec := audit.record("Removexattr", path)
+	if nil != rec {
+		rec.Name = name
+		defer func() { audit.done(rec, errc) }()
+	}
+	return audit.FileSystemInterface.Removexattr(path, name)
+}
+
+func (audit *Audit) Chflags(path string, flags uint32) (errc int) {
+	rec := audit.record("Chflags", path)
+	if nil != rec {
+		rec.Flags = int(flags)
+		defer func() { audit.done(rec, errc) }()
+	}
+	return fsChflags(audit.FileSystemInterface, path, flags)
+}
+
+func (audit *Audit) Setcrtime(path string, tmsp Timespec) (errc int) {
+	rec := audit.record("Setcrtime", path)
+	if nil != rec {
+		defer func() { audit.done(rec, errc) }()
+	}
+	return fsSetcrtime(audit.FileSystemInterface, path, tmsp)
+}
+
+func (audit *Audit) Setchgtime(path string, tmsp Timespec) (errc int) {
+	rec := audit.record("Setchgtime", path)
+	if nil != rec {
+		defer func() { audit.done(rec, errc) }()
+	}
+	return fsSetchgtime(audit.FileSystemInterface, path, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*Audit)(nil)
+	_ FileSystemChflags    = (*Audit)(nil)
+	_ FileSystemSetcrtime  = (*Audit)(nil)
+	_ FileSystemSetchgtime = (*Audit)(nil)
+)
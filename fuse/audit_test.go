@@ -0,0 +1,220 @@
+/*
+ * audit_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testAuditRecords(t *testing.T, data []byte) []AuditRecord {
+	recs := []AuditRecord{}
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	for scan.Scan() {
+		rec := AuditRecord{}
+		if err := json.Unmarshal(scan.Bytes(), &rec); nil != err {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func testAuditContext() (uint32, uint32, int) {
+	return 1000, 100, 42
+}
+
+func testAuditExe(pid int) string {
+	return "/usr/bin/test"
+}
+
+func TestAudit(t *testing.T) {
+	tfs := newTestFs()
+	buf := &bytes.Buffer{}
+	fs, err := NewAudit(tfs, AuditOptions{
+		Writer:     buf,
+		Exclude:    []string{"/tmp/*"},
+		Getcontext: testAuditContext,
+		Exe:        testAuditExe,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	fs.Mkdir("/dir", 0755)
+	fs.Mkdir("/tmp", 0755)
+	fs.Mkdir("/tmp/x", 0755)
+	testWriteFile(fs, "/dir/file", "hello", 0)
+	errc, fh := fs.Create("/dir/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Write("/dir/file", []byte("hello"), 0, fh)
+	fs.Release("/dir/file", fh)
+	testReadFile(fs, "/dir/file")
+	fs.Rename("/dir/file", "/dir/new")
+	fs.Chown("/dir/new", ^uint32(0), 100)
+	fs.Symlink("new", "/dir/link")
+	fs.Unlink("/dir/nonexistent")
+	fs.Mkdir("/tmp/y", 0755)
+	fs.Rename("/tmp/y", "/tmp/z")
+	fs.Rename("/tmp/x", "/dir/x")
+
+	recs := testAuditRecords(t, buf.Bytes())
+	ops := []string{}
+	for _, rec := range recs {
+		ops = append(ops, rec.Op)
+	}
+	expect := "Mkdir Mkdir Open Create Write Rename Chown Symlink Unlink Rename"
+	if expect != strings.Join(ops, " ") {
+		t.Fatal(ops)
+	}
+	if r := recs[0]; "/dir" != r.Path || 0755 != r.Mode || 0 != r.Errc ||
+		1000 != r.Uid || 100 != r.Gid || 42 != r.Pid || "/usr/bin/test" != r.Exe || r.Time.IsZero() {
+		t.Error(r)
+	}
+	if r := recs[2]; "/dir/file" != r.Path || -ENOENT != r.Errc {
+		t.Error(r)
+	}
+	if r := recs[4]; 0 != r.Ofst || 5 != r.Size {
+		t.Error(r)
+	}
+	if r := recs[5]; "/dir/file" != r.Path || "/dir/new" != r.Newpath {
+		t.Error(r)
+	}
+	if r := recs[6]; nil != r.Owner || nil == r.Group || 100 != *r.Group {
+		t.Error(r)
+	}
+	if r := recs[7]; "/dir/link" != r.Path || "new" != r.Target {
+		t.Error(r)
+	}
+	if r := recs[8]; -ENOENT != r.Errc {
+		t.Error(r)
+	}
+	if r := recs[9]; "/tmp/x" != r.Path || "/dir/x" != r.Newpath {
+		t.Error(r)
+	}
+}
+
+func TestAuditFilter(t *testing.T) {
+	tfs := newTestFs()
+	buf := &bytes.Buffer{}
+	fs, err := NewAudit(tfs, AuditOptions{
+		Writer: buf,
+		Ops:    []string{"Mkdir", "Rmdir"},
+		Filter: func(rec *AuditRecord) bool {
+			return 0 == rec.Errc
+		},
+		Getcontext: testAuditContext,
+		Exe:        testAuditExe,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	fs.Mkdir("/dir", 0755)
+	fs.Mkdir("/dir", 0755)
+	fs.Chmod("/dir", 0700)
+	fs.Rmdir("/dir")
+	recs := testAuditRecords(t, buf.Bytes())
+	if 2 != len(recs) || "Mkdir" != recs[0].Op || "Rmdir" != recs[1].Op {
+		t.Error(recs)
+	}
+
+	if _, err := NewAudit(tfs, AuditOptions{}); nil == err {
+		t.Error()
+	}
+	if _, err := NewAudit(tfs, AuditOptions{Writer: buf, Exclude: []string{"["}}); nil == err {
+		t.Error()
+	}
+}
+
+func TestAuditRotate(t *testing.T) {
+	tfs := newTestFs()
+	name := filepath.Join(t.TempDir(), "audit.log")
+	fs, err := NewAudit(tfs, AuditOptions{
+		File:       name,
+		MaxSize:    512,
+		MaxFiles:   2,
+		Getcontext: testAuditContext,
+		Exe:        testAuditExe,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	for i := 0; 20 > i; i++ {
+		fs.Chmod("/", 0755)
+	}
+	if err := fs.Close(); nil != err {
+		t.Error(err)
+	}
+	if nil != fs.Err() {
+		t.Error(fs.Err())
+	}
+	total := 0
+	for _, n := range []string{name, name + ".1", name + ".2"} {
+		data, err := ioutil.ReadFile(n)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 512 < len(data) {
+			t.Error(n, len(data))
+		}
+		total += len(testAuditRecords(t, data))
+	}
+	if _, err := os.Stat(name + ".3"); nil == err {
+		t.Error()
+	}
+	if 20 <= total {
+		t.Error(total)
+	}
+}
+
+func TestAuditRotateError(t *testing.T) {
+	tfs := newTestFs()
+	name := filepath.Join(t.TempDir(), "audit.log")
+	fs, err := NewAudit(tfs, AuditOptions{
+		File:       name,
+		MaxSize:    256,
+		MaxFiles:   1,
+		Getcontext: testAuditContext,
+		Exe:        testAuditExe,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// a non-empty directory in place of the rotated file makes rotation fail
+	os.Mkdir(name+".1", 0755)
+	ioutil.WriteFile(filepath.Join(name+".1", "x"), nil, 0644)
+
+	for i := 0; 10 > i; i++ {
+		fs.Chmod("/", 0755)
+	}
+	if nil == fs.Err() {
+		t.Error()
+	}
+	fs.Close()
+	data, err := ioutil.ReadFile(name)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if n := len(testAuditRecords(t, data)); 10 != n {
+		t.Error(n)
+	}
+}
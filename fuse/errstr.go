@@ -34,6 +34,7 @@ var errorStrings = []struct {
 	{EDEADLK, "EDEADLK"},
 	{EDESTADDRREQ, "EDESTADDRREQ"},
 	{EDOM, "EDOM"},
+	{EDQUOT, "EDQUOT"},
 	{EEXIST, "EEXIST"},
 	{EFAULT, "EFAULT"},
 	{EFBIG, "EFBIG"},
@@ -0,0 +1,732 @@
+/*
+ * fault.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"errors"
+	"math/rand"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultRule is a rule that injects a fault into the operations of a Fault file system.
+type FaultRule struct {
+	// Operation name (e.g. "Read", "Getattr"). Empty or "*" matches all operations.
+	Op string
+
+	// Path pattern (as in path.Match). Empty matches all paths.
+	Path string
+
+	// Probability with which the rule fires on a matching operation. Zero means 1.
+	Probability float64
+
+	// If not zero the rule fires only on every Nth matching operation.
+	Nth int
+
+	// Error code returned by the operation when the rule fires (e.g. -EIO). Zero means
+	// the operation is performed.
+	Errc int
+
+	// Delay before the operation is performed or fails when the rule fires.
+	Delay time.Duration
+
+	// If not zero Read and Write transfer at most Short bytes when the rule fires.
+	Short int
+
+	count int
+}
+
+// Fault is a file system that injects errors, latency and short transfers into the
+// operations of another file system according to a list of rules. All rules that fire
+// for an operation take effect: their delays are added, the error code of the first
+// rule with one is returned and the smallest Short limit applies.
+//
+// The rules may be changed at runtime by calling SetRules or through a control file
+// named "rules" within a control directory (e.g. "/.fault/rules"). Reading the control
+// file returns the current rules one per line; writing and closing it replaces them.
+// A rule line consists of space separated key=value pairs with the keys op, path,
+// prob, nth, errc (an error name such as EIO or a number), delay (a duration such as
+// 100ms) and short. Empty lines and lines starting with '#' are ignored. Operations on
+// the control directory are not subject to the rules.
+type Fault struct {
+	FileSystemInterface
+	control string
+	lock    sync.Mutex
+	rules   []FaultRule
+	rand    *rand.Rand
+	tmsp    Timespec
+	writes  map[uint64][]byte
+	nexth   uint64
+}
+
+// NewFault creates a Fault file system for the file system fs with no rules. The
+// control directory is placed at the path control; if control is empty there is no
+// control directory.
+func NewFault(fs FileSystemInterface, control string) *Fault {
+	if "" != control {
+		control = pathutil.Clean("/" + control)
+	}
+	return &Fault{
+		FileSystemInterface: fs,
+		control:             control,
+		rand:                rand.New(rand.NewSource(time.Now().UnixNano())),
+		tmsp:                Now(),
+		writes:              map[uint64][]byte{},
+	}
+}
+
+// SetRules validates and replaces the rules.
+func (fault *Fault) SetRules(rules []FaultRule) error {
+	for _, r := range rules {
+		if "" != r.Path {
+			if _, err := pathutil.Match(r.Path, ""); nil != err {
+				return errors.New("Fault: invalid path pattern " + r.Path)
+			}
+		}
+		if 0 > r.Probability || 1 < r.Probability || 0 > r.Nth || 0 < r.Errc ||
+			0 > r.Delay || 0 > r.Short {
+			return errors.New("Fault: invalid rule " + r.String())
+		}
+	}
+	rules = append([]FaultRule{}, rules...)
+	for i := range rules {
+		rules[i].count = 0
+	}
+	fault.lock.Lock()
+	fault.rules = rules
+	fault.lock.Unlock()
+	return nil
+}
+
+// Rules returns the current rules.
+func (fault *Fault) Rules() []FaultRule {
+	fault.lock.Lock()
+	defer fault.lock.Unlock()
+	return append([]FaultRule{}, fault.rules...)
+}
+
+func faultErrorName(errc int) string {
+	for _, i := range errorStrings {
+		if -i.errc == errc {
+			return i.errs
+		}
+	}
+	return strconv.Itoa(errc)
+}
+
+func faultErrorCode(s string) (int, error) {
+	for _, i := range errorStrings {
+		if i.errs == s {
+			return -i.errc, nil
+		}
+	}
+	errc, err := strconv.Atoi(s)
+	if nil != err {
+		return 0, err
+	}
+	if 0 < errc {
+		errc = -errc
+	}
+	return errc, nil
+}
+
+// String returns the rule in the format of the control file.
+func (r FaultRule) String() string {
+	parts := []string{}
+	if "" != r.Op {
+		parts = append(parts, "op="+r.Op)
+	}
+	if "" != r.Path {
+		parts = append(parts, "path="+r.Path)
+	}
+	if 0 != r.Probability {
+		parts = append(parts, "prob="+strconv.FormatFloat(r.Probability, 'g', -1, 64))
+	}
+	if 0 != r.Nth {
+		parts = append(parts, "nth="+strconv.Itoa(r.Nth))
+	}
+	if 0 != r.Errc {
+		parts = append(parts, "errc="+faultErrorName(r.Errc))
+	}
+	if 0 != r.Delay {
+		parts = append(parts, "delay="+r.Delay.String())
+	}
+	if 0 != r.Short {
+		parts = append(parts, "short="+strconv.Itoa(r.Short))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseFaultRules parses rules in the format of the control file.
+func ParseFaultRules(text string) ([]FaultRule, error) {
+	rules := []FaultRule{}
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := FaultRule{}
+		for _, field := range strings.Fields(line) {
+			var err error
+			kv := strings.SplitN(field, "=", 2)
+			if 2 != len(kv) {
+				err = errors.New("missing value")
+			} else {
+				switch kv[0] {
+				case "op":
+					r.Op = kv[1]
+				case "path":
+					r.Path = kv[1]
+				case "prob":
+					r.Probability, err = strconv.ParseFloat(kv[1], 64)
+				case "nth":
+					r.Nth, err = strconv.Atoi(kv[1])
+				case "errc":
+					r.Errc, err = faultErrorCode(kv[1])
+				case "delay":
+					r.Delay, err = time.ParseDuration(kv[1])
+				case "short":
+					r.Short, err = strconv.Atoi(kv[1])
+				default:
+					err = errors.New("unknown key")
+				}
+			}
+			if nil != err {
+				return nil, errors.New("ParseFaultRules: line " + strconv.Itoa(n+1) +
+					": " + field + ": " + err.Error())
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// inject applies the rules that fire for op on path. It returns the error code and
+// transfer limit (or -1) of the operation.
+func (fault *Fault) inject(op string, path string) (int, int) {
+	errc, short := 0, -1
+	var delay time.Duration
+	fault.lock.Lock()
+	for i := range fault.rules {
+		r := &fault.rules[i]
+		if "" != r.Op && "*" != r.Op && op != r.Op {
+			continue
+		}
+		if "" != r.Path {
+			if ok, _ := pathutil.Match(r.Path, path); !ok {
+				continue
+			}
+		}
+		r.count++
+		if 0 != r.Nth && 0 != r.count%r.Nth {
+			continue
+		}
+		if 0 != r.Probability && r.Probability <= fault.rand.Float64() {
+			continue
+		}
+		delay += r.Delay
+		if 0 == errc {
+			errc = r.Errc
+		}
+		if 0 != r.Short && (-1 == short || r.Short < short) {
+			short = r.Short
+		}
+	}
+	fault.lock.Unlock()
+	if 0 < delay {
+		time.Sleep(delay)
+	}
+	return errc, short
+}
+
+// isControl reports whether path is within the control directory.
+func (fault *Fault) isControl(path string) bool {
+	return "" != fault.control &&
+		(path == fault.control || strings.HasPrefix(path, fault.control+"/"))
+}
+
+// controlErrc returns -ENOENT if path is not the control directory or file.
+func (fault *Fault) controlErrc(path string) int {
+	if path != fault.control && path != fault.control+"/rules" {
+		return -ENOENT
+	}
+	return 0
+}
+
+func (fault *Fault) controlStat(path string, stat *Stat_t) {
+	*stat = Stat_t{}
+	if path == fault.control {
+		stat.Mode = S_IFDIR | 0755
+		stat.Nlink = 2
+	} else {
+		stat.Mode = S_IFREG | 0644
+		stat.Nlink = 1
+		stat.Size = int64(len(fault.controlText()))
+	}
+	stat.Atim, stat.Mtim, stat.Ctim, stat.Birthtim = fault.tmsp, fault.tmsp, fault.tmsp, fault.tmsp
+}
+
+func (fault *Fault) controlText() string {
+	text := ""
+	for _, r := range fault.Rules() {
+		text += r.String() + "\n"
+	}
+	return text
+}
+
+func (fault *Fault) Statfs(path string, stat *Statfs_t) int {
+	if fault.isControl(path) {
+		path = "/"
+	}
+	if errc, _ := fault.inject("Statfs", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Statfs(path, stat)
+}
+
+func (fault *Fault) Mknod(path string, mode uint32, dev uint64) int {
+	if fault.isControl(path) {
+		return -EEXIST
+	}
+	if errc, _ := fault.inject("Mknod", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Mknod(path, mode, dev)
+}
+
+func (fault *Fault) Mkdir(path string, mode uint32) int {
+	if fault.isControl(path) {
+		return -EEXIST
+	}
+	if errc, _ := fault.inject("Mkdir", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Mkdir(path, mode)
+}
+
+func (fault *Fault) Unlink(path string) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Unlink", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Unlink(path)
+}
+
+func (fault *Fault) Rmdir(path string) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Rmdir", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Rmdir(path)
+}
+
+func (fault *Fault) Link(oldpath string, newpath string) int {
+	if fault.isControl(oldpath) || fault.isControl(newpath) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Link", oldpath); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (fault *Fault) Symlink(target string, newpath string) int {
+	if fault.isControl(newpath) {
+		return -EEXIST
+	}
+	if errc, _ := fault.inject("Symlink", newpath); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (fault *Fault) Readlink(path string) (int, string) {
+	if fault.isControl(path) {
+		return -EINVAL, ""
+	}
+	if errc, _ := fault.inject("Readlink", path); 0 != errc {
+		return errc, ""
+	}
+	return fault.FileSystemInterface.Readlink(path)
+}
+
+func (fault *Fault) Rename(oldpath string, newpath string) int {
+	if fault.isControl(oldpath) || fault.isControl(newpath) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Rename", oldpath); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (fault *Fault) Chmod(path string, mode uint32) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Chmod", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Chmod(path, mode)
+}
+
+func (fault *Fault) Chown(path string, uid uint32, gid uint32) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Chown", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (fault *Fault) Utimens(path string, tmsp []Timespec) int {
+	if fault.isControl(path) {
+		return 0
+	}
+	if errc, _ := fault.inject("Utimens", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Utimens(path, tmsp)
+}
+
+func (fault *Fault) Access(path string, mask uint32) int {
+	if fault.isControl(path) {
+		return 0
+	}
+	if errc, _ := fault.inject("Access", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Access(path, mask)
+}
+
+func (fault *Fault) Create(path string, flags int, mode uint32) (int, uint64) {
+	if fault.isControl(path) {
+		if 0 != fault.controlErrc(path) {
+			return -EPERM, ^uint64(0)
+		}
+		return fault.Open(path, flags)
+	}
+	if errc, _ := fault.inject("Create", path); 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return fault.FileSystemInterface.Create(path, flags, mode)
+}
+
+func (fault *Fault) Open(path string, flags int) (int, uint64) {
+	if fault.isControl(path) {
+		if errc := fault.controlErrc(path); 0 != errc {
+			return errc, ^uint64(0)
+		}
+		if path == fault.control {
+			return -EISDIR, ^uint64(0)
+		}
+		text := ""
+		if 0 == flags&O_TRUNC {
+			text = fault.controlText()
+		}
+		fault.lock.Lock()
+		defer fault.lock.Unlock()
+		fault.nexth++
+		if O_RDONLY != flags&O_ACCMODE {
+			// writes without O_TRUNC edit the current rules (e.g. appends)
+			fault.writes[fault.nexth] = []byte(text)
+		}
+		return 0, fault.nexth
+	}
+	if errc, _ := fault.inject("Open", path); 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return fault.FileSystemInterface.Open(path, flags)
+}
+
+func (fault *Fault) Getattr(path string, stat *Stat_t, fh uint64) int {
+	if fault.isControl(path) {
+		if errc := fault.controlErrc(path); 0 != errc {
+			return errc
+		}
+		fault.controlStat(path, stat)
+		return 0
+	}
+	if errc, _ := fault.inject("Getattr", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Getattr(path, stat, fh)
+}
+
+func (fault *Fault) Truncate(path string, size int64, fh uint64) int {
+	if fault.isControl(path) {
+		fault.lock.Lock()
+		defer fault.lock.Unlock()
+		if buff, ok := fault.writes[fh]; ok && int64(len(buff)) >= size {
+			fault.writes[fh] = buff[:size]
+		}
+		return 0
+	}
+	if errc, _ := fault.inject("Truncate", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (fault *Fault) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	if fault.isControl(path) {
+		text := fault.controlText()
+		if ofst >= int64(len(text)) {
+			return 0
+		}
+		return copy(buff, text[ofst:])
+	}
+	errc, short := fault.inject("Read", path)
+	if 0 != errc {
+		return errc
+	}
+	if 0 <= short && short < len(buff) {
+		buff = buff[:short]
+	}
+	return fault.FileSystemInterface.Read(path, buff, ofst, fh)
+}
+
+func (fault *Fault) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	if fault.isControl(path) {
+		fault.lock.Lock()
+		defer fault.lock.Unlock()
+		data, ok := fault.writes[fh]
+		if !ok {
+			return -EBADF
+		}
+		if end := ofst + int64(len(buff)); int64(len(data)) < end {
+			data = append(data, make([]byte, end-int64(len(data)))...)
+		}
+		copy(data[ofst:], buff)
+		fault.writes[fh] = data
+		return len(buff)
+	}
+	errc, short := fault.inject("Write", path)
+	if 0 != errc {
+		return errc
+	}
+	if 0 <= short && short < len(buff) {
+		buff = buff[:short]
+	}
+	return fault.FileSystemInterface.Write(path, buff, ofst, fh)
+}
+
+// commit replaces the rules with those written to the control file handle fh.
+func (fault *Fault) commit(fh uint64, release bool) int {
+	fault.lock.Lock()
+	data, ok := fault.writes[fh]
+	if release {
+		delete(fault.writes, fh)
+	}
+	fault.lock.Unlock()
+	if !ok {
+		return 0
+	}
+	rules, err := ParseFaultRules(string(data))
+	if nil == err {
+		err = fault.SetRules(rules)
+	}
+	if nil != err {
+		return -EINVAL
+	}
+	return 0
+}
+
+func (fault *Fault) Flush(path string, fh uint64) int {
+	if fault.isControl(path) {
+		return fault.commit(fh, false)
+	}
+	if errc, _ := fault.inject("Flush", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Flush(path, fh)
+}
+
+func (fault *Fault) Release(path string, fh uint64) int {
+	if fault.isControl(path) {
+		return fault.commit(fh, true)
+	}
+	if errc, _ := fault.inject("Release", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Release(path, fh)
+}
+
+func (fault *Fault) Fsync(path string, datasync bool, fh uint64) int {
+	if fault.isControl(path) {
+		return 0
+	}
+	if errc, _ := fault.inject("Fsync", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Fsync(path, datasync, fh)
+}
+
+func (fault *Fault) Opendir(path string) (int, uint64) {
+	if fault.isControl(path) {
+		if errc := fault.controlErrc(path); 0 != errc {
+			return errc, ^uint64(0)
+		}
+		if path != fault.control {
+			return -ENOTDIR, ^uint64(0)
+		}
+		return 0, 0
+	}
+	if errc, _ := fault.inject("Opendir", path); 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return fault.FileSystemInterface.Opendir(path)
+}
+
+func (fault *Fault) Readdir(path string,
+	fill func(name string, stat *Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) int {
+	if fault.isControl(path) {
+		stat := Stat_t{}
+		fault.controlStat(fault.control, &stat)
+		fill(".", &stat, 0)
+		fill("..", nil, 0)
+		fault.controlStat(fault.control+"/rules", &stat)
+		fill("rules", &stat, 0)
+		return 0
+	}
+	if errc, _ := fault.inject("Readdir", path); 0 != errc {
+		return errc
+	}
+	if "" == fault.control || pathutil.Dir(fault.control) != path || 0 != ofst {
+		return fault.FileSystemInterface.Readdir(path, fill, ofst, fh)
+	}
+	full := false
+	errc := fault.FileSystemInterface.Readdir(path,
+		func(name string, stat *Stat_t, ofst int64) bool {
+			if !fill(name, stat, ofst) {
+				full = true
+				return false
+			}
+			return true
+		},
+		ofst,
+		fh)
+	if 0 == errc && !full {
+		stat := Stat_t{}
+		fault.controlStat(fault.control, &stat)
+		fill(pathutil.Base(fault.control), &stat, 0)
+	}
+	return errc
+}
+
+func (fault *Fault) Releasedir(path string, fh uint64) int {
+	if fault.isControl(path) {
+		return 0
+	}
+	if errc, _ := fault.inject("Releasedir", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Releasedir(path, fh)
+}
+
+func (fault *Fault) Fsyncdir(path string, datasync bool, fh uint64) int {
+	if fault.isControl(path) {
+		return 0
+	}
+	if errc, _ := fault.inject("Fsyncdir", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Fsyncdir(path, datasync, fh)
+}
+
+func (fault *Fault) Setxattr(path string, name string, value []byte, flags int) int {
+	if fault.isControl(path) {
+		return -ENOTSUP
+	}
+	if errc, _ := fault.inject("Setxattr", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+func (fault *Fault) Getxattr(path string, name string) (int, []byte) {
+	if fault.isControl(path) {
+		return -ENOATTR, nil
+	}
+	if errc, _ := fault.inject("Getxattr", path); 0 != errc {
+		return errc, nil
+	}
+	return fault.FileSystemInterface.Getxattr(path, name)
+}
+
+func (fault *Fault) Removexattr(path string, name string) int {
+	if fault.isControl(path) {
+		return -ENOATTR
+	}
+	if errc, _ := fault.inject("Removexattr", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Removexattr(path, name)
+}
+
+func (fault *Fault) Listxattr(path string, fill func(name string) bool) int {
+	if fault.isControl(path) {
+		return 0
+	}
+	if errc, _ := fault.inject("Listxattr", path); 0 != errc {
+		return errc
+	}
+	return fault.FileSystemInterface.Listxattr(path, fill)
+}
+
+func (fault *Fault) Chflags(path string, flags uint32) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Chflags", path); 0 != errc {
+		return errc
+	}
+	return fsChflags(fault.FileSystemInterface, path, flags)
+}
+
+func (fault *Fault) Setcrtime(path string, tmsp Timespec) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Setcrtime", path); 0 != errc {
+		return errc
+	}
+	return fsSetcrtime(fault.FileSystemInterface, path, tmsp)
+}
+
+func (fault *Fault) Setchgtime(path string, tmsp Timespec) int {
+	if fault.isControl(path) {
+		return -EPERM
+	}
+	if errc, _ := fault.inject("Setchgtime", path); 0 != errc {
+		return errc
+	}
+	return fsSetchgtime(fault.FileSystemInterface, path, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*Fault)(nil)
+	_ FileSystemChflags    = (*Fault)(nil)
+	_ FileSystemSetcrtime  = (*Fault)(nil)
+	_ FileSystemSetchgtime = (*Fault)(nil)
+)
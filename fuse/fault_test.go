@@ -0,0 +1,171 @@
+/*
+ * fault_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFault(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	tfs.create("/dir/file", S_IFREG|0644, "hello")
+	tfs.create("/other", S_IFREG|0644, "world")
+	fs := NewFault(tfs, "")
+
+	err := fs.SetRules([]FaultRule{
+		{Op: "Read", Path: "/dir/*", Errc: -EIO},
+		{Op: "Getattr", Nth: 2, Errc: -ENOSPC},
+		{Op: "Write", Short: 2},
+		{Op: "Open", Delay: 20 * time.Millisecond},
+		{Op: "Access", Probability: 0.5, Errc: -EACCES},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if errc, _ := testReadFile(fs, "/dir/file"); -EIO != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(fs, "/other"); 0 != errc || "world" != data {
+		t.Error(errc, data)
+	}
+
+	stat := Stat_t{}
+	for i := 1; 5 > i; i++ {
+		errc := fs.Getattr("/other", &stat, ^uint64(0))
+		if (0 == i%2 && -ENOSPC != errc) || (0 != i%2 && 0 != errc) {
+			t.Error(i, errc)
+		}
+	}
+
+	errc, fh := fs.Open("/other", O_RDWR)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if n := fs.Write("/other", []byte("WORLD"), 0, fh); 2 != n {
+		t.Error(n)
+	}
+	fs.Release("/other", fh)
+
+	start := time.Now()
+	fs.Open("/other", O_RDONLY)
+	if d := time.Since(start); 20*time.Millisecond > d {
+		t.Error(d)
+	}
+
+	n := 0
+	for i := 0; 1000 > i; i++ {
+		if -EACCES == fs.Access("/other", R_OK) {
+			n++
+		}
+	}
+	if 300 > n || 700 < n {
+		t.Error(n)
+	}
+
+	if err := fs.SetRules([]FaultRule{{Path: "["}}); nil == err {
+		t.Error()
+	}
+	if err := fs.SetRules([]FaultRule{{Errc: EIO}}); nil == err {
+		t.Error()
+	}
+	if 5 != len(fs.Rules()) {
+		t.Error(fs.Rules())
+	}
+}
+
+func TestFaultControl(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/file", S_IFREG|0644, "hello")
+	fs := NewFault(tfs, "/.fault")
+
+	if errc, names := testReaddir(fs, "/"); 0 != errc || 2 != len(names) || ".fault" != names[0] {
+		t.Error(errc, names)
+	}
+	if errc, names := testReaddir(fs, "/.fault"); 0 != errc || 1 != len(names) || "rules" != names[0] {
+		t.Error(errc, names)
+	}
+	stat := Stat_t{}
+	if errc := fs.Getattr("/.fault/other", &stat, ^uint64(0)); -ENOENT != errc {
+		t.Error(errc)
+	}
+
+	rules := "# comment\nop=Read path=/file errc=EIO\n\nop=Write short=1 nth=2 delay=1ms prob=1\n"
+	errc, fh := fs.Open("/.fault/rules", O_WRONLY|O_TRUNC)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if n := fs.Write("/.fault/rules", []byte(rules), 0, fh); len(rules) != n {
+		t.Error(n)
+	}
+	if errc := fs.Release("/.fault/rules", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, _ := testReadFile(fs, "/file"); -EIO != errc {
+		t.Error(errc)
+	}
+	expect := "op=Read path=/file errc=EIO\nop=Write prob=1 nth=2 delay=1ms short=1\n"
+	if errc, data := testReadFile(fs, "/.fault/rules"); 0 != errc || expect != data {
+		t.Errorf("%d %q", errc, data)
+	}
+	if errc := fs.Getattr("/.fault/rules", &stat, ^uint64(0)); 0 != errc || int64(len(expect)) != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+
+	errc, fh = fs.Open("/.fault/rules", O_WRONLY|O_APPEND)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if n := fs.Write("/.fault/rules", []byte("op=Rename errc=EPERM\n"), int64(len(expect)), fh); 21 != n {
+		t.Error(n)
+	}
+	if errc := fs.Release("/.fault/rules", fh); 0 != errc {
+		t.Error(errc)
+	}
+	expect += "op=Rename errc=EPERM\n"
+	if errc, data := testReadFile(fs, "/.fault/rules"); 0 != errc || expect != data {
+		t.Errorf("%d %q", errc, data)
+	}
+	if errc := fs.Rename("/file", "/new"); -EPERM != errc {
+		t.Error(errc)
+	}
+
+	errc, fh = fs.Open("/.fault/rules", O_WRONLY|O_TRUNC)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Write("/.fault/rules", []byte("op=Read bogus\n"), 0, fh)
+	if errc := fs.Flush("/.fault/rules", fh); -EINVAL != errc {
+		t.Error(errc)
+	}
+	fs.Release("/.fault/rules", fh)
+	if 3 != len(fs.Rules()) {
+		t.Error(fs.Rules())
+	}
+
+	errc, fh = fs.Open("/.fault/rules", O_WRONLY|O_TRUNC)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if errc := fs.Release("/.fault/rules", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(fs, "/file"); 0 != errc || "hello" != data {
+		t.Error(errc, data)
+	}
+	if errc := fs.Unlink("/.fault/rules"); -EPERM != errc {
+		t.Error(errc)
+	}
+}
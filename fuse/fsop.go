@@ -161,6 +161,18 @@ type FileInfo_t struct {
 	// File is not seekable. [IGNORED on Windows]
 	NonSeekable bool
 
+	// Cache directory contents; set by OpendirEx. [libfuse3 only]
+	CacheReaddir bool
+
+	// Allow concurrent direct writes to the same file. [libfuse3 only]
+	ParallelDirectWrites bool
+
+	// Data should be flushed; set by the host during ReleaseEx.
+	FlushRequested bool
+
+	// Lock owner; set by the host during FlushEx and ReleaseEx.
+	LockOwner uint64
+
 	// File handle.
 	Fh uint64
 }
@@ -319,6 +331,117 @@ type FileSystemOpenEx interface {
 	OpenEx(path string, fi *FileInfo_t) int
 }
 
+// FileSystemOpendirEx is the interface that wraps the OpendirEx method.
+//
+// OpendirEx is similar to Opendir except that it allows direct manipulation of the
+// FileInfo_t struct. This allows a file system to control caching of directory
+// contents using the KeepCache and CacheReaddir fields.
+type FileSystemOpendirEx interface {
+	OpendirEx(path string, fi *FileInfo_t) int
+}
+
+// FileSystemReleaseEx is the interface that wraps the FlushEx and ReleaseEx methods.
+//
+// FlushEx and ReleaseEx are similar to Flush and Release except that they receive
+// a FileInfo_t struct that includes the LockOwner and FlushRequested fields.
+type FileSystemReleaseEx interface {
+	FlushEx(path string, fi *FileInfo_t) int
+	ReleaseEx(path string, fi *FileInfo_t) int
+}
+
+// FUSE_ROOT_ID is the node ID of the root directory.
+const FUSE_ROOT_ID = 1
+
+// FileSystemNode is the interface that wraps the Lookup, Forget, GetattrNode and
+// ReaddirNode methods. It is modeled after the FUSE low-level operations.
+//
+// A file system that implements FileSystemNode identifies files by node ID, a
+// number that is unique among the files that the file system has reported via Lookup.
+// The root directory has node ID FUSE_ROOT_ID. The host resolves the paths that it
+// receives into node IDs by calling Lookup for each path component that it has not
+// seen before and remembers the results; it then calls GetattrNode and ReaddirNode in
+// place of Getattr and Readdir. All other operations remain path based.
+//
+// Every successful Lookup increments a lookup count for the returned node ID. The host
+// calls Forget when it drops references to a node ID: when the file is removed or
+// replaced by a rename, when a GetattrNode reports -ENOENT, when the entry is evicted
+// from the host's node table (see SetNodeLimit) and when the file system is destroyed.
+// An evicted entry is looked up again when it is next referenced. Renames do not
+// invalidate node IDs; the host moves the renamed entry and its descendants without
+// further Lookup calls. Lookup may be called concurrently for the same directory entry.
+type FileSystemNode interface {
+	// Lookup looks up a directory entry by name and gets its node ID and attributes.
+	Lookup(parent uint64, name string, stat *Stat_t) (int, uint64)
+
+	// Forget releases nlookup lookup references to a node ID.
+	Forget(ino uint64, nlookup uint64)
+
+	// GetattrNode gets file attributes by node ID.
+	GetattrNode(ino uint64, stat *Stat_t, fh uint64) int
+
+	// ReaddirNode reads a directory by node ID.
+	ReaddirNode(ino uint64,
+		fill func(name string, stat *Stat_t, ofst int64) bool,
+		ofst int64,
+		fh uint64) int
+}
+
+// FileSystemLookup is the interface that wraps the Lookup and Forget methods.
+//
+// Lookup and Forget allow a path based file system to track which paths the host
+// references, so that it can safely evict any per-path state that it keeps. The host
+// calls Lookup the first time that Getattr succeeds for a path. It calls Forget to
+// balance the Lookup calls for a path when the file is removed or renamed, when
+// Getattr reports -ENOENT for it and when the file system is destroyed; nlookup is the
+// number of Lookup calls being balanced. A renamed file is looked up again under its
+// new path. Forget for a path is never called before the Lookup that it balances has
+// returned.
+//
+// The FUSE high-level API does not report when the kernel evicts an entry from its
+// caches. Instead the host keeps a bounded number of paths (see SetNodeLimit) and
+// calls Forget for the least recently used paths beyond that bound; a forgotten path
+// is looked up again the next time that Getattr succeeds for it. Per-path state is
+// therefore bounded by the node limit, but a path may be forgotten while the kernel
+// still caches it, so a file system must be able to recreate any state that it evicts.
+type FileSystemLookup interface {
+	Lookup(path string)
+	Forget(path string, nlookup uint64)
+}
+
+// FileSystemReadBuf is the interface that wraps the ReadBuf and WriteBuf methods.
+//
+// ReadBuf and WriteBuf allow a file system that stores file data in OS files to have
+// the host transfer data directly between those files and FUSE, without copying it
+// through Go buffers; where possible libfuse uses splice(2) for the transfer. Rather
+// than transferring the data themselves, ReadBuf and WriteBuf return the file
+// descriptor and file position that size bytes of data at offset ofst should be read
+// from or written to. The file descriptor must remain valid until the Release of fh.
+//
+// The host calls ReadBuf in place of Read and WriteBuf in place of Write. If ReadBuf or
+// WriteBuf returns -ENOSYS the host falls back to Read or Write. [Linux only]
+type FileSystemReadBuf interface {
+	ReadBuf(path string, size int, ofst int64, fh uint64) (int, int, int64)
+	WriteBuf(path string, size int, ofst int64, fh uint64) (int, int, int64)
+}
+
+// FileSystemPassthrough is the interface that wraps the Passthrough method.
+//
+// Passthrough is called after a successful Open or Create and returns a file descriptor
+// for the backing file of the open file fh, or -1 if the file should not use passthrough.
+// The kernel then forwards reads and writes for the open file directly to the backing
+// file without calling Read or Write. The file descriptor need only remain valid for the
+// duration of the call.
+//
+// Passthrough requires kernel support (Linux 6.9 or later) and libfuse 3.16 or later; it
+// is detected when the file system is mounted. When it is not available, or when the
+// kernel declines a particular file, the host does not call Passthrough or falls back to
+// Read and Write respectively; so a file system that implements Passthrough must still
+// implement Read and Write. Files opened with KeepCache or NonSeekable set do not use
+// passthrough. [libfuse3 only]
+type FileSystemPassthrough interface {
+	Passthrough(path string, fh uint64) int
+}
+
 // FileSystemGetpath is the interface that wraps the Getpath method.
 //
 // Getpath allows a case-insensitive file system to report the correct case of a file path.
@@ -107,6 +107,9 @@ package fuse
 #define ETXTBSY         139
 #define EWOULDBLOCK     140
 
+// EDQUOT: not defined by the Windows CRT; convert to ENOSPC
+#define EDQUOT          ENOSPC
+
 #include <fcntl.h>
 #define O_RDONLY        _O_RDONLY
 #define O_WRONLY        _O_WRONLY
@@ -177,6 +180,7 @@ const (
 	EDEADLK         = int(C.EDEADLK)
 	EDESTADDRREQ    = int(C.EDESTADDRREQ)
 	EDOM            = int(C.EDOM)
+	EDQUOT          = int(C.EDQUOT)
 	EEXIST          = int(C.EEXIST)
 	EFAULT          = int(C.EFAULT)
 	EFBIG           = int(C.EFBIG)
@@ -35,6 +35,7 @@ const (
 	EDEADLK         = 36
 	EDESTADDRREQ    = 109
 	EDOM            = 33
+	EDQUOT          = ENOSPC
 	EEXIST          = 17
 	EFAULT          = 14
 	EFBIG           = 27
@@ -18,20 +18,30 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 // FileSystemHost is used to host a file system.
 type FileSystemHost struct {
-	fsop FileSystemInterface
-	fuse *c_struct_fuse
-	mntp string
-	sigc chan os.Signal
-
-	capCaseInsensitive, capReaddirPlus, capDeleteAccess bool
+	fsop  FileSystemInterface
+	fuse  *c_struct_fuse
+	mntp  string
+	sigc  chan os.Signal
+	sigs  []os.Signal
+	nodes *hostNodeTable
+	pthru *hostPassthrough
+	admit *hostAdmission
+	ops   hostOps
+
+	drainTimeout time.Duration
+	panicPolicy  PanicPolicy
+
+	capCaseInsensitive, capReaddirPlus, capDeleteAccess, capDontMask bool
 }
 
 var (
@@ -61,6 +71,24 @@ func hostHandleGet(p unsafe.Pointer) *FileSystemHost {
 	return host
 }
 
+// Mounts returns the file system hosts that are currently mounted, sorted by mountpoint.
+// A host is considered mounted after its file system has received the Init() call and
+// until it has received the Destroy() call.
+func Mounts() []*FileSystemHost {
+	hostGuard.Lock()
+	hosts := make([]*FileSystemHost, 0, len(hostTable))
+	for _, host := range hostTable {
+		if nil != host.fuse {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].mntp < hosts[j].mntp
+	})
+	hostGuard.Unlock()
+	return hosts
+}
+
 func copyCstatvfsFromFusestatfs(dst *c_fuse_statvfs_t, src *Statfs_t) {
 	c_hostCstatvfsFromFusestatfs(dst,
 		c_uint64_t(src.Bsize),
@@ -100,30 +128,36 @@ func copyFusetimespecFromCtimespec(dst *Timespec, src *c_fuse_timespec_t) {
 	dst.Nsec = int64(src.tv_nsec)
 }
 
-func recoverAsErrno(errc0 *c_int) {
-	if r := recover(); nil != r {
-		switch e := r.(type) {
-		case Error:
-			*errc0 = c_int(e)
-		default:
-			*errc0 = -c_int(EIO)
-		}
-	}
-}
-
 func hostGetattr(path0 *c_char, stat0 *c_fuse_stat_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Getattr", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	stat := &Stat_t{}
-	errc := fsop.Getattr(path, stat, ^uint64(0))
+	var errc int
+	intf, ok := fsop.(FileSystemNode)
+	if ok {
+		errc = host.nodeGetattr(intf, path, stat, ^uint64(0))
+	} else {
+		errc = fsop.Getattr(path, stat, ^uint64(0))
+		host.nodeGetattrDone(path, errc)
+	}
 	copyCstatFromFusestat(stat0, stat)
 	return c_int(errc)
 }
 
 func hostReadlink(path0 *c_char, buff0 *c_char, size0 c_size_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Readlink", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc, rslt := fsop.Readlink(path)
 	buff := (*[1 << 30]byte)(unsafe.Pointer(buff0))
@@ -136,110 +170,196 @@ func hostReadlink(path0 *c_char, buff0 *c_char, size0 c_size_t) (errc0 c_int) {
 }
 
 func hostMknod(path0 *c_char, mode0 c_fuse_mode_t, dev0 c_fuse_dev_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Mknod", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Mknod(path, uint32(mode0), uint64(dev0))
 	return c_int(errc)
 }
 
 func hostMkdir(path0 *c_char, mode0 c_fuse_mode_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Mkdir", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Mkdir(path, uint32(mode0))
 	return c_int(errc)
 }
 
 func hostUnlink(path0 *c_char) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Unlink", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Unlink(path)
+	if 0 == errc {
+		host.nodeRemove(path)
+	}
 	return c_int(errc)
 }
 
 func hostRmdir(path0 *c_char) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Rmdir", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Rmdir(path)
+	if 0 == errc {
+		host.nodeRemove(path)
+	}
 	return c_int(errc)
 }
 
 func hostSymlink(target0 *c_char, newpath0 *c_char) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Symlink", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	target, newpath := c_GoString(target0), c_GoString(newpath0)
 	errc := fsop.Symlink(target, newpath)
 	return c_int(errc)
 }
 
 func hostRename(oldpath0 *c_char, newpath0 *c_char) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Rename", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	oldpath, newpath := c_GoString(oldpath0), c_GoString(newpath0)
 	errc := fsop.Rename(oldpath, newpath)
+	if 0 == errc {
+		host.nodeRename(oldpath, newpath)
+	}
 	return c_int(errc)
 }
 
 func hostLink(oldpath0 *c_char, newpath0 *c_char) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Link", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	oldpath, newpath := c_GoString(oldpath0), c_GoString(newpath0)
 	errc := fsop.Link(oldpath, newpath)
 	return c_int(errc)
 }
 
 func hostChmod(path0 *c_char, mode0 c_fuse_mode_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Chmod", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Chmod(path, uint32(mode0))
 	return c_int(errc)
 }
 
 func hostChown(path0 *c_char, uid0 c_fuse_uid_t, gid0 c_fuse_gid_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Chown", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Chown(path, uint32(uid0), uint32(gid0))
 	return c_int(errc)
 }
 
 func hostTruncate(path0 *c_char, size0 c_fuse_off_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Truncate", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Truncate(path, int64(size0), ^uint64(0))
 	return c_int(errc)
 }
 
+func hostAsgnCfileinfo(fi0 *c_struct_fuse_file_info, fi *FileInfo_t) {
+	c_hostAsgnCfileinfo(fi0,
+		c_bool(fi.DirectIo),
+		c_bool(fi.KeepCache),
+		c_bool(fi.NonSeekable),
+		c_bool(fi.CacheReaddir),
+		c_bool(fi.ParallelDirectWrites),
+		c_uint64_t(fi.Fh))
+}
+
+func hostFileinfoFromCfileinfo(fi0 *c_struct_fuse_file_info) FileInfo_t {
+	return FileInfo_t{
+		Flags:          int(fi0.flags),
+		FlushRequested: bool(c_hostCfileinfoFlush(fi0)),
+		LockOwner:      uint64(c_hostCfileinfoLockOwner(fi0)),
+		Fh:             uint64(fi0.fh),
+	}
+}
+
 func hostOpen(path0 *c_char, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Open", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	intf, ok := fsop.(FileSystemOpenEx)
 	if ok {
 		fi := FileInfo_t{Flags: int(fi0.flags)}
 		errc := intf.OpenEx(path, &fi)
-		c_hostAsgnCfileinfo(fi0,
-			c_bool(fi.DirectIo),
-			c_bool(fi.KeepCache),
-			c_bool(fi.NonSeekable),
-			c_uint64_t(fi.Fh))
+		hostAsgnCfileinfo(fi0, &fi)
+		host.passthroughOpen(path, fi0, errc)
 		return c_int(errc)
 	} else {
 		errc, rslt := fsop.Open(path, int(fi0.flags))
 		fi0.fh = c_uint64_t(rslt)
+		host.passthroughOpen(path, fi0, errc)
 		return c_int(errc)
 	}
 }
 
 func hostRead(path0 *c_char, buff0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
 	fi0 *c_struct_fuse_file_info) (nbyt0 c_int) {
-	defer recoverAsErrno(&nbyt0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Read", &nbyt0)
+	if errc := host.enter(hostOpData); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpData)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	buff := (*[1 << 30]byte)(unsafe.Pointer(buff0))
 	nbyt := fsop.Read(path, buff[:size0], int64(ofst0), uint64(fi0.fh))
@@ -248,8 +368,13 @@ func hostRead(path0 *c_char, buff0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
 
 func hostWrite(path0 *c_char, buff0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
 	fi0 *c_struct_fuse_file_info) (nbyt0 c_int) {
-	defer recoverAsErrno(&nbyt0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Write", &nbyt0)
+	if errc := host.enter(hostOpData); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpData)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	buff := (*[1 << 30]byte)(unsafe.Pointer(buff0))
 	nbyt := fsop.Write(path, buff[:size0], int64(ofst0), uint64(fi0.fh))
@@ -257,8 +382,13 @@ func hostWrite(path0 *c_char, buff0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
 }
 
 func hostStatfs(path0 *c_char, stat0 *c_fuse_statvfs_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Statfs", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	stat := &Statfs_t{}
 	errc := fsop.Statfs(path, stat)
@@ -271,24 +401,54 @@ func hostStatfs(path0 *c_char, stat0 *c_fuse_statvfs_t) (errc0 c_int) {
 }
 
 func hostFlush(path0 *c_char, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Flush", &errc0)
+	if errc := host.enter(hostOpData); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpData)
+	fsop := host.fsop
 	path := c_GoString(path0)
-	errc := fsop.Flush(path, uint64(fi0.fh))
-	return c_int(errc)
+	intf, ok := fsop.(FileSystemReleaseEx)
+	if ok {
+		fi := hostFileinfoFromCfileinfo(fi0)
+		errc := intf.FlushEx(path, &fi)
+		return c_int(errc)
+	} else {
+		errc := fsop.Flush(path, uint64(fi0.fh))
+		return c_int(errc)
+	}
 }
 
 func hostRelease(path0 *c_char, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Release", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
-	errc := fsop.Release(path, uint64(fi0.fh))
-	return c_int(errc)
+	host.passthroughRelease(uint64(fi0.fh))
+	intf, ok := fsop.(FileSystemReleaseEx)
+	if ok {
+		fi := hostFileinfoFromCfileinfo(fi0)
+		errc := intf.ReleaseEx(path, &fi)
+		return c_int(errc)
+	} else {
+		errc := fsop.Release(path, uint64(fi0.fh))
+		return c_int(errc)
+	}
 }
 
 func hostFsync(path0 *c_char, datasync c_int, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Fsync", &errc0)
+	if errc := host.enter(hostOpData); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpData)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Fsync(path, 0 != datasync, uint64(fi0.fh))
 	if -ENOSYS == errc {
@@ -299,8 +459,13 @@ func hostFsync(path0 *c_char, datasync c_int, fi0 *c_struct_fuse_file_info) (err
 
 func hostSetxattr(path0 *c_char, name0 *c_char, buff0 *c_char, size0 c_size_t,
 	flags c_int) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Setxattr", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	name := c_GoString(name0)
 	buff := (*[1 << 30]byte)(unsafe.Pointer(buff0))
@@ -309,8 +474,13 @@ func hostSetxattr(path0 *c_char, name0 *c_char, buff0 *c_char, size0 c_size_t,
 }
 
 func hostGetxattr(path0 *c_char, name0 *c_char, buff0 *c_char, size0 c_size_t) (nbyt0 c_int) {
-	defer recoverAsErrno(&nbyt0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Getxattr", &nbyt0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	name := c_GoString(name0)
 	errc, rslt := fsop.Getxattr(path, name)
@@ -328,8 +498,13 @@ func hostGetxattr(path0 *c_char, name0 *c_char, buff0 *c_char, size0 c_size_t) (
 }
 
 func hostListxattr(path0 *c_char, buff0 *c_char, size0 c_size_t) (nbyt0 c_int) {
-	defer recoverAsErrno(&nbyt0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Listxattr", &nbyt0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	buff := (*[1 << 30]byte)(unsafe.Pointer(buff0))
 	size := int(size0)
@@ -354,8 +529,13 @@ func hostListxattr(path0 *c_char, buff0 *c_char, size0 c_size_t) (nbyt0 c_int) {
 }
 
 func hostRemovexattr(path0 *c_char, name0 *c_char) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Removexattr", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	name := c_GoString(name0)
 	errc := fsop.Removexattr(path, name)
@@ -363,49 +543,87 @@ func hostRemovexattr(path0 *c_char, name0 *c_char) (errc0 c_int) {
 }
 
 func hostOpendir(path0 *c_char, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Opendir", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
-	errc, rslt := fsop.Opendir(path)
-	if -ENOSYS == errc {
-		errc = 0
+	intf, ok := fsop.(FileSystemOpendirEx)
+	if ok {
+		fi := FileInfo_t{Flags: int(fi0.flags)}
+		errc := intf.OpendirEx(path, &fi)
+		if -ENOSYS == errc {
+			errc = 0
+		}
+		hostAsgnCfileinfo(fi0, &fi)
+		return c_int(errc)
+	} else {
+		errc, rslt := fsop.Opendir(path)
+		if -ENOSYS == errc {
+			errc = 0
+		}
+		fi0.fh = c_uint64_t(rslt)
+		return c_int(errc)
 	}
-	fi0.fh = c_uint64_t(rslt)
-	return c_int(errc)
 }
 
 func hostReaddir(path0 *c_char, buff0 unsafe.Pointer, fill0 c_fuse_fill_dir_t, ofst0 c_fuse_off_t,
 	fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Readdir", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
+	plus := c_bool(host.capReaddirPlus)
 	path := c_GoString(path0)
 	fill := func(name1 string, stat1 *Stat_t, off1 int64) bool {
 		name := c_CString(name1)
 		defer c_free(unsafe.Pointer(name))
 		if nil == stat1 {
-			return 0 == c_hostFilldir(fill0, buff0, name, nil, c_fuse_off_t(off1))
+			return 0 == c_hostFilldir(fill0, buff0, name, nil, c_fuse_off_t(off1), plus)
 		} else {
 			stat_ex := c_fuse_stat_ex_t{} // support WinFsp fuse_stat_ex
 			stat := (*c_fuse_stat_t)(unsafe.Pointer(&stat_ex))
 			copyCstatFromFusestat(stat, stat1)
-			return 0 == c_hostFilldir(fill0, buff0, name, stat, c_fuse_off_t(off1))
+			return 0 == c_hostFilldir(fill0, buff0, name, stat, c_fuse_off_t(off1), plus)
 		}
 	}
-	errc := fsop.Readdir(path, fill, int64(ofst0), uint64(fi0.fh))
+	var errc int
+	intf, ok := fsop.(FileSystemNode)
+	if ok {
+		errc = host.nodeReaddir(intf, path, fill, int64(ofst0), uint64(fi0.fh))
+	} else {
+		errc = fsop.Readdir(path, fill, int64(ofst0), uint64(fi0.fh))
+	}
 	return c_int(errc)
 }
 
 func hostReleasedir(path0 *c_char, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Releasedir", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Releasedir(path, uint64(fi0.fh))
 	return c_int(errc)
 }
 
 func hostFsyncdir(path0 *c_char, datasync c_int, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Fsyncdir", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Fsyncdir(path, 0 != datasync, uint64(fi0.fh))
 	if -ENOSYS == errc {
@@ -415,50 +633,65 @@ func hostFsyncdir(path0 *c_char, datasync c_int, fi0 *c_struct_fuse_file_info) (
 }
 
 func hostInit(conn0 *c_struct_fuse_conn_info) (user_data unsafe.Pointer) {
-	defer func() {
-		recover()
-	}()
 	fctx := c_fuse_get_context()
 	user_data = fctx.private_data
 	host := hostHandleGet(user_data)
+	defer host.recoverAsErrno("Init", nil)
+	hostGuard.Lock()
 	host.fuse = fctx.fuse
+	hostGuard.Unlock()
 	c_hostAsgnCconninfo(conn0,
 		c_bool(host.capCaseInsensitive),
 		c_bool(host.capReaddirPlus),
-		c_bool(host.capDeleteAccess))
-	if nil != host.sigc {
-		signal.Notify(host.sigc, syscall.SIGINT, syscall.SIGTERM)
+		c_bool(host.capDeleteAccess),
+		c_bool(host.capDontMask))
+	host.passthroughInit(conn0)
+	if nil != host.sigc && 0 < len(host.sigs) {
+		signal.Notify(host.sigc, host.sigs...)
 	}
 	host.fsop.Init()
 	return
 }
 
 func hostDestroy(user_data unsafe.Pointer) {
-	defer func() {
-		recover()
-	}()
 	if "netbsd" == runtime.GOOS {
 		user_data = c_fuse_get_context().private_data
 	}
 	host := hostHandleGet(user_data)
+	defer host.recoverAsErrno("Destroy", nil)
+	// other FUSE threads may still be executing file system operations
+	host.ops.drain(host.drainTimeout)
+	host.nodeClear()
 	host.fsop.Destroy()
 	if nil != host.sigc {
 		signal.Stop(host.sigc)
 	}
+	hostGuard.Lock()
 	host.fuse = nil
+	hostGuard.Unlock()
 }
 
 func hostAccess(path0 *c_char, mask0 c_int) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Access", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Access(path, uint32(mask0))
 	return c_int(errc)
 }
 
 func hostCreate(path0 *c_char, mode0 c_fuse_mode_t, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Create", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	intf, ok := fsop.(FileSystemOpenEx)
 	if ok {
@@ -470,11 +703,8 @@ func hostCreate(path0 *c_char, mode0 c_fuse_mode_t, fi0 *c_struct_fuse_file_info
 				errc = intf.OpenEx(path, &fi)
 			}
 		}
-		c_hostAsgnCfileinfo(fi0,
-			c_bool(fi.DirectIo),
-			c_bool(fi.KeepCache),
-			c_bool(fi.NonSeekable),
-			c_uint64_t(fi.Fh))
+		hostAsgnCfileinfo(fi0, &fi)
+		host.passthroughOpen(path, fi0, errc)
 		return c_int(errc)
 	} else {
 		errc, rslt := fsop.Create(path, int(fi0.flags), uint32(mode0))
@@ -485,13 +715,19 @@ func hostCreate(path0 *c_char, mode0 c_fuse_mode_t, fi0 *c_struct_fuse_file_info
 			}
 		}
 		fi0.fh = c_uint64_t(rslt)
+		host.passthroughOpen(path, fi0, errc)
 		return c_int(errc)
 	}
 }
 
 func hostFtruncate(path0 *c_char, size0 c_fuse_off_t, fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Ftruncate", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	errc := fsop.Truncate(path, int64(size0), uint64(fi0.fh))
 	return c_int(errc)
@@ -499,18 +735,35 @@ func hostFtruncate(path0 *c_char, size0 c_fuse_off_t, fi0 *c_struct_fuse_file_in
 
 func hostFgetattr(path0 *c_char, stat0 *c_fuse_stat_t,
 	fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Fgetattr", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	stat := &Stat_t{}
-	errc := fsop.Getattr(path, stat, uint64(fi0.fh))
+	var errc int
+	intf, ok := fsop.(FileSystemNode)
+	if ok {
+		errc = host.nodeGetattr(intf, path, stat, uint64(fi0.fh))
+	} else {
+		errc = fsop.Getattr(path, stat, uint64(fi0.fh))
+		host.nodeGetattrDone(path, errc)
+	}
 	copyCstatFromFusestat(stat0, stat)
 	return c_int(errc)
 }
 
 func hostUtimens(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Utimens", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	path := c_GoString(path0)
 	if nil == tmsp0 {
 		errc := fsop.Utimens(path, nil)
@@ -527,8 +780,13 @@ func hostUtimens(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
 
 func hostGetpath(path0 *c_char, buff0 *c_char, size0 c_size_t,
 	fi0 *c_struct_fuse_file_info) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Getpath", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	intf, ok := fsop.(FileSystemGetpath)
 	if !ok {
 		return -c_int(ENOSYS)
@@ -549,8 +807,13 @@ func hostGetpath(path0 *c_char, buff0 *c_char, size0 c_size_t,
 }
 
 func hostSetchgtime(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Setchgtime", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	intf, ok := fsop.(FileSystemSetchgtime)
 	if !ok {
 		// say we did it!
@@ -564,8 +827,13 @@ func hostSetchgtime(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
 }
 
 func hostSetcrtime(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Setcrtime", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	intf, ok := fsop.(FileSystemSetcrtime)
 	if !ok {
 		// say we did it!
@@ -579,8 +847,13 @@ func hostSetcrtime(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
 }
 
 func hostChflags(path0 *c_char, flags c_uint32_t) (errc0 c_int) {
-	defer recoverAsErrno(&errc0)
-	fsop := hostHandleGet(c_fuse_get_context().private_data).fsop
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("Chflags", &errc0)
+	if errc := host.enter(hostOpMeta); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpMeta)
+	fsop := host.fsop
 	intf, ok := fsop.(FileSystemChflags)
 	if !ok {
 		// say we did it!
@@ -591,13 +864,74 @@ func hostChflags(path0 *c_char, flags c_uint32_t) (errc0 c_int) {
 	return c_int(errc)
 }
 
+func hostReadBuf(path0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
+	fi0 *c_struct_fuse_file_info, fd0 *c_int, pos0 *c_int64_t) (errc0 c_int) {
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("ReadBuf", &errc0)
+	if errc := host.enter(hostOpData); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpData)
+	fsop := host.fsop
+	intf, ok := fsop.(FileSystemReadBuf)
+	if !ok {
+		return -c_int(ENOSYS)
+	}
+	path := c_GoString(path0)
+	errc, fd, pos := intf.ReadBuf(path, int(size0), int64(ofst0), uint64(fi0.fh))
+	*fd0 = c_int(fd)
+	*pos0 = c_int64_t(pos)
+	return c_int(errc)
+}
+
+func hostWriteBuf(path0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
+	fi0 *c_struct_fuse_file_info, fd0 *c_int, pos0 *c_int64_t) (errc0 c_int) {
+	host := hostHandleGet(c_fuse_get_context().private_data)
+	defer host.recoverAsErrno("WriteBuf", &errc0)
+	if errc := host.enter(hostOpData); 0 != errc {
+		return c_int(errc)
+	}
+	defer host.leave(hostOpData)
+	fsop := host.fsop
+	intf, ok := fsop.(FileSystemReadBuf)
+	if !ok {
+		return -c_int(ENOSYS)
+	}
+	path := c_GoString(path0)
+	errc, fd, pos := intf.WriteBuf(path, int(size0), int64(ofst0), uint64(fi0.fh))
+	*fd0 = c_int(fd)
+	*pos0 = c_int64_t(pos)
+	return c_int(errc)
+}
+
 // NewFileSystemHost creates a file system host.
 func NewFileSystemHost(fsop FileSystemInterface) *FileSystemHost {
 	host := &FileSystemHost{}
 	host.fsop = fsop
+	host.sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	host.drainTimeout = 30 * time.Second
+	switch fsop.(type) {
+	case FileSystemNode, FileSystemLookup:
+		host.nodes = newHostNodeTable()
+	}
+	if intf, ok := fsop.(interface{ dontMask() bool }); ok {
+		host.capDontMask = intf.dontMask()
+	}
 	return host
 }
 
+// SetSignals sets the signals that cause the host to unmount its file system [UNIX only].
+// By default a host unmounts its file system on SIGINT and SIGTERM. Calling SetSignals
+// with no arguments disables signal handling for this host, which is useful when several
+// hosts are mounted in the same process and the process manages their lifetime itself.
+//
+// Signals are routed to every mounted host that has asked for them, so that a SIGINT
+// unmounts all hosts that use the default setting while leaving other hosts mounted.
+// SetSignals must be called prior to Mount.
+func (host *FileSystemHost) SetSignals(sigs ...os.Signal) {
+	host.sigs = append([]os.Signal(nil), sigs...)
+}
+
 // SetCapCaseInsensitive informs the host that the hosted file system is case insensitive
 // [OSX and Windows only].
 func (host *FileSystemHost) SetCapCaseInsensitive(value bool) {
@@ -605,8 +939,10 @@ func (host *FileSystemHost) SetCapCaseInsensitive(value bool) {
 }
 
 // SetCapReaddirPlus informs the host that the hosted file system has the readdir-plus
-// capability [Windows only]. A file system that has the readdir-plus capability can send
-// full stat information during Readdir, thus avoiding extraneous Getattr calls.
+// capability [Windows and libfuse3 only]. A file system that has the readdir-plus
+// capability can send full stat information during Readdir, thus avoiding extraneous
+// Getattr calls. On libfuse3 the stat information is passed to the kernel using
+// FUSE_FILL_DIR_PLUS; readdir-plus is disabled if the capability is not set.
 func (host *FileSystemHost) SetCapReaddirPlus(value bool) {
 	host.capReaddirPlus = value
 }
@@ -618,6 +954,24 @@ func (host *FileSystemHost) SetCapDeleteAccess(value bool) {
 	host.capDeleteAccess = value
 }
 
+// SetCapDontMask informs the host that the hosted file system applies the umask of the
+// caller (see Getumask) to the mode of new files itself [Linux only]. Otherwise the
+// kernel applies the umask before calling Mknod, Mkdir or Create. SetCapDontMask must
+// be called prior to Mount.
+func (host *FileSystemHost) SetCapDontMask(value bool) {
+	host.capDontMask = value
+}
+
+// SetAdmission sets limits on the number of file system operations that the host lets
+// into the file system concurrently. By default there are no limits. SetAdmission must
+// be called prior to Mount.
+//
+// Admission control does not limit the threads that the FUSE implementation creates;
+// use the MaxThreads option of MountOptions (libfuse3 and WinFsp) for that purpose.
+func (host *FileSystemHost) SetAdmission(adm Admission) {
+	host.admit = newHostAdmission(adm)
+}
+
 // Mount mounts a file system on the given mountpoint with the mount options in opts.
 //
 // Many of the mount options in opts are specific to the underlying FUSE implementation.
@@ -682,24 +1036,28 @@ func (host *FileSystemHost) Mount(mountpoint string, opts []string) bool {
 	 * We need to determine the mountpoint that FUSE is going (to try) to use, so that we
 	 * can unmount later.
 	 */
-	if "" != mountpoint {
-		host.mntp = mountpoint
-	} else {
+	mntp := mountpoint
+	if "" == mntp {
 		outargs, _ := OptParse(opts, "")
 		if 1 <= len(outargs) {
-			host.mntp = outargs[0]
+			mntp = outargs[0]
 		}
 	}
-	if "" != host.mntp {
-		if "windows" != runtime.GOOS || 2 != len(host.mntp) || ':' != host.mntp[1] {
-			abs, err := filepath.Abs(host.mntp)
+	if "" != mntp {
+		if "windows" != runtime.GOOS || 2 != len(mntp) || ':' != mntp[1] {
+			abs, err := filepath.Abs(mntp)
 			if nil == err {
-				host.mntp = abs
+				mntp = abs
 			}
 		}
 	}
+	hostGuard.Lock()
+	host.mntp = mntp
+	hostGuard.Unlock()
 	defer func() {
+		hostGuard.Lock()
 		host.mntp = ""
+		hostGuard.Unlock()
 	}()
 
 	/*
@@ -732,28 +1090,49 @@ func (host *FileSystemHost) Mount(mountpoint string, opts []string) bool {
 	 */
 	hndl := hostHandleNew(host)
 	defer hostHandleDel(hndl)
-	return 0 != c_hostMount(c_int(argc), &argv[0], hndl)
+	_, bufio := host.fsop.(FileSystemReadBuf)
+	return 0 != c_hostMount(c_int(argc), &argv[0], hndl, c_bool(bufio))
 }
 
 // Unmount unmounts a mounted file system.
 // Unmount may be called at any time after the Init() method has been called
 // and before the Destroy() method has been called.
+//
+// Unmount only affects this host; other hosts mounted in the same process remain mounted.
 func (host *FileSystemHost) Unmount() bool {
-	if nil == host.fuse {
+	hostGuard.Lock()
+	fuse, mntp0 := host.fuse, host.mntp
+	hostGuard.Unlock()
+	if nil == fuse {
 		return false
 	}
 	var mntp *c_char
-	if "" != host.mntp {
-		mntp = c_CString(host.mntp)
+	if "" != mntp0 {
+		mntp = c_CString(mntp0)
 		defer c_free(unsafe.Pointer(mntp))
 	}
-	return 0 != c_hostUnmount(host.fuse, mntp)
+	return 0 != c_hostUnmount(fuse, mntp)
+}
+
+// Mountpoint returns the absolute path of the mountpoint of a mounted file system.
+// It returns the empty string if the host is not mounted or if the mountpoint
+// could not be determined.
+func (host *FileSystemHost) Mountpoint() string {
+	hostGuard.Lock()
+	defer hostGuard.Unlock()
+	if nil == host.fuse {
+		return ""
+	}
+	return host.mntp
 }
 
 // Notify notifies the operating system about a file change.
 // The action is a combination of the fuse.NOTIFY_* constants.
 func (host *FileSystemHost) Notify(path string, action uint32) bool {
-	if nil == host.fuse {
+	hostGuard.Lock()
+	fuse := host.fuse
+	hostGuard.Unlock()
+	if nil == fuse {
 		return false
 	}
 	if "" == path {
@@ -762,7 +1141,14 @@ func (host *FileSystemHost) Notify(path string, action uint32) bool {
 	var p *c_char
 	p = c_CString(path)
 	defer c_free(unsafe.Pointer(p))
-	return 0 != c_hostNotify(host.fuse, p, c_uint32_t(action))
+	return 0 != c_hostNotify(fuse, p, c_uint32_t(action))
+}
+
+// Getumask gets the umask of the process that caused the current file system operation.
+// It is meaningful during Mknod, Mkdir and Create and returns 0 where the FUSE
+// implementation does not report it [Linux and Windows only].
+func Getumask() uint32 {
+	return uint32(c_hostGetumask())
 }
 
 // Getcontext gets information related to a file system operation.
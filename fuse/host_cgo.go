@@ -38,6 +38,7 @@ package fuse
 #if defined(__APPLE__) || defined(__FreeBSD__) || defined(__NetBSD__) || defined(__OpenBSD__) || defined(__linux__)
 
 #include <dlfcn.h>
+#include <errno.h>
 #include <pthread.h>
 #include <spawn.h>
 #include <sys/mount.h>
@@ -114,7 +115,29 @@ static void cgofuse_init_fail(void)
 
 #if defined(__APPLE__) || defined(__FreeBSD__) || defined(__NetBSD__) || defined(__OpenBSD__) || defined(__linux__)
 
+#if defined(CGOFUSE_FUSE3)
+#undef FUSE_USE_VERSION
+#define FUSE_USE_VERSION 35
+#include <fuse3/fuse.h>
+#else
 #include <fuse.h>
+#endif
+
+#if defined(CGOFUSE_FUSE3)
+#if FUSE_VERSION >= FUSE_MAKE_VERSION(3, 16)
+// kernel passthrough (Linux 6.9+); see linux/fuse.h
+#include <sys/ioctl.h>
+#define CGOFUSE_PASSTHROUGH
+struct cgofuse_backing_map
+{
+	int32_t fd;
+	uint32_t flags;
+	uint64_t padding;
+};
+#define CGOFUSE_DEV_IOC_BACKING_OPEN	_IOW(229, 1, struct cgofuse_backing_map)
+#define CGOFUSE_DEV_IOC_BACKING_CLOSE	_IOW(229, 2, uint32_t)
+#endif
+#endif
 
 #if defined(__OpenBSD__)
 static int (*pfn_fuse_main)(int argc, char *argv[],
@@ -127,6 +150,17 @@ static struct fuse_context *(*pfn_fuse_get_context)(void);
 static int (*pfn_fuse_opt_parse)(struct fuse_args *args, void *data,
     const struct fuse_opt opts[], fuse_opt_proc_t proc);
 static void (*pfn_fuse_opt_free_args)(struct fuse_args *args);
+#if defined(__linux__)
+// optional
+static size_t (*pfn_fuse_buf_size)(const struct fuse_bufvec *bufv);
+static ssize_t (*pfn_fuse_buf_copy)(struct fuse_bufvec *dst, struct fuse_bufvec *src,
+    enum fuse_buf_copy_flags flags);
+#endif
+#if defined(CGOFUSE_PASSTHROUGH)
+// optional
+static struct fuse_session *(*pfn_fuse_get_session)(struct fuse *f);
+static int (*pfn_fuse_session_fd)(struct fuse_session *se);
+#endif
 
 static inline int inl_fuse_main_real(int argc, char *argv[],
     const struct fuse_operations *ops, size_t opsize, void *data)
@@ -160,6 +194,8 @@ static inline void inl_fuse_opt_free_args(struct fuse_args *args)
 #define fuse_get_context		inl_fuse_get_context
 #define fuse_opt_parse			inl_fuse_opt_parse
 #define fuse_opt_free_args		inl_fuse_opt_free_args
+#define fuse_buf_size			fuse_buf_size_DO_NOT_USE
+#define fuse_buf_copy			fuse_buf_copy_DO_NOT_USE
 
 static void *cgofuse_init_fuse(void)
 {
@@ -180,6 +216,8 @@ static void *cgofuse_init_fuse(void)
 	h = dlopen("librefuse.so.2", RTLD_NOW);
 #elif defined(__OpenBSD__)
 	h = dlopen("libfuse.so.2.0", RTLD_NOW);
+#elif defined(__linux__) && defined(CGOFUSE_FUSE3)
+	h = dlopen("libfuse3.so.3", RTLD_NOW);
 #elif defined(__linux__)
 	h = dlopen("libfuse.so.2", RTLD_NOW);
 #endif
@@ -195,6 +233,16 @@ static void *cgofuse_init_fuse(void)
 	CGOFUSE_GET_API(fuse_opt_parse);
 	CGOFUSE_GET_API(fuse_opt_free_args);
 
+#if defined(__linux__)
+	// optional
+	*(void **)&pfn_fuse_buf_size = dlsym(h, "fuse_buf_size");
+	*(void **)&pfn_fuse_buf_copy = dlsym(h, "fuse_buf_copy");
+#endif
+#if defined(CGOFUSE_PASSTHROUGH)
+	*(void **)&pfn_fuse_get_session = dlsym(h, "fuse_get_session");
+	*(void **)&pfn_fuse_session_fd = dlsym(h, "fuse_session_fd");
+#endif
+
 	return h;
 
 #undef CGOFUSE_GET_API
@@ -364,16 +412,32 @@ extern int go_hostGetpath(char *path, char *buf, size_t size,
 extern int go_hostSetchgtime(char *path, fuse_timespec_t *tv);
 extern int go_hostSetcrtime(char *path, fuse_timespec_t *tv);
 extern int go_hostChflags(char *path, uint32_t flags);
+extern int go_hostReadBuf(char *path, size_t size, fuse_off_t off, struct fuse_file_info *fi,
+	int *fd, int64_t *pos);
+extern int go_hostWriteBuf(char *path, size_t size, fuse_off_t off, struct fuse_file_info *fi,
+	int *fd, int64_t *pos);
 
 static inline void hostAsgnCconninfo(struct fuse_conn_info *conn,
 	bool capCaseInsensitive,
 	bool capReaddirPlus,
-	bool capDeleteAccess)
+	bool capDeleteAccess,
+	bool capDontMask)
 {
 #if defined(__APPLE__)
 	if (capCaseInsensitive)
 		FUSE_ENABLE_CASE_INSENSITIVE(conn);
-#elif defined(__FreeBSD__) || defined(__NetBSD__) || defined(__OpenBSD__) || defined(__linux__)
+#elif defined(__linux__) && defined(CGOFUSE_FUSE3)
+	// libfuse3 enables readdirplus by default; only keep it if we can supply stat data
+	if (capReaddirPlus)
+		conn->want |= conn->capable & FUSE_CAP_READDIRPLUS;
+	else
+		conn->want &= ~(FUSE_CAP_READDIRPLUS | FUSE_CAP_READDIRPLUS_AUTO);
+	if (capDontMask)
+		conn->want |= conn->capable & FUSE_CAP_DONT_MASK;
+#elif defined(__linux__)
+	if (capDontMask)
+		conn->want |= conn->capable & FUSE_CAP_DONT_MASK;
+#elif defined(__FreeBSD__) || defined(__NetBSD__) || defined(__OpenBSD__)
 #elif defined(_WIN32)
 #if defined(FSP_FUSE_CAP_STAT_EX)
 	conn->want |= conn->capable & FSP_FUSE_CAP_STAT_EX;
@@ -388,6 +452,15 @@ static inline void hostAsgnCconninfo(struct fuse_conn_info *conn,
 #endif
 }
 
+static inline unsigned hostGetumask(void)
+{
+#if defined(__linux__) || defined(_WIN32)
+	return fuse_get_context()->umask;
+#else
+	return 0;
+#endif
+}
+
 static inline void hostCstatvfsFromFusestatfs(fuse_statvfs_t *stbuf,
 	uint64_t bsize,
 	uint64_t frsize,
@@ -487,20 +560,82 @@ static inline void hostAsgnCfileinfo(struct fuse_file_info *fi,
 	bool direct_io,
 	bool keep_cache,
 	bool nonseekable,
+	bool cache_readdir,
+	bool parallel_direct_writes,
 	uint64_t fh)
 {
 	fi->direct_io = direct_io;
 	fi->keep_cache = keep_cache;
 #if !defined(__NetBSD__)
 	fi->nonseekable = nonseekable;
+#endif
+#if defined(CGOFUSE_FUSE3)
+	fi->cache_readdir = cache_readdir;
+#if FUSE_VERSION >= FUSE_MAKE_VERSION(3, 15)
+	fi->parallel_direct_writes = parallel_direct_writes;
+#endif
 #endif
 	fi->fh = fh;
 }
 
+static inline bool hostCfileinfoFlush(struct fuse_file_info *fi)
+{
+	return fi->flush;
+}
+
+static inline uint64_t hostCfileinfoLockOwner(struct fuse_file_info *fi)
+{
+	return fi->lock_owner;
+}
+
+static inline bool hostPassthroughInit(struct fuse_conn_info *conn)
+{
+#if defined(CGOFUSE_PASSTHROUGH)
+	if (0 != pfn_fuse_get_session && 0 != pfn_fuse_session_fd &&
+		0 != (conn->capable & FUSE_CAP_PASSTHROUGH))
+	{
+		conn->want |= FUSE_CAP_PASSTHROUGH;
+		return true;
+	}
+#endif
+	return false;
+}
+
+static inline int hostPassthroughOpen(struct fuse_file_info *fi, int fd)
+{
+#if defined(CGOFUSE_PASSTHROUGH)
+	// the kernel refuses passthrough opens that request page cache behavior
+	if (fi->keep_cache || fi->nonseekable || fi->cache_readdir)
+		return 0;
+	struct fuse_session *se = pfn_fuse_get_session(fuse_get_context()->fuse);
+	struct cgofuse_backing_map map = { .fd = fd };
+	int id = ioctl(pfn_fuse_session_fd(se), CGOFUSE_DEV_IOC_BACKING_OPEN, &map);
+	if (0 >= id)
+		return 0;
+	fi->backing_id = id;
+	return id;
+#else
+	return 0;
+#endif
+}
+
+static inline void hostPassthroughClose(struct fuse *f, int id)
+{
+#if defined(CGOFUSE_PASSTHROUGH)
+	struct fuse_session *se = pfn_fuse_get_session(f);
+	uint32_t id32 = id;
+	ioctl(pfn_fuse_session_fd(se), CGOFUSE_DEV_IOC_BACKING_CLOSE, &id32);
+#endif
+}
+
 static inline int hostFilldir(fuse_fill_dir_t filler, void *buf,
-	char *name, fuse_stat_t *stbuf, fuse_off_t off)
+	char *name, fuse_stat_t *stbuf, fuse_off_t off, bool plus)
 {
+#if defined(CGOFUSE_FUSE3)
+	return filler(buf, name, stbuf, off, plus && 0 != stbuf ? FUSE_FILL_DIR_PLUS : 0);
+#else
 	return filler(buf, name, stbuf, off);
+#endif
 }
 
 #if defined(__APPLE__)
@@ -521,6 +656,134 @@ static int _hostGetxattr(char *path, char *name, char *value, size_t size,
 #define _hostGetxattr go_hostGetxattr
 #endif
 
+#if defined(__linux__)
+// read_buf and write_buf let a file system designate a file descriptor as the source or
+// destination of data, which libfuse can then transfer without copying it through Go.
+static int _hostReadBuf(char *path, struct fuse_bufvec **bufp, size_t size, fuse_off_t off,
+	struct fuse_file_info *fi)
+{
+	struct fuse_bufvec *bufv;
+	int fd = -1;
+	int64_t pos = 0;
+	int res;
+	bufv = malloc(sizeof *bufv);
+	if (0 == bufv)
+		return -ENOMEM;
+	*bufv = FUSE_BUFVEC_INIT(size);
+	res = go_hostReadBuf(path, size, off, fi, &fd, &pos);
+	if (-ENOSYS == res)
+	{
+		// libfuse frees the memory buffer using free()
+		void *mem = malloc(0 != size ? size : 1);
+		if (0 == mem)
+		{
+			free(bufv);
+			return -ENOMEM;
+		}
+		res = go_hostRead(path, mem, size, off, fi);
+		if (0 > res)
+		{
+			free(mem);
+			free(bufv);
+			return res;
+		}
+		bufv->buf[0].mem = mem;
+		bufv->buf[0].size = res;
+	}
+	else if (0 == res)
+	{
+		bufv->buf[0].flags = FUSE_BUF_IS_FD | FUSE_BUF_FD_SEEK;
+		bufv->buf[0].fd = fd;
+		bufv->buf[0].pos = pos;
+	}
+	else
+	{
+		free(bufv);
+		return res;
+	}
+	*bufp = bufv;
+	return 0;
+}
+static int _hostWriteBuf(char *path, struct fuse_bufvec *buf, fuse_off_t off,
+	struct fuse_file_info *fi)
+{
+	size_t size = pfn_fuse_buf_size(buf);
+	int fd = -1;
+	int64_t pos = 0;
+	int res;
+	res = go_hostWriteBuf(path, size, off, fi, &fd, &pos);
+	if (-ENOSYS == res)
+	{
+		if (1 == buf->count && 0 == buf->idx && 0 == buf->off &&
+			0 == (buf->buf[0].flags & FUSE_BUF_IS_FD))
+			return go_hostWrite(path, buf->buf[0].mem, size, off, fi);
+		void *mem = malloc(0 != size ? size : 1);
+		if (0 == mem)
+			return -ENOMEM;
+		struct fuse_bufvec dst = FUSE_BUFVEC_INIT(size);
+		dst.buf[0].mem = mem;
+		ssize_t n = pfn_fuse_buf_copy(&dst, buf, 0);
+		res = 0 > n ? (int)n : go_hostWrite(path, mem, n, off, fi);
+		free(mem);
+		return res;
+	}
+	else if (0 == res)
+	{
+		struct fuse_bufvec dst = FUSE_BUFVEC_INIT(size);
+		dst.buf[0].flags = FUSE_BUF_IS_FD | FUSE_BUF_FD_SEEK;
+		dst.buf[0].fd = fd;
+		dst.buf[0].pos = pos;
+		return (int)pfn_fuse_buf_copy(&dst, buf, FUSE_BUF_SPLICE_NONBLOCK);
+	}
+	else
+		return res;
+}
+#endif
+
+#if defined(CGOFUSE_FUSE3)
+// libfuse3 merges some operations and adds parameters to others; adapt them here.
+static int _hostGetattr(char *path, fuse_stat_t *stbuf, struct fuse_file_info *fi)
+{
+	if (0 != fi)
+		return go_hostFgetattr(path, stbuf, fi);
+	return go_hostGetattr(path, stbuf);
+}
+static int _hostRename(char *oldpath, char *newpath, unsigned int flags)
+{
+	// RENAME_NOREPLACE and RENAME_EXCHANGE are not supported
+	if (0 != flags)
+		return -EINVAL;
+	return go_hostRename(oldpath, newpath);
+}
+static int _hostChmod(char *path, fuse_mode_t mode, struct fuse_file_info *fi)
+{
+	return go_hostChmod(path, mode);
+}
+static int _hostChown(char *path, fuse_uid_t uid, fuse_gid_t gid, struct fuse_file_info *fi)
+{
+	return go_hostChown(path, uid, gid);
+}
+static int _hostTruncate(char *path, fuse_off_t size, struct fuse_file_info *fi)
+{
+	if (0 != fi)
+		return go_hostFtruncate(path, size, fi);
+	return go_hostTruncate(path, size);
+}
+static int _hostReaddir(char *path, void *buf, fuse_fill_dir_t filler, fuse_off_t off,
+	struct fuse_file_info *fi, enum fuse_readdir_flags flags)
+{
+	return go_hostReaddir(path, buf, filler, off, fi);
+}
+static void *_hostInit(struct fuse_conn_info *conn, struct fuse_config *cfg)
+{
+	return go_hostInit(conn);
+}
+static int _hostUtimens(char *path, fuse_timespec_t tv[2], struct fuse_file_info *fi)
+{
+	return go_hostUtimens(path, tv);
+}
+#endif
+
 // hostStaticInit, hostFuseInit and hostInit serve different purposes.
 //
 // hostStaticInit and hostFuseInit are needed to provide static and dynamic initialization
@@ -538,22 +801,37 @@ static int hostFuseInit(void)
 	return 0 != cgofuse_init_fast(0);
 }
 
-static int hostMount(int argc, char *argv[], void *data)
+static int hostMount(int argc, char *argv[], void *data, bool bufio)
 {
 	static struct fuse_operations fsop =
 	{
+#if defined(CGOFUSE_FUSE3)
+		.getattr = (int (*)(const char *, fuse_stat_t *, struct fuse_file_info *))_hostGetattr,
+#else
 		.getattr = (int (*)(const char *, fuse_stat_t *))go_hostGetattr,
+#endif
 		.readlink = (int (*)(const char *, char *, size_t))go_hostReadlink,
 		.mknod = (int (*)(const char *, fuse_mode_t, fuse_dev_t))go_hostMknod,
 		.mkdir = (int (*)(const char *, fuse_mode_t))go_hostMkdir,
 		.unlink = (int (*)(const char *))go_hostUnlink,
 		.rmdir = (int (*)(const char *))go_hostRmdir,
 		.symlink = (int (*)(const char *, const char *))go_hostSymlink,
+#if defined(CGOFUSE_FUSE3)
+		.rename = (int (*)(const char *, const char *, unsigned int))_hostRename,
+#else
 		.rename = (int (*)(const char *, const char *))go_hostRename,
+#endif
 		.link = (int (*)(const char *, const char *))go_hostLink,
+#if defined(CGOFUSE_FUSE3)
+		.chmod = (int (*)(const char *, fuse_mode_t, struct fuse_file_info *))_hostChmod,
+		.chown = (int (*)(const char *, fuse_uid_t, fuse_gid_t, struct fuse_file_info *))
+			_hostChown,
+		.truncate = (int (*)(const char *, fuse_off_t, struct fuse_file_info *))_hostTruncate,
+#else
 		.chmod = (int (*)(const char *, fuse_mode_t))go_hostChmod,
 		.chown = (int (*)(const char *, fuse_uid_t, fuse_gid_t))go_hostChown,
 		.truncate = (int (*)(const char *, fuse_off_t))go_hostTruncate,
+#endif
 		.open = (int (*)(const char *, struct fuse_file_info *))go_hostOpen,
 		.read = (int (*)(const char *, char *, size_t, fuse_off_t, struct fuse_file_info *))
 			go_hostRead,
@@ -575,19 +853,35 @@ static int hostMount(int argc, char *argv[], void *data)
 		.listxattr = (int (*)(const char *, char *, size_t))go_hostListxattr,
 		.removexattr = (int (*)(const char *, const char *))go_hostRemovexattr,
 		.opendir = (int (*)(const char *, struct fuse_file_info *))go_hostOpendir,
+#if defined(CGOFUSE_FUSE3)
+		.readdir = (int (*)(const char *, void *, fuse_fill_dir_t, fuse_off_t,
+			struct fuse_file_info *, enum fuse_readdir_flags))_hostReaddir,
+#else
 		.readdir = (int (*)(const char *, void *, fuse_fill_dir_t, fuse_off_t,
 			struct fuse_file_info *))go_hostReaddir,
+#endif
 		.releasedir = (int (*)(const char *, struct fuse_file_info *))go_hostReleasedir,
 		.fsyncdir = (int (*)(const char *, int, struct fuse_file_info *))go_hostFsyncdir,
+#if defined(CGOFUSE_FUSE3)
+		.init = (void *(*)(struct fuse_conn_info *, struct fuse_config *))_hostInit,
+#else
 		.init = (void *(*)(struct fuse_conn_info *))go_hostInit,
+#endif
 		.destroy = (void (*)(void *))go_hostDestroy,
 		.access = (int (*)(const char *, int))go_hostAccess,
 		.create = (int (*)(const char *, fuse_mode_t, struct fuse_file_info *))go_hostCreate,
+#if !defined(CGOFUSE_FUSE3)
 		.ftruncate = (int (*)(const char *, fuse_off_t, struct fuse_file_info *))go_hostFtruncate,
 		.fgetattr = (int (*)(const char *, fuse_stat_t *, struct fuse_file_info *))go_hostFgetattr,
+#endif
 		//.lock = (int (*)(const char *, struct fuse_file_info *, int, struct fuse_flock *))
 		//	go_hostFlock,
+#if defined(CGOFUSE_FUSE3)
+		.utimens = (int (*)(const char *, const fuse_timespec_t [2], struct fuse_file_info *))
+			_hostUtimens,
+#else
 		.utimens = (int (*)(const char *, const fuse_timespec_t [2]))go_hostUtimens,
+#endif
 #if defined(__APPLE__) || (defined(_WIN32) && defined(FSP_FUSE_CAP_STAT_EX))
 		.setchgtime = (int (*)(const char *, const fuse_timespec_t *))go_hostSetchgtime,
 		.setcrtime = (int (*)(const char *, const fuse_timespec_t *))go_hostSetcrtime,
@@ -615,6 +909,17 @@ static int hostMount(int argc, char *argv[], void *data)
 	// write the same value to getpath/reserved00 (and because writes of aligned pointer
 	// values are atomic so that no half writes can be observed).
 	((void **)&fsop)[45] = go_hostGetpath;
+#endif
+#if defined(__linux__)
+	if (bufio && 0 != pfn_fuse_buf_size && 0 != pfn_fuse_buf_copy)
+	{
+		struct fuse_operations fsop_bufio = fsop;
+		fsop_bufio.read_buf = (int (*)(const char *, struct fuse_bufvec **, size_t, fuse_off_t,
+			struct fuse_file_info *))_hostReadBuf;
+		fsop_bufio.write_buf = (int (*)(const char *, struct fuse_bufvec *, fuse_off_t,
+			struct fuse_file_info *))_hostWriteBuf;
+		return 0 == fuse_main_real(argc, argv, &fsop_bufio, sizeof fsop_bufio, data);
+	}
 #endif
 	return 0 == fuse_main_real(argc, argv, &fsop, sizeof fsop, data);
 }
@@ -636,8 +941,13 @@ static int hostUnmount(struct fuse *fuse, char *mountpoint)
 	// linux: umount2 failed; try fusermount
 	char *paths[] =
 	{
+#if defined(CGOFUSE_FUSE3)
+		"/bin/fusermount3",
+		"/usr/bin/fusermount3",
+#else
 		"/bin/fusermount",
 		"/usr/bin/fusermount",
+#endif
 	};
 	char *path = paths[0];
 	for (size_t i = 0; sizeof paths / sizeof paths[0] > i; i++)
@@ -775,8 +1085,12 @@ func c_fuse_opt_free_args(args *c_struct_fuse_args) {
 func c_hostAsgnCconninfo(conn *c_struct_fuse_conn_info,
 	capCaseInsensitive c_bool,
 	capReaddirPlus c_bool,
-	capDeleteAccess c_bool) {
-	C.hostAsgnCconninfo(conn, capCaseInsensitive, capReaddirPlus, capDeleteAccess)
+	capDeleteAccess c_bool,
+	capDontMask c_bool) {
+	C.hostAsgnCconninfo(conn, capCaseInsensitive, capReaddirPlus, capDeleteAccess, capDontMask)
+}
+func c_hostGetumask() c_uint32_t {
+	return c_uint32_t(C.hostGetumask())
 }
 func c_hostCstatvfsFromFusestatfs(stbuf *c_fuse_statvfs_t,
 	bsize c_uint64_t,
@@ -844,16 +1158,35 @@ func c_hostAsgnCfileinfo(fi *c_struct_fuse_file_info,
 	direct_io c_bool,
 	keep_cache c_bool,
 	nonseekable c_bool,
+	cache_readdir c_bool,
+	parallel_direct_writes c_bool,
 	fh c_uint64_t) {
 	C.hostAsgnCfileinfo(fi,
 		direct_io,
 		keep_cache,
 		nonseekable,
+		cache_readdir,
+		parallel_direct_writes,
 		fh)
 }
+func c_hostCfileinfoFlush(fi *c_struct_fuse_file_info) c_bool {
+	return C.hostCfileinfoFlush(fi)
+}
+func c_hostCfileinfoLockOwner(fi *c_struct_fuse_file_info) c_uint64_t {
+	return C.hostCfileinfoLockOwner(fi)
+}
+func c_hostPassthroughInit(conn *c_struct_fuse_conn_info) c_bool {
+	return C.hostPassthroughInit(conn)
+}
+func c_hostPassthroughOpen(fi *c_struct_fuse_file_info, fd c_int) c_int {
+	return C.hostPassthroughOpen(fi, fd)
+}
+func c_hostPassthroughClose(f *c_struct_fuse, id c_int) {
+	C.hostPassthroughClose(f, id)
+}
 func c_hostFilldir(filler c_fuse_fill_dir_t,
-	buf unsafe.Pointer, name *c_char, stbuf *c_fuse_stat_t, off c_fuse_off_t) c_int {
-	return C.hostFilldir(filler, buf, name, stbuf, off)
+	buf unsafe.Pointer, name *c_char, stbuf *c_fuse_stat_t, off c_fuse_off_t, plus c_bool) c_int {
+	return C.hostFilldir(filler, buf, name, stbuf, off, plus)
 }
 func c_hostStaticInit() {
 	C.hostStaticInit()
@@ -861,8 +1194,8 @@ func c_hostStaticInit() {
 func c_hostFuseInit() c_int {
 	return C.hostFuseInit()
 }
-func c_hostMount(argc c_int, argv **c_char, data unsafe.Pointer) c_int {
-	return C.hostMount(argc, argv, data)
+func c_hostMount(argc c_int, argv **c_char, data unsafe.Pointer, bufio c_bool) c_int {
+	return C.hostMount(argc, argv, data, bufio)
 }
 func c_hostUnmount(fuse *c_struct_fuse, mountpoint *c_char) c_int {
 	return C.hostUnmount(fuse, mountpoint)
@@ -1076,3 +1409,15 @@ func go_hostSetcrtime(path0 *c_char, tmsp0 *c_fuse_timespec_t) (errc0 c_int) {
 func go_hostChflags(path0 *c_char, flags c_uint32_t) (errc0 c_int) {
 	return hostChflags(path0, flags)
 }
+
+//export go_hostReadBuf
+func go_hostReadBuf(path0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
+	fi0 *c_struct_fuse_file_info, fd0 *c_int, pos0 *c_int64_t) (errc0 c_int) {
+	return hostReadBuf(path0, size0, ofst0, fi0, fd0, pos0)
+}
+
+//export go_hostWriteBuf
+func go_hostWriteBuf(path0 *c_char, size0 c_size_t, ofst0 c_fuse_off_t,
+	fi0 *c_struct_fuse_file_info, fd0 *c_int, pos0 *c_int64_t) (errc0 c_int) {
+	return hostWriteBuf(path0, size0, ofst0, fi0, fd0, pos0)
+}
@@ -0,0 +1,24 @@
+//go:build cgo && linux && fuse3
+// +build cgo,linux,fuse3
+
+/*
+ * host_cgo_fuse3.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+/*
+#cgo linux CFLAGS: -DCGOFUSE_FUSE3
+*/
+import "C"
+
+// hostFuse3 is true when the host is built against libfuse3.
+const hostFuse3 = true
@@ -298,6 +298,9 @@ func c_fuse_get_context() *c_struct_fuse_context {
 	p, _, _ := fuse_get_context.Call()
 	return (*c_struct_fuse_context)(unsafe.Pointer(p))
 }
+func c_hostGetumask() c_uint32_t {
+	return c_uint32_t(c_fuse_get_context().umask)
+}
 func c_fuse_opt_free_args(args *c_struct_fuse_args) {
 	fuse_opt_free_args.Call(uintptr(unsafe.Pointer(args)))
 }
@@ -305,7 +308,8 @@ func c_fuse_opt_free_args(args *c_struct_fuse_args) {
 func c_hostAsgnCconninfo(conn *c_struct_fuse_conn_info,
 	capCaseInsensitive c_bool,
 	capReaddirPlus c_bool,
-	capDeleteAccess c_bool) {
+	capDeleteAccess c_bool,
+	capDontMask c_bool) {
 	conn.want |= conn.capable & FSP_FUSE_CAP_STAT_EX
 	cgofuse_stat_ex = 0 != conn.want&FSP_FUSE_CAP_STAT_EX // hack!
 	if capCaseInsensitive {
@@ -426,6 +430,8 @@ func c_hostAsgnCfileinfo(fi *c_struct_fuse_file_info,
 	direct_io c_bool,
 	keep_cache c_bool,
 	nonseekable c_bool,
+	cache_readdir c_bool,
+	parallel_direct_writes c_bool,
 	fh c_uint64_t) {
 	if direct_io {
 		fi.bits |= 1
@@ -438,8 +444,22 @@ func c_hostAsgnCfileinfo(fi *c_struct_fuse_file_info,
 	}
 	fi.fh = fh
 }
+func c_hostCfileinfoFlush(fi *c_struct_fuse_file_info) c_bool {
+	return 0 != fi.bits&4
+}
+func c_hostCfileinfoLockOwner(fi *c_struct_fuse_file_info) c_uint64_t {
+	return fi.lock_owner
+}
+func c_hostPassthroughInit(conn *c_struct_fuse_conn_info) c_bool {
+	return false
+}
+func c_hostPassthroughOpen(fi *c_struct_fuse_file_info, fd c_int) c_int {
+	return 0
+}
+func c_hostPassthroughClose(f *c_struct_fuse, id c_int) {
+}
 func c_hostFilldir(filler c_fuse_fill_dir_t,
-	buf unsafe.Pointer, name *c_char, stbuf *c_fuse_stat_t, off c_fuse_off_t) c_int {
+	buf unsafe.Pointer, name *c_char, stbuf *c_fuse_stat_t, off c_fuse_off_t, plus c_bool) c_int {
 	var r uintptr
 	if uint64(0xffffffff) < uint64(^uintptr(0)) {
 		r, _, _ = syscall.Syscall6(filler, 4,
@@ -480,7 +500,7 @@ func c_hostFuseInit() c_int {
 	}
 	return 1
 }
-func c_hostMount(argc c_int, argv **c_char, data unsafe.Pointer) c_int {
+func c_hostMount(argc c_int, argv **c_char, data unsafe.Pointer, bufio c_bool) c_int {
 	r, _, _ := fuse_main_real.Call(
 		uintptr(argc),
 		uintptr(unsafe.Pointer(argv)),
@@ -17,6 +17,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -98,6 +99,100 @@ func testHost(t *testing.T, unmount bool) {
 	}
 }
 
+func testMounts(t *testing.T, signal bool) {
+	path, err := ioutil.TempDir("", "test")
+	if nil != err {
+		panic(err)
+	}
+	defer os.Remove(path)
+	const count = 3
+	mntp := make([]string, count)
+	for i := range mntp {
+		mntp[i] = filepath.Join(path, "m"+strconv.Itoa(i))
+		if "windows" != runtime.GOOS {
+			err = os.Mkdir(mntp[i], os.FileMode(0755))
+			if nil != err {
+				panic(err)
+			}
+			defer os.Remove(mntp[i])
+		}
+	}
+	done := make([]chan bool, count)
+	tstf := make([]*testfs, count)
+	host := make([]*FileSystemHost, count)
+	mres := make([]bool, count)
+	for i := range host {
+		done[i] = make(chan bool)
+		tstf[i] = &testfs{}
+		host[i] = NewFileSystemHost(tstf[i])
+		if signal && 1 == i {
+			host[i].SetSignals()
+		}
+		go func(i int) {
+			mres[i] = host[i].Mount(mntp[i], nil)
+			done[i] <- true
+		}(i)
+	}
+	<-time.After(3 * time.Second)
+	mnts := Mounts()
+	if count != len(mnts) {
+		t.Errorf("Mounts() returned %v hosts; expected %v", len(mnts), count)
+	}
+	for i := range host {
+		abs, _ := filepath.Abs(mntp[i])
+		if abs != host[i].Mountpoint() {
+			t.Errorf("Mountpoint() returned %q; expected %q", host[i].Mountpoint(), abs)
+		}
+	}
+	if signal {
+		if !sendInterrupt() {
+			t.Error("sendInterrupt failed")
+		}
+		<-done[0]
+		<-done[2]
+		mnts = Mounts()
+		if 1 != len(mnts) || host[1] != mnts[0] {
+			t.Errorf("Mounts() returned %v hosts; expected only the host without signals", len(mnts))
+		}
+		if !host[1].Unmount() {
+			t.Error("Unmount failed")
+		}
+		<-done[1]
+	} else {
+		if !host[1].Unmount() {
+			t.Error("Unmount failed")
+		}
+		<-done[1]
+		mnts = Mounts()
+		if 2 != len(mnts) || host[0] != mnts[0] || host[2] != mnts[1] {
+			t.Errorf("Mounts() returned %v hosts; expected the remaining 2 hosts", len(mnts))
+		}
+		if "" != host[1].Mountpoint() {
+			t.Errorf("Mountpoint() returned %q after Unmount", host[1].Mountpoint())
+		}
+		for _, i := range []int{0, 2} {
+			if !host[i].Unmount() {
+				t.Error("Unmount failed")
+			}
+			<-done[i]
+		}
+	}
+	if 0 != len(Mounts()) {
+		t.Errorf("Mounts() returned %v hosts; expected 0", len(Mounts()))
+	}
+	for i := range host {
+		if !mres[i] {
+			t.Error("Mount failed")
+		}
+		if 1 != tstf[i].init {
+			t.Errorf("Init() called %v times; expected 1", tstf[i].init)
+		}
+		if 1 != tstf[i].dstr {
+			t.Errorf("Destroy() called %v times; expected 1", tstf[i].dstr)
+		}
+	}
+}
+
 func TestUnmount(t *testing.T) {
 	testHost(t, true)
 }
@@ -107,3 +202,13 @@ func TestSignal(t *testing.T) {
 		testHost(t, false)
 	}
 }
+
+func TestMounts(t *testing.T) {
+	testMounts(t, false)
+}
+
+func TestMountsSignal(t *testing.T) {
+	if "windows" != runtime.GOOS {
+		testMounts(t, true)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * hostadmit.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Admission contains limits on the file system operations that a FileSystemHost lets
+// into the file system concurrently. A zero limit means no limit.
+//
+// Operations that cannot be admitted immediately wait in a queue. Each waiting operation
+// holds the FUSE thread that delivered it, so MaxQueued bounds the number of threads
+// blocked on a slow file system. Operations that find the queue full fail with EAGAIN
+// and operations that wait longer than QueueTimeout fail with ETIMEDOUT.
+type Admission struct {
+	// Maximum number of operations in the file system.
+	MaxInflight int
+
+	// Maximum number of metadata operations in the file system. All operations other
+	// than data operations are metadata operations.
+	MaxMetadata int
+
+	// Maximum number of data operations (Read, Write, Flush, Fsync) in the file system.
+	MaxData int
+
+	// Maximum number of operations waiting for admission.
+	MaxQueued int
+
+	// Maximum time that an operation waits for admission.
+	QueueTimeout time.Duration
+}
+
+type hostOpClass int
+
+const (
+	hostOpMeta hostOpClass = iota
+	hostOpData
+)
+
+type hostAdmission struct {
+	total  chan struct{}
+	class  [2]chan struct{}
+	maxq   int32
+	queued int32
+	tmo    time.Duration
+}
+
+func hostAdmissionSem(n int) chan struct{} {
+	if 0 >= n {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+func newHostAdmission(adm Admission) *hostAdmission {
+	if 0 >= adm.MaxInflight && 0 >= adm.MaxMetadata && 0 >= adm.MaxData {
+		return nil
+	}
+	return &hostAdmission{
+		total: hostAdmissionSem(adm.MaxInflight),
+		class: [2]chan struct{}{
+			hostOpMeta: hostAdmissionSem(adm.MaxMetadata),
+			hostOpData: hostAdmissionSem(adm.MaxData),
+		},
+		maxq: int32(adm.MaxQueued),
+		tmo:  adm.QueueTimeout,
+	}
+}
+
+// tryAcquire acquires all semaphores in sems without waiting or none of them.
+func (adm *hostAdmission) tryAcquire(sems [2]chan struct{}) bool {
+	for i, sem := range sems {
+		if nil == sem {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			adm.releaseSems(sems[:i])
+			return false
+		}
+	}
+	return true
+}
+
+func (adm *hostAdmission) releaseSems(sems []chan struct{}) {
+	for _, sem := range sems {
+		if nil != sem {
+			<-sem
+		}
+	}
+}
+
+// acquire admits an operation of the specified class, waiting if necessary.
+func (adm *hostAdmission) acquire(class hostOpClass) int {
+	// class semaphore first, so that waiting metadata operations do not hold
+	// overall slots that data operations could use and vice versa
+	sems := [2]chan struct{}{adm.class[class], adm.total}
+	if adm.tryAcquire(sems) {
+		return 0
+	}
+	if 0 < adm.maxq {
+		if atomic.AddInt32(&adm.queued, 1) > adm.maxq {
+			atomic.AddInt32(&adm.queued, -1)
+			return -EAGAIN
+		}
+		defer atomic.AddInt32(&adm.queued, -1)
+	} else {
+		atomic.AddInt32(&adm.queued, 1)
+		defer atomic.AddInt32(&adm.queued, -1)
+	}
+	var tmoc <-chan time.Time
+	if 0 < adm.tmo {
+		t := time.NewTimer(adm.tmo)
+		defer t.Stop()
+		tmoc = t.C
+	}
+	for i, sem := range sems {
+		if nil == sem {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-tmoc:
+			adm.releaseSems(sems[:i])
+			return -ETIMEDOUT
+		}
+	}
+	return 0
+}
+
+func (adm *hostAdmission) release(class hostOpClass) {
+	adm.releaseSems([]chan struct{}{adm.class[class], adm.total})
+}
+
+// enter is called at the start of every file system operation. If it returns an error
+// the operation must fail with it; otherwise leave must be called when the operation
+// completes.
+func (host *FileSystemHost) enter(class hostOpClass) int {
+	host.ops.enter()
+	if nil != host.admit {
+		if errc := host.admit.acquire(class); 0 != errc {
+			host.ops.leave()
+			return errc
+		}
+	}
+	return 0
+}
+
+func (host *FileSystemHost) leave(class hostOpClass) {
+	if nil != host.admit {
+		host.admit.release(class)
+	}
+	host.ops.leave()
+}
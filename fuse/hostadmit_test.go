@@ -0,0 +1,93 @@
+/*
+ * hostadmit_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostAdmission(t *testing.T) {
+	if nil != newHostAdmission(Admission{MaxQueued: 1}) {
+		t.Error()
+	}
+
+	adm := newHostAdmission(Admission{MaxInflight: 3, MaxData: 2, MaxQueued: 1,
+		QueueTimeout: 50 * time.Millisecond})
+
+	// data operations are limited by MaxData; metadata operations use the remainder
+	if 0 != adm.acquire(hostOpData) || 0 != adm.acquire(hostOpData) {
+		t.Error()
+	}
+	if 0 != adm.acquire(hostOpMeta) {
+		t.Error()
+	}
+
+	// waiting operation times out; further operations find the queue full
+	errc := make(chan int)
+	go func() {
+		errc <- adm.acquire(hostOpMeta)
+	}()
+	for 0 == atomic.LoadInt32(&adm.queued) {
+		time.Sleep(time.Millisecond)
+	}
+	if e := adm.acquire(hostOpData); -EAGAIN != e {
+		t.Error(e)
+	}
+	if e := <-errc; -ETIMEDOUT != e {
+		t.Error(e)
+	}
+
+	// waiting operation is admitted when another one leaves
+	go func() {
+		errc <- adm.acquire(hostOpMeta)
+	}()
+	for 0 == atomic.LoadInt32(&adm.queued) {
+		time.Sleep(time.Millisecond)
+	}
+	adm.release(hostOpData)
+	if e := <-errc; 0 != e {
+		t.Error(e)
+	}
+	if 0 != adm.queued || 3 != len(adm.total) || 1 != len(adm.class[hostOpData]) {
+		t.Error(adm.queued, len(adm.total), len(adm.class[hostOpData]))
+	}
+}
+
+func TestHostAdmissionClass(t *testing.T) {
+	adm := newHostAdmission(Admission{MaxData: 1})
+
+	// metadata operations are not limited by data operations
+	if 0 != adm.acquire(hostOpData) {
+		t.Error()
+	}
+	for i := 0; 100 > i; i++ {
+		if 0 != adm.acquire(hostOpMeta) {
+			t.Error()
+		}
+	}
+	done := make(chan int)
+	go func() {
+		done <- adm.acquire(hostOpData)
+	}()
+	select {
+	case <-done:
+		t.Error()
+	case <-time.After(10 * time.Millisecond):
+	}
+	adm.release(hostOpData)
+	if 0 != <-done {
+		t.Error()
+	}
+}
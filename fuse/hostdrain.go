@@ -0,0 +1,118 @@
+/*
+ * hostdrain.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HostStatus reports the file system operations that a FileSystemHost is processing.
+type HostStatus struct {
+	// Number of operations that have been received but have not completed. This
+	// includes operations waiting for admission.
+	Pending int
+
+	// Number of operations waiting for admission (see SetAdmission).
+	Queued int
+
+	// True while the host waits for pending operations to complete prior to calling
+	// Destroy.
+	Draining bool
+}
+
+// hostOps tracks the operations that are pending in a host.
+type hostOps struct {
+	lock    sync.Mutex
+	pending int
+	drained chan struct{}
+}
+
+func (ops *hostOps) enter() {
+	ops.lock.Lock()
+	ops.pending++
+	ops.lock.Unlock()
+}
+
+func (ops *hostOps) leave() {
+	ops.lock.Lock()
+	ops.pending--
+	if 0 == ops.pending && nil != ops.drained {
+		close(ops.drained)
+		ops.drained = nil
+	}
+	ops.lock.Unlock()
+}
+
+// drain waits until there are no pending operations or until timeout elapses. A
+// negative timeout waits indefinitely. It returns true if there are no pending
+// operations.
+func (ops *hostOps) drain(timeout time.Duration) bool {
+	ops.lock.Lock()
+	if 0 == ops.pending {
+		ops.lock.Unlock()
+		return true
+	}
+	if 0 == timeout {
+		ops.lock.Unlock()
+		return false
+	}
+	if nil == ops.drained {
+		ops.drained = make(chan struct{})
+	}
+	drained := ops.drained
+	ops.lock.Unlock()
+	if 0 > timeout {
+		<-drained
+		return true
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-drained:
+		return true
+	case <-t.C:
+		ops.lock.Lock()
+		if drained == ops.drained {
+			ops.drained = nil
+		}
+		ops.lock.Unlock()
+		return false
+	}
+}
+
+func (ops *hostOps) status() (pending int, draining bool) {
+	ops.lock.Lock()
+	defer ops.lock.Unlock()
+	return ops.pending, nil != ops.drained
+}
+
+// SetDrainTimeout sets the maximum time that the host waits for pending operations to
+// complete when the file system is unmounted. Destroy is called once the operations
+// have completed or the timeout has elapsed. A zero timeout disables waiting and a
+// negative timeout waits indefinitely. The default timeout is 30 seconds.
+func (host *FileSystemHost) SetDrainTimeout(timeout time.Duration) {
+	host.drainTimeout = timeout
+}
+
+// Status reports the file system operations that the host is processing. It may be
+// called at any time.
+func (host *FileSystemHost) Status() HostStatus {
+	pending, draining := host.ops.status()
+	status := HostStatus{Pending: pending, Draining: draining}
+	if nil != host.admit {
+		status.Queued = int(atomic.LoadInt32(&host.admit.queued))
+	}
+	return status
+}
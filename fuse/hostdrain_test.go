@@ -0,0 +1,83 @@
+/*
+ * hostdrain_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostOpsDrain(t *testing.T) {
+	ops := hostOps{}
+	if !ops.drain(0) {
+		t.Error()
+	}
+
+	ops.enter()
+	ops.enter()
+	if ops.drain(0) || ops.drain(10*time.Millisecond) {
+		t.Error()
+	}
+	if pending, draining := ops.status(); 2 != pending || draining {
+		t.Error(pending, draining)
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- ops.drain(-1)
+	}()
+	for {
+		if _, draining := ops.status(); draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ops.leave()
+	select {
+	case <-done:
+		t.Error()
+	case <-time.After(10 * time.Millisecond):
+	}
+	ops.leave()
+	if !<-done {
+		t.Error()
+	}
+	if pending, draining := ops.status(); 0 != pending || draining {
+		t.Error(pending, draining)
+	}
+}
+
+func TestHostStatus(t *testing.T) {
+	host := NewFileSystemHost(&FileSystemBase{})
+	host.SetAdmission(Admission{MaxInflight: 1})
+	if 0 != host.enter(hostOpMeta) {
+		t.Error()
+	}
+	go func() {
+		host.enter(hostOpData)
+	}()
+	for 0 == host.Status().Queued {
+		time.Sleep(time.Millisecond)
+	}
+	if s := host.Status(); 2 != s.Pending || 1 != s.Queued || s.Draining {
+		t.Error(s)
+	}
+	host.leave(hostOpMeta)
+	for 0 != host.Status().Queued {
+		time.Sleep(time.Millisecond)
+	}
+	host.leave(hostOpData)
+	if s := host.Status(); 0 != s.Pending || 0 != s.Queued {
+		t.Error(s)
+	}
+}
@@ -0,0 +1,434 @@
+/*
+ * hostnode.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"strings"
+	"sync"
+)
+
+// hostNode is an entry in the host node table.
+type hostNode struct {
+	ino     uint64
+	nlookup uint64
+	busy    int
+	looking bool
+	dropped []hostForget
+	prnt    *hostNode
+	name    string
+	chld    map[string]*hostNode
+	prev    *hostNode
+	next    *hostNode
+}
+
+// hostForget records lookup references dropped from the host node table.
+type hostForget struct {
+	path    string
+	ino     uint64
+	nlookup uint64
+}
+
+// hostNodeTable maps paths to the node IDs reported by a FileSystemNode, or tracks the
+// paths that have been reported to a FileSystemLookup. Entries are kept as a tree so
+// that renames only move a single entry. Entries that have not been looked up
+// (nlookup == 0) may exist as ancestors of entries that have.
+//
+// Entries other than the root are also kept on a list in least recently used order.
+// An entry is always more recently used than its descendants, so that the least
+// recently used entry has no children and can be evicted when the table is full.
+type hostNodeTable struct {
+	lock  sync.Mutex
+	root  *hostNode
+	lru   hostNode
+	count int
+	limit int
+}
+
+// hostNodeLimit is the default maximum number of entries in the host node table.
+const hostNodeLimit = 65536
+
+func newHostNodeTable() *hostNodeTable {
+	tbl := &hostNodeTable{root: &hostNode{ino: FUSE_ROOT_ID}, limit: hostNodeLimit}
+	tbl.lru.prev, tbl.lru.next = &tbl.lru, &tbl.lru
+	return tbl
+}
+
+func hostNodeSplit(path string) []string {
+	return strings.FieldsFunc(path, func(c rune) bool { return '/' == c })
+}
+
+func (node *hostNode) attach(name string, chld *hostNode) {
+	if nil == node.chld {
+		node.chld = map[string]*hostNode{}
+	}
+	chld.prnt = node
+	chld.name = name
+	node.chld[name] = chld
+}
+
+// path returns the path of node.
+func (node *hostNode) path() string {
+	comp := []string{}
+	for ; nil != node.prnt; node = node.prnt {
+		comp = append(comp, node.name)
+	}
+	for i, j := 0, len(comp)-1; i < j; i, j = i+1, j-1 {
+		comp[i], comp[j] = comp[j], comp[i]
+	}
+	return "/" + strings.Join(comp, "/")
+}
+
+// unlink removes node from the least recently used list.
+func (tbl *hostNodeTable) unlink(node *hostNode) {
+	if nil != node.next {
+		node.prev.next = node.next
+		node.next.prev = node.prev
+		node.prev, node.next = nil, nil
+		tbl.count--
+	}
+}
+
+// touch marks node and its ancestors as most recently used.
+func (tbl *hostNodeTable) touch(node *hostNode) {
+	for ; nil != node.prnt; node = node.prnt {
+		tbl.unlink(node)
+		node.prev, node.next = &tbl.lru, tbl.lru.next
+		node.prev.next = node
+		node.next.prev = node
+		tbl.count++
+	}
+}
+
+// attached returns true if node is reachable from the table root.
+func (tbl *hostNodeTable) attached(node *hostNode) bool {
+	for ; tbl.root != node; node = node.prnt {
+		if nil == node.prnt {
+			return false
+		}
+	}
+	return true
+}
+
+func (tbl *hostNodeTable) detach(node *hostNode) {
+	prnt := node.prnt
+	delete(prnt.chld, node.name)
+	node.prnt = nil
+	// prune ancestors that only exist to hold this entry
+	for nil != prnt.prnt && 0 == prnt.nlookup && 0 == len(prnt.chld) {
+		next := prnt.prnt
+		delete(next.chld, prnt.name)
+		prnt.prnt = nil
+		tbl.unlink(prnt)
+		prnt = next
+	}
+}
+
+// forgets removes node and its descendants from the least recently used list and
+// appends the lookup references that they hold to forgets. The reference of an entry
+// whose Lookup has not returned yet is reported when it returns instead (see looked).
+func (tbl *hostNodeTable) forgets(node *hostNode, path string, forgets []hostForget) []hostForget {
+	for name, chld := range node.chld {
+		forgets = tbl.forgets(chld, strings.TrimSuffix(path, "/")+"/"+name, forgets)
+	}
+	tbl.unlink(node)
+	if 0 != node.nlookup {
+		if node.looking {
+			node.dropped = append(node.dropped, hostForget{path, node.ino, node.nlookup})
+		} else {
+			forgets = append(forgets, hostForget{path, node.ino, node.nlookup})
+		}
+		node.nlookup = 0
+	}
+	return forgets
+}
+
+// evict removes least recently used entries until the table is within its limit.
+// Entries that are in use by an operation are not evicted.
+func (tbl *hostNodeTable) evict(forgets []hostForget) []hostForget {
+	node := tbl.lru.prev
+	for 0 < tbl.limit && tbl.count > tbl.limit && &tbl.lru != node {
+		prev := node.prev
+		if 0 == len(node.chld) && 0 == node.busy {
+			path := node.path()
+			tbl.detach(node)
+			forgets = tbl.forgets(node, path, forgets)
+			if nil != prev.next {
+				// prev was not pruned by detach
+				node = prev
+			} else {
+				node = tbl.lru.prev
+			}
+		} else {
+			node = prev
+		}
+	}
+	return forgets
+}
+
+// find returns the entry for path or nil if there is no such entry.
+func (tbl *hostNodeTable) find(path string) *hostNode {
+	node := tbl.root
+	for _, name := range hostNodeSplit(path) {
+		node = node.chld[name]
+		if nil == node {
+			return nil
+		}
+	}
+	return node
+}
+
+// resolve returns the entry for path. It calls lookup for every path component that is
+// not in the table; lookup is called without holding the table lock. If the last path
+// component was looked up, resolve returns its attributes in stat and sets fresh to
+// true. The returned entry is in use and must be released using release. Lookup
+// references that resolve drops must be reported using forgets, even on error.
+func (tbl *hostNodeTable) resolve(path string,
+	lookup func(parent uint64, name string, stat *Stat_t) (int, uint64),
+	stat *Stat_t) (errc int, node *hostNode, fresh bool, forgets []hostForget) {
+	tbl.lock.Lock()
+	defer tbl.lock.Unlock()
+	node = tbl.root
+	for _, name := range hostNodeSplit(path) {
+		chld := node.chld[name]
+		fresh = nil == chld
+		if fresh {
+			prnt := node
+			prnt.busy++
+			tbl.lock.Unlock()
+			*stat = Stat_t{}
+			errc, ino := lookup(prnt.ino, name, stat)
+			tbl.lock.Lock()
+			prnt.busy--
+			if 0 != errc {
+				return errc, nil, false, forgets
+			}
+			if !tbl.attached(prnt) {
+				// parent was removed while looking up
+				forgets = append(forgets, hostForget{"", ino, 1})
+				return -ENOENT, nil, false, forgets
+			}
+			chld = prnt.chld[name]
+			if nil == chld {
+				chld = &hostNode{ino: ino}
+				prnt.attach(name, chld)
+			}
+			if ino == chld.ino {
+				chld.nlookup++
+			} else {
+				// another lookup has entered a different node meanwhile
+				forgets = append(forgets, hostForget{"", ino, 1})
+				fresh = false
+			}
+		}
+		node = chld
+	}
+	node.busy++
+	tbl.touch(node)
+	forgets = tbl.evict(forgets)
+	return 0, node, fresh, forgets
+}
+
+// release releases an entry returned by resolve.
+func (tbl *hostNodeTable) release(node *hostNode) {
+	tbl.lock.Lock()
+	node.busy--
+	tbl.lock.Unlock()
+}
+
+// insert adds an entry for path or marks an existing entry as most recently used. If
+// path had not been looked up, insert returns its entry, which cannot be evicted or
+// forgotten until it is passed to looked; otherwise it returns nil. It also returns
+// the entries evicted to make room for it.
+func (tbl *hostNodeTable) insert(path string) (*hostNode, []hostForget) {
+	tbl.lock.Lock()
+	defer tbl.lock.Unlock()
+	node := tbl.root
+	for _, name := range hostNodeSplit(path) {
+		chld := node.chld[name]
+		if nil == chld {
+			chld = &hostNode{}
+			node.attach(name, chld)
+		}
+		node = chld
+	}
+	if tbl.root == node {
+		return nil, nil
+	}
+	tbl.touch(node)
+	if 0 != node.nlookup || node.looking {
+		return nil, nil
+	}
+	node.nlookup = 1
+	node.looking = true
+	node.busy++
+	return node, tbl.evict(nil)
+}
+
+// looked marks the Lookup of an entry returned by insert as complete. It returns the
+// lookup references of the entry that were dropped while the Lookup was in progress.
+func (tbl *hostNodeTable) looked(node *hostNode) []hostForget {
+	tbl.lock.Lock()
+	defer tbl.lock.Unlock()
+	forgets := node.dropped
+	node.looking = false
+	node.dropped = nil
+	node.busy--
+	return forgets
+}
+
+// remove removes the entry for path and its descendants from the table.
+func (tbl *hostNodeTable) remove(path string) []hostForget {
+	tbl.lock.Lock()
+	defer tbl.lock.Unlock()
+	node := tbl.find(path)
+	if nil == node || tbl.root == node {
+		return nil
+	}
+	tbl.detach(node)
+	return tbl.forgets(node, path, nil)
+}
+
+// rename moves the entry for oldpath and its descendants to newpath, replacing any
+// entry for newpath. If move is false the entry for oldpath is removed instead.
+func (tbl *hostNodeTable) rename(oldpath string, newpath string, move bool) []hostForget {
+	tbl.lock.Lock()
+	defer tbl.lock.Unlock()
+	var forgets []hostForget
+	node := tbl.find(newpath)
+	if nil != node && tbl.root != node {
+		tbl.detach(node)
+		forgets = tbl.forgets(node, newpath, forgets)
+	}
+	node = tbl.find(oldpath)
+	if nil == node || tbl.root == node {
+		return forgets
+	}
+	tbl.detach(node)
+	comp := hostNodeSplit(newpath)
+	if !move || 0 == len(comp) {
+		return tbl.forgets(node, oldpath, forgets)
+	}
+	prnt := tbl.find("/" + strings.Join(comp[:len(comp)-1], "/"))
+	if nil == prnt {
+		return tbl.forgets(node, oldpath, forgets)
+	}
+	prnt.attach(comp[len(comp)-1], node)
+	tbl.touch(node)
+	return forgets
+}
+
+// clear removes all entries from the table.
+func (tbl *hostNodeTable) clear() []hostForget {
+	tbl.lock.Lock()
+	defer tbl.lock.Unlock()
+	forgets := []hostForget{}
+	for name, chld := range tbl.root.chld {
+		forgets = tbl.forgets(chld, "/"+name, forgets)
+	}
+	tbl.root = &hostNode{ino: tbl.root.ino}
+	return forgets
+}
+
+// nodeForget reports lookup references dropped from the host node table to the file system.
+func (host *FileSystemHost) nodeForget(forgets []hostForget) {
+	switch intf := host.fsop.(type) {
+	case FileSystemNode:
+		for _, f := range forgets {
+			intf.Forget(f.ino, f.nlookup)
+		}
+	case FileSystemLookup:
+		for _, f := range forgets {
+			intf.Forget(f.path, f.nlookup)
+		}
+	}
+}
+
+func (host *FileSystemHost) nodeGetattr(intf FileSystemNode,
+	path string, stat *Stat_t, fh uint64) int {
+	errc, node, fresh, forgets := host.nodes.resolve(path, intf.Lookup, stat)
+	host.nodeForget(forgets)
+	if 0 != errc {
+		return errc
+	}
+	defer host.nodes.release(node)
+	if fresh {
+		return 0
+	}
+	errc = intf.GetattrNode(node.ino, stat, fh)
+	if -ENOENT == errc {
+		// file was removed behind our back
+		host.nodeForget(host.nodes.remove(path))
+	}
+	return errc
+}
+
+func (host *FileSystemHost) nodeReaddir(intf FileSystemNode,
+	path string, fill func(name string, stat *Stat_t, ofst int64) bool, ofst int64,
+	fh uint64) int {
+	errc, node, _, forgets := host.nodes.resolve(path, intf.Lookup, &Stat_t{})
+	host.nodeForget(forgets)
+	if 0 != errc {
+		return errc
+	}
+	defer host.nodes.release(node)
+	return intf.ReaddirNode(node.ino, fill, ofst, fh)
+}
+
+// nodeGetattrDone updates the host node table for a path based file system after Getattr.
+func (host *FileSystemHost) nodeGetattrDone(path string, errc int) {
+	intf, ok := host.fsop.(FileSystemLookup)
+	if !ok {
+		return
+	}
+	if 0 == errc {
+		node, forgets := host.nodes.insert(path)
+		host.nodeForget(forgets)
+		if nil != node {
+			// the entry is pinned so that Forget cannot precede Lookup
+			intf.Lookup(path)
+			host.nodeForget(host.nodes.looked(node))
+		}
+	} else if -ENOENT == errc {
+		host.nodeForget(host.nodes.remove(path))
+	}
+}
+
+func (host *FileSystemHost) nodeRemove(path string) {
+	if nil != host.nodes {
+		host.nodeForget(host.nodes.remove(path))
+	}
+}
+
+func (host *FileSystemHost) nodeRename(oldpath string, newpath string) {
+	if nil != host.nodes {
+		_, move := host.fsop.(FileSystemNode)
+		host.nodeForget(host.nodes.rename(oldpath, newpath, move))
+	}
+}
+
+func (host *FileSystemHost) nodeClear() {
+	if nil != host.nodes {
+		host.nodeForget(host.nodes.clear())
+	}
+}
+
+// SetNodeLimit sets the maximum number of entries that the host keeps for a file system
+// that implements FileSystemNode or FileSystemLookup. When the limit is exceeded the
+// host forgets the least recently used entries that are not in use; they are looked up
+// again when next referenced. A zero limit disables eviction. The default limit is
+// 65536 entries. SetNodeLimit must be called prior to Mount.
+func (host *FileSystemHost) SetNodeLimit(limit int) {
+	if nil != host.nodes {
+		host.nodes.limit = limit
+	}
+}
@@ -0,0 +1,266 @@
+/*
+ * hostnode_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"sort"
+	"testing"
+)
+
+type testNodeLookup struct {
+	ents  map[uint64]map[string]uint64
+	calls int
+}
+
+func (self *testNodeLookup) Lookup(parent uint64, name string, stat *Stat_t) (int, uint64) {
+	self.calls++
+	ino, ok := self.ents[parent][name]
+	if !ok {
+		return -ENOENT, 0
+	}
+	stat.Ino = ino
+	return 0, ino
+}
+
+func testNodeForgets(forgets []hostForget) []uint64 {
+	inos := []uint64{}
+	for _, f := range forgets {
+		if 1 != f.nlookup {
+			return nil
+		}
+		inos = append(inos, f.ino)
+	}
+	sort.Slice(inos, func(i, j int) bool { return inos[i] < inos[j] })
+	return inos
+}
+
+func TestHostNodeTable(t *testing.T) {
+	fs := &testNodeLookup{ents: map[uint64]map[string]uint64{
+		FUSE_ROOT_ID: {"a": 2, "b": 3},
+		2:            {"c": 4, "d": 5},
+	}}
+	tbl := newHostNodeTable()
+
+	stat := Stat_t{}
+	errc, node, fresh, _ := tbl.resolve("/a/c", fs.Lookup, &stat)
+	if 0 != errc || 4 != node.ino || !fresh || 4 != stat.Ino || 2 != fs.calls {
+		t.Error(errc, node.ino, fresh, stat.Ino, fs.calls)
+	}
+	errc, node, fresh, _ = tbl.resolve("/a/c", fs.Lookup, &stat)
+	if 0 != errc || 4 != node.ino || fresh || 2 != fs.calls {
+		t.Error(errc, node.ino, fresh, fs.calls)
+	}
+	errc, node, fresh, _ = tbl.resolve("/", fs.Lookup, &stat)
+	if 0 != errc || FUSE_ROOT_ID != node.ino || fresh || 2 != fs.calls {
+		t.Error(errc, node.ino, fresh, fs.calls)
+	}
+	errc, _, _, _ = tbl.resolve("/a/x", fs.Lookup, &stat)
+	if -ENOENT != errc || 3 != fs.calls {
+		t.Error(errc, fs.calls)
+	}
+
+	// rename moves the subtree without further lookups and forgets the target
+	tbl.resolve("/b", fs.Lookup, &stat)
+	forgets := tbl.rename("/a", "/b", true)
+	if inos := testNodeForgets(forgets); 1 != len(inos) || 3 != inos[0] {
+		t.Error(forgets)
+	}
+	calls := fs.calls
+	errc, node, _, _ = tbl.resolve("/b/c", fs.Lookup, &stat)
+	if 0 != errc || 4 != node.ino || calls != fs.calls {
+		t.Error(errc, node.ino, fs.calls)
+	}
+	if nil != tbl.find("/a") {
+		t.Error()
+	}
+
+	// rename into a directory that is not in the table forgets the subtree
+	forgets = tbl.rename("/b", "/x/y", true)
+	if inos := testNodeForgets(forgets); 2 != len(inos) || 2 != inos[0] || 4 != inos[1] {
+		t.Error(forgets)
+	}
+
+	tbl.resolve("/a/c", fs.Lookup, &stat)
+	tbl.resolve("/a/d", fs.Lookup, &stat)
+	forgets = tbl.remove("/a")
+	if inos := testNodeForgets(forgets); 3 != len(inos) || 2 != inos[0] || 5 != inos[2] {
+		t.Error(forgets)
+	}
+	if nil != tbl.remove("/") || nil != tbl.remove("/a") {
+		t.Error()
+	}
+
+	tbl.resolve("/a/c", fs.Lookup, &stat)
+	tbl.resolve("/b", fs.Lookup, &stat)
+	forgets = tbl.clear()
+	if inos := testNodeForgets(forgets); 3 != len(inos) {
+		t.Error(forgets)
+	}
+	if nil != tbl.find("/a") || nil == tbl.find("/") {
+		t.Error()
+	}
+}
+
+func TestHostNodeTableEvict(t *testing.T) {
+	fs := &testNodeLookup{ents: map[uint64]map[string]uint64{
+		FUSE_ROOT_ID: {"a": 2, "b": 3, "c": 4},
+		2:            {"d": 5},
+	}}
+	tbl := newHostNodeTable()
+	tbl.limit = 2
+	stat := Stat_t{}
+
+	_, node, _, forgets := tbl.resolve("/a/d", fs.Lookup, &stat)
+	tbl.release(node)
+	_, node, _, forgets = tbl.resolve("/b", fs.Lookup, &stat)
+	if inos := testNodeForgets(forgets); 1 != len(inos) || 5 != inos[0] {
+		t.Error(forgets)
+	}
+	tbl.release(node)
+
+	// entries in use are not evicted
+	_, node, _, forgets = tbl.resolve("/b", fs.Lookup, &stat)
+	_, node2, _, forgets := tbl.resolve("/c", fs.Lookup, &stat)
+	if inos := testNodeForgets(forgets); 1 != len(inos) || 2 != inos[0] {
+		t.Error(forgets)
+	}
+	tbl.release(node2)
+	_, node2, _, forgets = tbl.resolve("/a", fs.Lookup, &stat)
+	if inos := testNodeForgets(forgets); 1 != len(inos) || 4 != inos[0] {
+		t.Error(forgets)
+	}
+	tbl.release(node2)
+	tbl.release(node)
+	if 2 != tbl.count || nil == tbl.find("/a") || nil == tbl.find("/b") {
+		t.Error(tbl.count)
+	}
+
+	// lookup runs without the table lock; the parent may go away meanwhile
+	tbl = newHostNodeTable()
+	tbl.resolve("/a", fs.Lookup, &stat)
+	errc, _, _, forgets := tbl.resolve("/a/d", func(parent uint64, name string, stat *Stat_t) (int, uint64) {
+		tbl.remove("/a")
+		return fs.Lookup(parent, name, stat)
+	}, &stat)
+	if inos := testNodeForgets(forgets); -ENOENT != errc || 1 != len(inos) || 5 != inos[0] {
+		t.Error(errc, forgets)
+	}
+	if nil != tbl.find("/a") || 0 != tbl.count {
+		t.Error(tbl.count)
+	}
+}
+
+func testNodeInsert(tbl *hostNodeTable, path string) (bool, []hostForget) {
+	node, forgets := tbl.insert(path)
+	if nil != node {
+		forgets = append(forgets, tbl.looked(node)...)
+	}
+	return nil != node, forgets
+}
+
+func TestHostNodeTablePaths(t *testing.T) {
+	tbl := newHostNodeTable()
+
+	if ok, _ := testNodeInsert(tbl, "/"); ok {
+		t.Error()
+	}
+	if ok, _ := testNodeInsert(tbl, "/a/b/c"); !ok {
+		t.Error()
+	}
+	if ok, _ := testNodeInsert(tbl, "/a/b/c"); ok {
+		t.Error()
+	}
+	if ok, _ := testNodeInsert(tbl, "/a/b"); !ok {
+		t.Error()
+	}
+	if ok, _ := testNodeInsert(tbl, "/x"); !ok {
+		t.Error()
+	}
+
+	// implicit ancestor /a is neither forgotten nor kept once empty
+	forgets := tbl.remove("/a/b")
+	if 2 != len(forgets) || "/a/b/c" != forgets[0].path || "/a/b" != forgets[1].path {
+		t.Error(forgets)
+	}
+	if nil != tbl.find("/a") {
+		t.Error()
+	}
+
+	// path based renames forget the old path and any replaced path
+	testNodeInsert(tbl, "/d/e")
+	forgets = tbl.rename("/d/e", "/x", false)
+	if 2 != len(forgets) || "/x" != forgets[0].path || "/d/e" != forgets[1].path {
+		t.Error(forgets)
+	}
+	if nil != tbl.find("/d") || nil != tbl.find("/x") {
+		t.Error()
+	}
+	if ok, _ := testNodeInsert(tbl, "/x"); !ok {
+		t.Error()
+	}
+
+	forgets = tbl.clear()
+	if 1 != len(forgets) || "/x" != forgets[0].path || 1 != forgets[0].nlookup {
+		t.Error(forgets)
+	}
+}
+
+func TestHostNodeTablePathsEvict(t *testing.T) {
+	tbl := newHostNodeTable()
+	tbl.limit = 3
+
+	testNodeInsert(tbl, "/a/b")
+	testNodeInsert(tbl, "/c")
+	// a repeated Getattr keeps /a/b in use
+	testNodeInsert(tbl, "/a/b")
+	_, forgets := testNodeInsert(tbl, "/d")
+	if 1 != len(forgets) || "/c" != forgets[0].path || 1 != forgets[0].nlookup {
+		t.Error(forgets)
+	}
+
+	// the implicit ancestor /a is evicted along with /a/b but is not forgotten
+	_, forgets = testNodeInsert(tbl, "/e/f")
+	if 1 != len(forgets) || "/a/b" != forgets[0].path {
+		t.Error(forgets)
+	}
+	if nil != tbl.find("/a") || 3 != tbl.count {
+		t.Error(tbl.count)
+	}
+}
+
+func TestHostNodeTablePathsLooking(t *testing.T) {
+	tbl := newHostNodeTable()
+	tbl.limit = 1
+
+	// an entry whose Lookup is in progress is neither evicted nor forgotten
+	node, _ := tbl.insert("/a")
+	if nil == node {
+		t.Fatal()
+	}
+	if _, forgets := testNodeInsert(tbl, "/b"); 0 != len(forgets) || nil == tbl.find("/a") {
+		t.Error(forgets)
+	}
+	if forgets := tbl.remove("/a"); 0 != len(forgets) {
+		t.Error(forgets)
+	}
+	if ok, _ := testNodeInsert(tbl, "/a"); !ok {
+		t.Error()
+	}
+	forgets := tbl.looked(node)
+	if 1 != len(forgets) || "/a" != forgets[0].path || 1 != forgets[0].nlookup {
+		t.Error(forgets)
+	}
+	if forgets := tbl.looked(node); 0 != len(forgets) {
+		t.Error(forgets)
+	}
+}
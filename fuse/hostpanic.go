@@ -0,0 +1,75 @@
+/*
+ * hostpanic.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"runtime/debug"
+)
+
+// PanicPolicy specifies how a FileSystemHost handles panics in file system operations.
+//
+// A panic with an Error value is the documented way of reporting an error code and is
+// always converted to that error code. Any other panic is considered a bug: it is
+// reported to Handler (if set) and then either converted to Errno or, if Crash is set,
+// resumed so that the process terminates.
+//
+// The zero value of PanicPolicy converts panics to -EIO without reporting them.
+type PanicPolicy struct {
+	// Handler receives the name of the file system operation (e.g. "Getattr"), the
+	// value passed to panic and a stack trace of the panicking goroutine.
+	Handler func(op string, r interface{}, stack []byte)
+
+	// Errno is the error code reported for a panic (e.g. -EIO). Zero means -EIO.
+	Errno int
+
+	// Crash resumes the panic after calling Handler, terminating the process.
+	Crash bool
+}
+
+// SetPanicPolicy sets the policy that the host uses for panics in file system operations,
+// including Init and Destroy. SetPanicPolicy must be called prior to Mount.
+func (host *FileSystemHost) SetPanicPolicy(policy PanicPolicy) {
+	host.panicPolicy = policy
+}
+
+// recoverAsErrno recovers a panic in the file system operation op and handles it
+// according to the host's panic policy. If errc0 is not nil it receives the error code.
+func (host *FileSystemHost) recoverAsErrno(op string, errc0 *c_int) {
+	r := recover()
+	if nil == r {
+		return
+	}
+	if e, ok := r.(Error); ok {
+		if nil != errc0 {
+			*errc0 = c_int(e)
+		}
+		return
+	}
+	policy := PanicPolicy{}
+	if nil != host {
+		policy = host.panicPolicy
+	}
+	if nil != policy.Handler {
+		policy.Handler(op, r, debug.Stack())
+	}
+	if policy.Crash {
+		panic(r)
+	}
+	if nil != errc0 {
+		errc := policy.Errno
+		if 0 == errc {
+			errc = -EIO
+		}
+		*errc0 = c_int(errc)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * hostpanic_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"strings"
+	"testing"
+)
+
+func testPanicOp(host *FileSystemHost, r interface{}) (errc0 c_int) {
+	defer host.recoverAsErrno("Test", &errc0)
+	panic(r)
+}
+
+func TestPanicPolicyDefault(t *testing.T) {
+	host := NewFileSystemHost(&FileSystemBase{})
+	if errc := testPanicOp(host, "bug"); -c_int(EIO) != errc {
+		t.Error(errc)
+	}
+	if errc := testPanicOp(host, Error(-ENOENT)); -c_int(ENOENT) != errc {
+		t.Error(errc)
+	}
+	if errc := testPanicOp(nil, "bug"); -c_int(EIO) != errc {
+		t.Error(errc)
+	}
+}
+
+func TestPanicPolicyHandler(t *testing.T) {
+	host := NewFileSystemHost(&FileSystemBase{})
+	var ops []string
+	var vals []interface{}
+	var stack []byte
+	host.SetPanicPolicy(PanicPolicy{
+		Handler: func(op string, r interface{}, s []byte) {
+			ops = append(ops, op)
+			vals = append(vals, r)
+			stack = s
+		},
+		Errno: -EFAULT,
+	})
+	if errc := testPanicOp(host, "bug"); -c_int(EFAULT) != errc {
+		t.Error(errc)
+	}
+	if 1 != len(ops) || "Test" != ops[0] || "bug" != vals[0] {
+		t.Error(ops, vals)
+	}
+	if !strings.Contains(string(stack), "testPanicOp") {
+		t.Error(string(stack))
+	}
+
+	// Error panics report error codes and are not bugs
+	if errc := testPanicOp(host, Error(-ENOENT)); -c_int(ENOENT) != errc {
+		t.Error(errc)
+	}
+	if 1 != len(ops) {
+		t.Error(ops)
+	}
+}
+
+func TestPanicPolicyCrash(t *testing.T) {
+	host := NewFileSystemHost(&FileSystemBase{})
+	handled := false
+	host.SetPanicPolicy(PanicPolicy{
+		Handler: func(op string, r interface{}, s []byte) { handled = true },
+		Crash:   true,
+	})
+	var r interface{}
+	func() {
+		defer func() { r = recover() }()
+		testPanicOp(host, "bug")
+	}()
+	if !handled || "bug" != r {
+		t.Error(handled, r)
+	}
+
+	// Error panics do not crash
+	if errc := testPanicOp(host, Error(-ENOENT)); -c_int(ENOENT) != errc {
+		t.Error(errc)
+	}
+}
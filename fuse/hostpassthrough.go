@@ -0,0 +1,74 @@
+/*
+ * hostpassthrough.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"sync"
+)
+
+// hostPassthrough tracks the kernel backing IDs registered for open files. A backing ID
+// cannot be closed until the open reply that references it has been sent, so it is
+// closed when the file is released.
+type hostPassthrough struct {
+	lock sync.Mutex
+	ids  map[uint64]c_int
+}
+
+// passthroughInit enables kernel passthrough if the file system implements
+// FileSystemPassthrough and both libfuse and the kernel support it.
+func (host *FileSystemHost) passthroughInit(conn0 *c_struct_fuse_conn_info) {
+	host.pthru = nil
+	if _, ok := host.fsop.(FileSystemPassthrough); !ok {
+		return
+	}
+	if c_hostPassthroughInit(conn0) {
+		host.pthru = &hostPassthrough{ids: map[uint64]c_int{}}
+	}
+}
+
+func (host *FileSystemHost) passthroughOpen(path string, fi0 *c_struct_fuse_file_info, errc int) {
+	if nil == host.pthru || 0 != errc {
+		return
+	}
+	fd := host.fsop.(FileSystemPassthrough).Passthrough(path, uint64(fi0.fh))
+	if 0 > fd {
+		return
+	}
+	id := c_hostPassthroughOpen(fi0, c_int(fd))
+	if 0 == id {
+		// kernel declined; Read and Write will be used for this file
+		return
+	}
+	fh := uint64(fi0.fh)
+	host.pthru.lock.Lock()
+	prev, ok := host.pthru.ids[fh]
+	host.pthru.ids[fh] = id
+	host.pthru.lock.Unlock()
+	if ok {
+		// open files hold their own reference to the backing file
+		c_hostPassthroughClose(c_fuse_get_context().fuse, prev)
+	}
+}
+
+func (host *FileSystemHost) passthroughRelease(fh uint64) {
+	if nil == host.pthru {
+		return
+	}
+	host.pthru.lock.Lock()
+	id, ok := host.pthru.ids[fh]
+	delete(host.pthru.ids, fh)
+	host.pthru.lock.Unlock()
+	if ok {
+		c_hostPassthroughClose(c_fuse_get_context().fuse, id)
+	}
+}
@@ -0,0 +1,329 @@
+/*
+ * mountopt.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MountFlavor identifies the FUSE implementation that mount options are rendered for.
+type MountFlavor int
+
+// FUSE implementations understood by MountOptions.
+const (
+	// MountFlavorDefault is the FUSE implementation that the host uses.
+	MountFlavorDefault MountFlavor = iota
+
+	// MountFlavorLibfuse2 is libfuse 2.x and compatible implementations
+	// (macFUSE, FUSE-T, FreeBSD fusefs-libs, NetBSD librefuse, OpenBSD libfuse).
+	MountFlavorLibfuse2
+
+	// MountFlavorLibfuse3 is libfuse 3.x.
+	MountFlavorLibfuse3
+
+	// MountFlavorWinFsp is the WinFsp FUSE compatibility layer.
+	MountFlavorWinFsp
+)
+
+func (flavor MountFlavor) resolve() MountFlavor {
+	if MountFlavorDefault != flavor {
+		return flavor
+	}
+	if "windows" == runtime.GOOS {
+		return MountFlavorWinFsp
+	}
+	if hostFuse3 {
+		return MountFlavorLibfuse3
+	}
+	return MountFlavorLibfuse2
+}
+
+func (flavor MountFlavor) String() string {
+	switch flavor {
+	case MountFlavorDefault:
+		return "default"
+	case MountFlavorLibfuse2:
+		return "libfuse2"
+	case MountFlavorLibfuse3:
+		return "libfuse3"
+	case MountFlavorWinFsp:
+		return "winfsp"
+	default:
+		return "MountFlavor(" + strconv.Itoa(int(flavor)) + ")"
+	}
+}
+
+// MountOptions contains typed mount options. It is an alternative to passing raw FUSE
+// command line options to FileSystemHost.Mount.
+//
+// The zero value of MountOptions specifies no options. Options whose zero value is
+// meaningful (for example uid=0 or attr_timeout=0) are pointers and are only used
+// when non-nil.
+//
+// Not all options are honored by all FUSE implementations. Options that have no
+// equivalent in a particular implementation are omitted when the options are
+// rendered for it, unless omitting them would change the semantics of the mount in
+// an unsafe manner, in which case validation fails.
+type MountOptions struct {
+	// Allow access to other users. [IGNORED on Windows]
+	AllowOther bool
+
+	// Enable permission checking by the kernel. [IGNORED on Windows]
+	DefaultPermissions bool
+
+	// Mount read-only. [NOT SUPPORTED on Windows]
+	ReadOnly bool
+
+	// Set file owner. Overrides the owner reported by Getattr.
+	Uid *uint32
+
+	// Set file group. Overrides the group reported by Getattr.
+	Gid *uint32
+
+	// Set file permissions mask. Overrides the permissions reported by Getattr.
+	Umask *uint32
+
+	// File system name. On Windows this is used as the volume label.
+	FsName string
+
+	// File system subtype. On Windows this is used as the file system name.
+	Subtype string
+
+	// Maximum size of read requests. [IGNORED on Windows]
+	MaxRead uint32
+
+	// Cache timeout for file attributes.
+	AttrTimeout *time.Duration
+
+	// Cache timeout for names. [IGNORED on Windows]
+	EntryTimeout *time.Duration
+
+	// Cache timeout for deleted names. [IGNORED on Windows]
+	NegativeTimeout *time.Duration
+
+	// Use direct I/O for all files. [IGNORED on Windows]
+	DirectIo bool
+
+	// Maximum number of threads that process FUSE requests. [IGNORED on libfuse2]
+	MaxThreads uint32
+
+	// Maximum number of idle threads kept to process FUSE requests.
+	// [IGNORED on libfuse2 and Windows]
+	MaxIdleThreads uint32
+
+	// Enable FUSE debug output.
+	Debug bool
+}
+
+func mountOptEscape(s string) string {
+	if !strings.ContainsAny(s, ",\\") {
+		return s
+	}
+	return strings.NewReplacer("\\", "\\\\", ",", "\\,").Replace(s)
+}
+
+func mountOptSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// Validate checks the options for errors and conflicts for the specified FUSE
+// implementation. It returns nil if the options can be rendered. A nil *MountOptions
+// is treated as the zero value.
+func (opts *MountOptions) Validate(flavor MountFlavor) error {
+	if nil == opts {
+		opts = &MountOptions{}
+	}
+	flavor = flavor.resolve()
+	switch flavor {
+	case MountFlavorLibfuse2, MountFlavorLibfuse3, MountFlavorWinFsp:
+	default:
+		return errors.New("MountOptions: unknown flavor " + flavor.String())
+	}
+	if nil != opts.Umask && 0 != *opts.Umask&^0777 {
+		return errors.New("MountOptions: umask " + strconv.FormatUint(uint64(*opts.Umask), 8) +
+			" out of range")
+	}
+	for _, t := range []struct {
+		name string
+		tval *time.Duration
+	}{
+		{"attr_timeout", opts.AttrTimeout},
+		{"entry_timeout", opts.EntryTimeout},
+		{"negative_timeout", opts.NegativeTimeout},
+	} {
+		if nil != t.tval && 0 > *t.tval {
+			return errors.New("MountOptions: " + t.name + " must not be negative")
+		}
+	}
+	if MountFlavorWinFsp == flavor && opts.ReadOnly {
+		// silently dropping ro would result in a writable mount
+		return errors.New("MountOptions: ro is not supported by " + flavor.String())
+	}
+	return nil
+}
+
+// Args validates the options and renders them as command line arguments suitable for
+// the specified FUSE implementation. The returned arguments may be passed to
+// FileSystemHost.Mount. A nil *MountOptions is treated as the zero value.
+func (opts *MountOptions) Args(flavor MountFlavor) ([]string, error) {
+	if nil == opts {
+		opts = &MountOptions{}
+	}
+	flavor = flavor.resolve()
+	err := opts.Validate(flavor)
+	if nil != err {
+		return nil, err
+	}
+
+	var o []string
+	winfsp := MountFlavorWinFsp == flavor
+	if opts.Debug {
+		o = append(o, "debug")
+	}
+	if opts.AllowOther && !winfsp {
+		o = append(o, "allow_other")
+	}
+	if opts.DefaultPermissions && !winfsp {
+		o = append(o, "default_permissions")
+	}
+	if opts.ReadOnly {
+		o = append(o, "ro")
+	}
+	if nil != opts.Uid {
+		o = append(o, "uid="+strconv.FormatUint(uint64(*opts.Uid), 10))
+	}
+	if nil != opts.Gid {
+		o = append(o, "gid="+strconv.FormatUint(uint64(*opts.Gid), 10))
+	}
+	if nil != opts.Umask {
+		o = append(o, "umask=0"+strconv.FormatUint(uint64(*opts.Umask), 8))
+	}
+	if "" != opts.FsName {
+		if winfsp {
+			o = append(o, "volname="+mountOptEscape(opts.FsName))
+		} else {
+			o = append(o, "fsname="+mountOptEscape(opts.FsName))
+		}
+	}
+	if "" != opts.Subtype {
+		if winfsp {
+			o = append(o, "FileSystemName="+mountOptEscape(opts.Subtype))
+		} else {
+			o = append(o, "subtype="+mountOptEscape(opts.Subtype))
+		}
+	}
+	if 0 != opts.MaxRead && !winfsp {
+		o = append(o, "max_read="+strconv.FormatUint(uint64(opts.MaxRead), 10))
+	}
+	if nil != opts.AttrTimeout {
+		if winfsp {
+			o = append(o, "FileInfoTimeout="+
+				strconv.FormatInt(int64(*opts.AttrTimeout/time.Millisecond), 10))
+		} else {
+			o = append(o, "attr_timeout="+mountOptSeconds(*opts.AttrTimeout))
+		}
+	}
+	if nil != opts.EntryTimeout && !winfsp {
+		o = append(o, "entry_timeout="+mountOptSeconds(*opts.EntryTimeout))
+	}
+	if nil != opts.NegativeTimeout && !winfsp {
+		o = append(o, "negative_timeout="+mountOptSeconds(*opts.NegativeTimeout))
+	}
+	if opts.DirectIo && !winfsp {
+		o = append(o, "direct_io")
+	}
+	if 0 != opts.MaxThreads {
+		if winfsp {
+			o = append(o, "ThreadCount="+strconv.FormatUint(uint64(opts.MaxThreads), 10))
+		} else if MountFlavorLibfuse3 == flavor {
+			o = append(o, "max_threads="+strconv.FormatUint(uint64(opts.MaxThreads), 10))
+		}
+	}
+	if 0 != opts.MaxIdleThreads && MountFlavorLibfuse3 == flavor {
+		o = append(o, "max_idle_threads="+strconv.FormatUint(uint64(opts.MaxIdleThreads), 10))
+	}
+
+	if 0 == len(o) {
+		return []string{}, nil
+	}
+	return []string{"-o", strings.Join(o, ",")}, nil
+}
+
+// mountOptSplit returns the -o options in args as a map of option name to value.
+func mountOptSplit(args []string) map[string]string {
+	m := map[string]string{}
+	for i := 0; len(args) > i; i++ {
+		var o string
+		if "-o" == args[i] && len(args) > i+1 {
+			i++
+			o = args[i]
+		} else if strings.HasPrefix(args[i], "-o") {
+			o = args[i][2:]
+		} else {
+			continue
+		}
+		for len(o) > 0 {
+			j := 0
+			for ; len(o) > j && ',' != o[j]; j++ {
+				if '\\' == o[j] {
+					j++
+				}
+			}
+			if len(o) < j {
+				j = len(o)
+			}
+			n, v := o[:j], ""
+			if k := strings.IndexByte(n, '='); -1 != k {
+				n, v = n[:k], n[k+1:]
+			}
+			if "" != n {
+				m[n] = v
+			}
+			if len(o) == j {
+				break
+			}
+			o = o[j+1:]
+		}
+	}
+	return m
+}
+
+// MountWithOptions mounts a file system on the given mountpoint with the mount options
+// in mopts and any additional raw FUSE command line options in opts. It is similar to
+// Mount except that the options are validated prior to mounting and that errors are
+// reported as a Go error. It is an error for opts to specify an option that is also
+// specified in mopts with a different value. The mopts argument may be nil.
+func (host *FileSystemHost) MountWithOptions(mountpoint string, mopts *MountOptions,
+	opts ...string) error {
+	args, err := mopts.Args(MountFlavorDefault)
+	if nil != err {
+		return err
+	}
+	if 0 != len(opts) && 0 != len(args) {
+		m := mountOptSplit(opts)
+		for n, v := range mountOptSplit(args) {
+			if w, ok := m[n]; ok && v != w {
+				return errors.New("MountWithOptions: option " + n + "=" + v +
+					" conflicts with " + n + "=" + w)
+			}
+		}
+	}
+	if !host.Mount(mountpoint, append(args, opts...)) {
+		return errors.New("MountWithOptions: mount failed")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+ * mountopt_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMountOptionsArgs(t *testing.T) {
+	uid, gid, umask := uint32(1000), uint32(0), uint32(022)
+	attr, entr, negt := 1500*time.Millisecond, time.Duration(0), 2*time.Second
+	opts := MountOptions{
+		AllowOther:         true,
+		DefaultPermissions: true,
+		Uid:                &uid,
+		Gid:                &gid,
+		Umask:              &umask,
+		FsName:             "etcd,fs",
+		Subtype:            "etcdfs",
+		MaxRead:            131072,
+		AttrTimeout:        &attr,
+		EntryTimeout:       &entr,
+		NegativeTimeout:    &negt,
+		DirectIo:           true,
+		Debug:              true,
+	}
+
+	expect := []string{"-o", "debug,allow_other,default_permissions," +
+		"uid=1000,gid=0,umask=022,fsname=etcd\\,fs,subtype=etcdfs,max_read=131072," +
+		"attr_timeout=1.5,entry_timeout=0,negative_timeout=2,direct_io"}
+	for _, flavor := range []MountFlavor{MountFlavorLibfuse2, MountFlavorLibfuse3} {
+		args, err := opts.Args(flavor)
+		if nil != err {
+			t.Errorf("%v: unexpected error %v", flavor, err)
+		}
+		if !reflect.DeepEqual(expect, args) {
+			t.Errorf("%v: got %#v; expected %#v", flavor, args, expect)
+		}
+	}
+
+	expect = []string{"-o", "debug,uid=1000,gid=0,umask=022," +
+		"volname=etcd\\,fs,FileSystemName=etcdfs,FileInfoTimeout=1500"}
+	args, err := opts.Args(MountFlavorWinFsp)
+	if nil != err {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(expect, args) {
+		t.Errorf("got %#v; expected %#v", args, expect)
+	}
+
+	args, err = (&MountOptions{}).Args(MountFlavorDefault)
+	if nil != err || 0 != len(args) {
+		t.Errorf("got %#v, %v; expected no args", args, err)
+	}
+
+	opts = MountOptions{MaxThreads: 16, MaxIdleThreads: 4}
+	for flavor, expect := range map[MountFlavor][]string{
+		MountFlavorLibfuse2: {},
+		MountFlavorLibfuse3: {"-o", "max_threads=16,max_idle_threads=4"},
+		MountFlavorWinFsp:   {"-o", "ThreadCount=16"},
+	} {
+		args, err := opts.Args(flavor)
+		if nil != err || !reflect.DeepEqual(expect, args) {
+			t.Errorf("%v: got %#v, %v; expected %#v", flavor, args, err, expect)
+		}
+	}
+}
+
+func TestMountOptionsValidate(t *testing.T) {
+	badumask := uint32(01777)
+	badtime := -time.Second
+	for _, c := range []struct {
+		flavor MountFlavor
+		opts   MountOptions
+	}{
+		{MountFlavorLibfuse2, MountOptions{Umask: &badumask}},
+		{MountFlavorLibfuse3, MountOptions{AttrTimeout: &badtime}},
+		{MountFlavorLibfuse3, MountOptions{NegativeTimeout: &badtime}},
+		{MountFlavorWinFsp, MountOptions{ReadOnly: true}},
+		{MountFlavor(42), MountOptions{}},
+	} {
+		if nil == c.opts.Validate(c.flavor) {
+			t.Errorf("%v: %+v: expected validation error", c.flavor, c.opts)
+		}
+		if _, err := c.opts.Args(c.flavor); nil == err {
+			t.Errorf("%v: %+v: expected rendering error", c.flavor, c.opts)
+		}
+	}
+
+	if err := (&MountOptions{ReadOnly: true}).Validate(MountFlavorLibfuse2); nil != err {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	var nilopts *MountOptions
+	if err := nilopts.Validate(MountFlavorLibfuse3); nil != err {
+		t.Errorf("unexpected error %v", err)
+	}
+	if args, err := nilopts.Args(MountFlavorLibfuse3); nil != err || 0 != len(args) {
+		t.Errorf("got %#v, %v; expected no arguments", args, err)
+	}
+}
+
+func TestMountOptSplit(t *testing.T) {
+	m := mountOptSplit([]string{"-d", "-o", "ro,uid=1000", "-oumask=022,fsname=a\\,b", "mnt"})
+	expect := map[string]string{"ro": "", "uid": "1000", "umask": "022", "fsname": "a\\,b"}
+	if !reflect.DeepEqual(expect, m) {
+		t.Errorf("got %#v; expected %#v", m, expect)
+	}
+}
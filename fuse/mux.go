@@ -0,0 +1,616 @@
+/*
+ * mux.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type muxMount struct {
+	prefix string
+	fs     FileSystemInterface
+}
+
+type muxHandle struct {
+	mnt *muxMount // nil for synthesized directories
+	fh  uint64
+}
+
+// Mux is a file system that serves multiple file systems under different path prefixes
+// of a single mount. For example, a Mux with file systems mounted at "/etcd" and
+// "/scratch" passes operations on "/etcd/a/b" to the first file system as operations
+// on "/a/b".
+//
+// Mux synthesizes read-only directories for the root and for every ancestor of a
+// mount prefix. File handles are renumbered by the Mux so that handles from different
+// file systems do not collide. Renames and hard links across file systems fail with
+// -EXDEV; attempts to remove or rename a mount point fail with -EBUSY. Symbolic link
+// targets are passed through unchanged.
+type Mux struct {
+	lock    sync.RWMutex
+	mounts  map[string]*muxMount
+	synth   map[string][]string
+	init    bool
+	tmsp    Timespec
+	hlock   sync.Mutex
+	handles map[uint64]muxHandle
+	nexth   uint64
+}
+
+// NewMux creates a Mux with no file systems mounted.
+func NewMux() *Mux {
+	return &Mux{
+		mounts:  map[string]*muxMount{},
+		synth:   map[string][]string{"/": nil},
+		tmsp:    Now(),
+		handles: map[uint64]muxHandle{},
+	}
+}
+
+func muxClean(path string) string {
+	comps := []string{}
+	for _, c := range strings.Split(path, "/") {
+		if "" != c && "." != c {
+			comps = append(comps, c)
+		}
+	}
+	return "/" + strings.Join(comps, "/")
+}
+
+func muxParent(path string) (string, string) {
+	i := strings.LastIndexByte(path, '/')
+	if 0 == i {
+		return "/", path[1:]
+	}
+	return path[:i], path[i+1:]
+}
+
+// Mount mounts fs at the path prefix. A prefix may not be the root, nor may it be an
+// ancestor or descendant of another prefix. If the Mux has already been initialized,
+// Mount calls fs.Init.
+func (mux *Mux) Mount(prefix string, fs FileSystemInterface) error {
+	prefix = muxClean(prefix)
+	if "/" == prefix || strings.Contains(prefix, "/../") || strings.HasSuffix(prefix, "/..") {
+		return errors.New("Mux.Mount: invalid prefix " + prefix)
+	}
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	if _, ok := mux.synth[prefix]; ok {
+		return errors.New("Mux.Mount: prefix " + prefix + " overlaps another prefix")
+	}
+	for p := range mux.mounts {
+		if p == prefix || strings.HasPrefix(prefix, p+"/") {
+			return errors.New("Mux.Mount: prefix " + prefix + " overlaps another prefix")
+		}
+	}
+	mux.mounts[prefix] = &muxMount{prefix, fs}
+	for path := prefix; "/" != path; {
+		dir, name := muxParent(path)
+		chld, ok := mux.synth[dir]
+		mux.synth[dir] = append(chld, name)
+		if ok {
+			break
+		}
+		path = dir
+	}
+	if mux.init {
+		fs.Init()
+	}
+	return nil
+}
+
+// resolve returns the mounted file system and the path within it for path. It returns
+// a nil mount for synthesized directories.
+func (mux *Mux) resolve(path string) (errc int, mnt *muxMount, subpath string) {
+	mux.lock.RLock()
+	defer mux.lock.RUnlock()
+	if _, ok := mux.synth[path]; ok {
+		return 0, nil, path
+	}
+	for p := path; "/" != p; p, _ = muxParent(p) {
+		if mnt, ok := mux.mounts[p]; ok {
+			subpath = path[len(p):]
+			if "" == subpath {
+				subpath = "/"
+			}
+			return 0, mnt, subpath
+		}
+	}
+	return -ENOENT, nil, ""
+}
+
+// resolveEntry is like resolve but fails with errc for synthesized directories and mount
+// points; it is used by operations that create or remove directory entries.
+func (mux *Mux) resolveEntry(path string, errc int) (int, *muxMount, string) {
+	e, mnt, subpath := mux.resolve(path)
+	if 0 != e {
+		if p, _ := muxParent(path); -ENOENT == e {
+			if e, pmnt, _ := mux.resolve(p); 0 == e && nil == pmnt {
+				return errc, nil, ""
+			}
+		}
+		return e, nil, ""
+	}
+	if nil == mnt || "/" == subpath {
+		return errc, nil, ""
+	}
+	return 0, mnt, subpath
+}
+
+func (mux *Mux) newHandle(mnt *muxMount, fh uint64) uint64 {
+	mux.hlock.Lock()
+	defer mux.hlock.Unlock()
+	mux.nexth++
+	mux.handles[mux.nexth] = muxHandle{mnt, fh}
+	return mux.nexth
+}
+
+func (mux *Mux) getHandle(fh uint64) (muxHandle, bool) {
+	mux.hlock.Lock()
+	defer mux.hlock.Unlock()
+	h, ok := mux.handles[fh]
+	return h, ok
+}
+
+func (mux *Mux) delHandle(fh uint64) (muxHandle, bool) {
+	mux.hlock.Lock()
+	defer mux.hlock.Unlock()
+	h, ok := mux.handles[fh]
+	delete(mux.handles, fh)
+	return h, ok
+}
+
+func (mux *Mux) synthStat(stat *Stat_t) {
+	*stat = Stat_t{
+		Mode:     S_IFDIR | 0555,
+		Nlink:    2,
+		Atim:     mux.tmsp,
+		Mtim:     mux.tmsp,
+		Ctim:     mux.tmsp,
+		Birthtim: mux.tmsp,
+	}
+}
+
+func (mux *Mux) Init() {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	mux.init = true
+	for _, mnt := range mux.mounts {
+		mnt.fs.Init()
+	}
+}
+
+func (mux *Mux) Destroy() {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	mux.init = false
+	for _, mnt := range mux.mounts {
+		mnt.fs.Destroy()
+	}
+}
+
+func (mux *Mux) Statfs(path string, stat *Statfs_t) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		*stat = Statfs_t{Bsize: 4096, Frsize: 4096, Namemax: 255}
+		return 0
+	}
+	return mnt.fs.Statfs(subpath, stat)
+}
+
+func (mux *Mux) Mknod(path string, mode uint32, dev uint64) int {
+	errc, mnt, subpath := mux.resolveEntry(path, -EPERM)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Mknod(subpath, mode, dev)
+}
+
+func (mux *Mux) Mkdir(path string, mode uint32) int {
+	errc, mnt, subpath := mux.resolveEntry(path, -EPERM)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Mkdir(subpath, mode)
+}
+
+func (mux *Mux) Unlink(path string) int {
+	errc, mnt, subpath := mux.resolveEntry(path, -EBUSY)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Unlink(subpath)
+}
+
+func (mux *Mux) Rmdir(path string) int {
+	errc, mnt, subpath := mux.resolveEntry(path, -EBUSY)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Rmdir(subpath)
+}
+
+func (mux *Mux) Link(oldpath string, newpath string) int {
+	errc, omnt, osubpath := mux.resolveEntry(oldpath, -EPERM)
+	if 0 != errc {
+		return errc
+	}
+	errc, nmnt, nsubpath := mux.resolveEntry(newpath, -EXDEV)
+	if 0 != errc {
+		return errc
+	}
+	if omnt != nmnt {
+		return -EXDEV
+	}
+	return omnt.fs.Link(osubpath, nsubpath)
+}
+
+func (mux *Mux) Symlink(target string, newpath string) int {
+	errc, mnt, subpath := mux.resolveEntry(newpath, -EPERM)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Symlink(target, subpath)
+}
+
+func (mux *Mux) Readlink(path string) (int, string) {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc, ""
+	}
+	if nil == mnt {
+		return -EINVAL, ""
+	}
+	return mnt.fs.Readlink(subpath)
+}
+
+func (mux *Mux) Rename(oldpath string, newpath string) int {
+	errc, omnt, osubpath := mux.resolveEntry(oldpath, -EBUSY)
+	if 0 != errc {
+		return errc
+	}
+	errc, nmnt, nsubpath := mux.resolveEntry(newpath, -EXDEV)
+	if 0 != errc {
+		return errc
+	}
+	if omnt != nmnt {
+		return -EXDEV
+	}
+	return omnt.fs.Rename(osubpath, nsubpath)
+}
+
+func (mux *Mux) Chmod(path string, mode uint32) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return mnt.fs.Chmod(subpath, mode)
+}
+
+func (mux *Mux) Chown(path string, uid uint32, gid uint32) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return mnt.fs.Chown(subpath, uid, gid)
+}
+
+func (mux *Mux) Utimens(path string, tmsp []Timespec) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return mnt.fs.Utimens(subpath, tmsp)
+}
+
+func (mux *Mux) Access(path string, mask uint32) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		if 0 != mask&W_OK {
+			return -EACCES
+		}
+		return 0
+	}
+	return mnt.fs.Access(subpath, mask)
+}
+
+func (mux *Mux) Create(path string, flags int, mode uint32) (int, uint64) {
+	errc, mnt, subpath := mux.resolveEntry(path, -EPERM)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	errc, fh := mnt.fs.Create(subpath, flags, mode)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, mux.newHandle(mnt, fh)
+}
+
+func (mux *Mux) Open(path string, flags int) (int, uint64) {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if nil == mnt {
+		return -EISDIR, ^uint64(0)
+	}
+	errc, fh := mnt.fs.Open(subpath, flags)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, mux.newHandle(mnt, fh)
+}
+
+func (mux *Mux) Getattr(path string, stat *Stat_t, fh uint64) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		mux.synthStat(stat)
+		return 0
+	}
+	if h, ok := mux.getHandle(fh); ok && mnt == h.mnt {
+		return mnt.fs.Getattr(subpath, stat, h.fh)
+	}
+	return mnt.fs.Getattr(subpath, stat, ^uint64(0))
+}
+
+func (mux *Mux) Truncate(path string, size int64, fh uint64) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EISDIR
+	}
+	if h, ok := mux.getHandle(fh); ok && mnt == h.mnt {
+		return mnt.fs.Truncate(subpath, size, h.fh)
+	}
+	return mnt.fs.Truncate(subpath, size, ^uint64(0))
+}
+
+// handle returns the mount, path and file handle for an operation on an open file.
+func (mux *Mux) handle(path string, fh uint64) (int, *muxMount, string, uint64) {
+	h, ok := mux.getHandle(fh)
+	if !ok || nil == h.mnt {
+		return -EBADF, nil, "", 0
+	}
+	subpath := "/"
+	if errc, mnt, p := mux.resolve(path); 0 == errc && mnt == h.mnt {
+		subpath = p
+	}
+	return 0, h.mnt, subpath, h.fh
+}
+
+func (mux *Mux) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	errc, mnt, subpath, mfh := mux.handle(path, fh)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Read(subpath, buff, ofst, mfh)
+}
+
+func (mux *Mux) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	errc, mnt, subpath, mfh := mux.handle(path, fh)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Write(subpath, buff, ofst, mfh)
+}
+
+func (mux *Mux) Flush(path string, fh uint64) int {
+	errc, mnt, subpath, mfh := mux.handle(path, fh)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Flush(subpath, mfh)
+}
+
+func (mux *Mux) Release(path string, fh uint64) int {
+	errc, mnt, subpath, mfh := mux.handle(path, fh)
+	if 0 != errc {
+		return errc
+	}
+	mux.delHandle(fh)
+	return mnt.fs.Release(subpath, mfh)
+}
+
+func (mux *Mux) Fsync(path string, datasync bool, fh uint64) int {
+	errc, mnt, subpath, mfh := mux.handle(path, fh)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Fsync(subpath, datasync, mfh)
+}
+
+func (mux *Mux) Opendir(path string) (int, uint64) {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if nil == mnt {
+		return 0, mux.newHandle(nil, 0)
+	}
+	errc, fh := mnt.fs.Opendir(subpath)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, mux.newHandle(mnt, fh)
+}
+
+func (mux *Mux) Readdir(path string,
+	fill func(name string, stat *Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) int {
+	h, ok := mux.getHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	if nil != h.mnt {
+		errc, mnt, subpath := mux.resolve(path)
+		if 0 != errc {
+			return errc
+		}
+		if mnt != h.mnt {
+			return -EBADF
+		}
+		return mnt.fs.Readdir(subpath, fill, ofst, h.fh)
+	}
+	mux.lock.RLock()
+	names := append([]string(nil), mux.synth[path]...)
+	mux.lock.RUnlock()
+	sort.Strings(names)
+	stat := Stat_t{}
+	mux.synthStat(&stat)
+	fill(".", &stat, 0)
+	fill("..", nil, 0)
+	for _, name := range names {
+		if !fill(name, nil, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func (mux *Mux) Releasedir(path string, fh uint64) int {
+	h, ok := mux.delHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	if nil == h.mnt {
+		return 0
+	}
+	subpath := "/"
+	if errc, mnt, p := mux.resolve(path); 0 == errc && mnt == h.mnt {
+		subpath = p
+	}
+	return h.mnt.fs.Releasedir(subpath, h.fh)
+}
+
+func (mux *Mux) Fsyncdir(path string, datasync bool, fh uint64) int {
+	h, ok := mux.getHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	if nil == h.mnt {
+		return 0
+	}
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	return mnt.fs.Fsyncdir(subpath, datasync, h.fh)
+}
+
+func (mux *Mux) Setxattr(path string, name string, value []byte, flags int) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return mnt.fs.Setxattr(subpath, name, value, flags)
+}
+
+func (mux *Mux) Getxattr(path string, name string) (int, []byte) {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc, nil
+	}
+	if nil == mnt {
+		return -ENOATTR, nil
+	}
+	return mnt.fs.Getxattr(subpath, name)
+}
+
+func (mux *Mux) Removexattr(path string, name string) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return mnt.fs.Removexattr(subpath, name)
+}
+
+func (mux *Mux) Listxattr(path string, fill func(name string) bool) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return 0
+	}
+	return mnt.fs.Listxattr(subpath, fill)
+}
+
+func (mux *Mux) Chflags(path string, flags uint32) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return fsChflags(mnt.fs, subpath, flags)
+}
+
+func (mux *Mux) Setcrtime(path string, tmsp Timespec) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return fsSetcrtime(mnt.fs, subpath, tmsp)
+}
+
+func (mux *Mux) Setchgtime(path string, tmsp Timespec) int {
+	errc, mnt, subpath := mux.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if nil == mnt {
+		return -EPERM
+	}
+	return fsSetchgtime(mnt.fs, subpath, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*Mux)(nil)
+	_ FileSystemChflags    = (*Mux)(nil)
+	_ FileSystemSetcrtime  = (*Mux)(nil)
+	_ FileSystemSetchgtime = (*Mux)(nil)
+)
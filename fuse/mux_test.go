@@ -0,0 +1,120 @@
+/*
+ * mux_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMuxMount(t *testing.T) {
+	mux := NewMux()
+	if nil == mux.Mount("/", newTestFs()) {
+		t.Error()
+	}
+	if nil != mux.Mount("/data/etcd", newTestFs()) {
+		t.Error()
+	}
+	for _, prefix := range []string{"/data", "/data/etcd", "/data/etcd/x", "data/etcd/"} {
+		if nil == mux.Mount(prefix, newTestFs()) {
+			t.Error(prefix)
+		}
+	}
+	if nil != mux.Mount("data/scratch/", newTestFs()) {
+		t.Error()
+	}
+}
+
+func TestMux(t *testing.T) {
+	etcd, scratch := newTestFs(), newTestFs()
+	etcd.create("/key", S_IFREG|0644, "value")
+	scratch.create("/tmp", S_IFDIR|0755, "")
+	mux := NewMux()
+	mux.Mount("/data/etcd", etcd)
+	mux.Mount("/data/scratch", scratch)
+	mux.Mount("/config", newTestFs())
+
+	testOverlayNames(t, mux, "/", "config", "data")
+	testOverlayNames(t, mux, "/data", "etcd", "scratch")
+	testOverlayNames(t, mux, "/data/etcd", "key")
+	testOverlayData(t, mux, "/data/etcd/key", "value")
+
+	stat := Stat_t{}
+	if errc := mux.Getattr("/data", &stat, ^uint64(0)); 0 != errc || S_IFDIR|0555 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+	testOverlayErrc(t, mux, "/data/other", -ENOENT)
+	testOverlayErrc(t, mux, "/data/scratch/tmp", 0)
+
+	// handles from different file systems do not collide
+	errc1, fh1 := mux.Open("/data/etcd/key", O_RDONLY)
+	errc2, fh2 := mux.Create("/data/scratch/tmp/f", O_RDWR, 0644)
+	if 0 != errc1 || 0 != errc2 || fh1 == fh2 {
+		t.Error(errc1, errc2, fh1, fh2)
+	}
+	if n := mux.Write("/data/scratch/tmp/f", []byte("scratch"), 0, fh2); 7 != n {
+		t.Error(n)
+	}
+	buff := make([]byte, 16)
+	if n := mux.Read("/data/etcd/key", buff, 0, fh1); 5 != n || "value" != string(buff[:n]) {
+		t.Error(n)
+	}
+	if 0 != mux.Release("/data/etcd/key", fh1) || 0 != mux.Release("/data/scratch/tmp/f", fh2) {
+		t.Error()
+	}
+	if n := mux.Read("/data/etcd/key", buff, 0, fh1); -EBADF != n {
+		t.Error(n)
+	}
+	testOverlayData(t, scratch, "/tmp/f", "scratch")
+
+	errcs := map[string][2]int{
+		"rename across": {mux.Rename("/data/etcd/key", "/data/scratch/key"), -EXDEV},
+		"rename to top": {mux.Rename("/data/etcd/key", "/data/key"), -EXDEV},
+		"rename mount":  {mux.Rename("/data/etcd", "/data/etcd2"), -EBUSY},
+		"link across":   {mux.Link("/data/etcd/key", "/config/key"), -EXDEV},
+		"rmdir mount":   {mux.Rmdir("/data/etcd"), -EBUSY},
+		"rmdir synth":   {mux.Rmdir("/data"), -EBUSY},
+		"mkdir synth":   {mux.Mkdir("/data/new", 0755), -EPERM},
+		"mkdir exists":  {mux.Mkdir("/data/etcd", 0755), -EPERM},
+		"chmod synth":   {mux.Chmod("/data", 0777), -EPERM},
+		"rename within": {mux.Rename("/data/etcd/key", "/data/etcd/key2"), 0},
+	}
+	for name, e := range errcs {
+		if e[1] != e[0] {
+			t.Error(name, e[0])
+		}
+	}
+	testOverlayNames(t, etcd, "/", "key2")
+
+	var called []string
+	mux.Mount("/late", &testInitFs{testFs: newTestFs(), called: &called})
+	mux.Init()
+	mux.Mount("/later", &testInitFs{testFs: newTestFs(), called: &called})
+	mux.Destroy()
+	if !reflect.DeepEqual([]string{"Init", "Init", "Destroy", "Destroy"}, called) {
+		t.Error(called)
+	}
+}
+
+type testInitFs struct {
+	*testFs
+	called *[]string
+}
+
+func (fs *testInitFs) Init() {
+	*fs.called = append(*fs.called, "Init")
+}
+
+func (fs *testInitFs) Destroy() {
+	*fs.called = append(*fs.called, "Destroy")
+}
@@ -14,6 +14,7 @@ package fuse
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -190,3 +191,97 @@ func TestOptParse(t *testing.T) {
 		t.Error()
 	}
 }
+
+type testOptStruct struct {
+	Foreground bool   `fuse:"-f,--foreground" help:"run in the foreground"`
+	CacheSize  int    `fuse:"-o cache_size=%d" help:"cache size in bytes"`
+	Umask      uint32 `fuse:"umask=%o" help:"permission mask"`
+	Volname    string `fuse:"volname" help:"volume name"`
+	Timeout    int64  `fuse:"--timeout"`
+	Ignored    int
+}
+
+func TestOptStructFormat(t *testing.T) {
+	var cfg testOptStruct
+	format, vals, bindings, err := optStructFormat(&cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "-f --foreground cache_size=%d cache_size= umask=%o umask= volname volname= "+
+		"--timeout --timeout=" != format {
+		t.Error(format)
+	}
+	if 10 != len(vals) || 6 != len(bindings) {
+		t.Error(len(vals), len(bindings))
+	}
+
+	_, _, _, err = optStructFormat(cfg)
+	if nil == err {
+		t.Error()
+	}
+	_, _, _, err = optStructFormat(&struct {
+		F float64 `fuse:"f=%v"`
+	}{})
+	if nil == err {
+		t.Error()
+	}
+	_, _, _, err = optStructFormat(&struct {
+		f int `fuse:"f=%d"`
+	}{})
+	if nil == err {
+		t.Error()
+	}
+	_, _, _, err = optStructFormat(&struct {
+		F int `fuse:"-o f=%d g=%d"`
+	}{})
+	if nil == err {
+		t.Error()
+	}
+}
+
+func TestOptHelpStruct(t *testing.T) {
+	exphelp := strings.Join([]string{
+		"    -f --foreground   run in the foreground",
+		"    -o cache_size=N   cache size in bytes",
+		"    -o umask=OCT      permission mask",
+		"    -o volname=VALUE  volume name",
+		"    --timeout=VALUE",
+		"",
+	}, "\n")
+	if help := OptHelpStruct(&testOptStruct{}); exphelp != help {
+		t.Error(help)
+	}
+}
+
+func TestOptParseStruct(t *testing.T) {
+	args := []string{
+		"-f",
+		"-o",
+		"cache_size=42,umask=027",
+		"--timeout=10",
+		"-o",
+		"other",
+		"arg1",
+	}
+
+	expargs := []string{
+		"-o",
+		"other",
+		"arg1",
+	}
+
+	cfg := testOptStruct{Volname: "default", Ignored: 7}
+	outargs, err := OptParseStruct(args, &cfg)
+	if nil != err {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(expargs, outargs) {
+		t.Error(outargs)
+	}
+
+	if !cfg.Foreground || 42 != cfg.CacheSize || 027 != cfg.Umask ||
+		"default" != cfg.Volname || 10 != cfg.Timeout || 7 != cfg.Ignored {
+		t.Error(cfg)
+	}
+}
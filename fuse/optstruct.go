@@ -0,0 +1,255 @@
+/*
+ * optstruct.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+type optStructField struct {
+	templ []string
+	help  string
+	value reflect.Value
+}
+
+// optStructFields returns the tagged fields of the struct pointed to by v.
+func optStructFields(v interface{}) ([]optStructField, error) {
+	p := reflect.ValueOf(v)
+	if reflect.Ptr != p.Kind() || reflect.Struct != p.Elem().Kind() {
+		return nil, errors.New("OptParseStruct: expected pointer to struct")
+	}
+	s := p.Elem()
+	t := s.Type()
+	fields := make([]optStructField, 0, t.NumField())
+	for i := 0; t.NumField() > i; i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("fuse")
+		if !ok || "" == tag || "-" == tag {
+			continue
+		}
+		if "" != f.PkgPath {
+			return nil, errors.New("OptParseStruct: field " + f.Name + " is not exported")
+		}
+		switch f.Type.Kind() {
+		case reflect.Bool, reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Uintptr:
+		default:
+			return nil, errors.New("OptParseStruct: field " + f.Name +
+				" has unsupported type " + f.Type.String())
+		}
+		field := optStructField{help: f.Tag.Get("help"), value: s.Field(i)}
+		for _, templ := range strings.Split(tag, ",") {
+			templ = strings.TrimSpace(templ)
+			if strings.HasPrefix(templ, "-o ") {
+				templ = strings.TrimSpace(templ[3:])
+			}
+			if "" == templ || strings.ContainsAny(templ, " \t") {
+				return nil, errors.New("OptParseStruct: field " + f.Name +
+					" has invalid option " + tag)
+			}
+			field.templ = append(field.templ, templ)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// optStructPresence returns a template that matches whenever templ matches an
+// option that has a parameter.
+func optStructPresence(templ string) string {
+	if i := strings.Index(templ, "=%"); -1 != i {
+		return templ[:i+1]
+	} else if strings.HasSuffix(templ, "=") {
+		return templ
+	} else {
+		return templ + "="
+	}
+}
+
+// optStructTemp returns a pointer to a temporary of one of the types accepted by OptParse
+// that can hold a value of kind k.
+func optStructTemp(k reflect.Kind) reflect.Value {
+	switch k {
+	case reflect.Bool:
+		return reflect.New(reflect.TypeOf(false))
+	case reflect.String:
+		return reflect.New(reflect.TypeOf(""))
+	case reflect.Int:
+		return reflect.New(reflect.TypeOf(int(0)))
+	case reflect.Int8:
+		return reflect.New(reflect.TypeOf(int8(0)))
+	case reflect.Int16:
+		return reflect.New(reflect.TypeOf(int16(0)))
+	case reflect.Int32:
+		return reflect.New(reflect.TypeOf(int32(0)))
+	case reflect.Int64:
+		return reflect.New(reflect.TypeOf(int64(0)))
+	case reflect.Uint:
+		return reflect.New(reflect.TypeOf(uint(0)))
+	case reflect.Uint8:
+		return reflect.New(reflect.TypeOf(uint8(0)))
+	case reflect.Uint16:
+		return reflect.New(reflect.TypeOf(uint16(0)))
+	case reflect.Uint32:
+		return reflect.New(reflect.TypeOf(uint32(0)))
+	case reflect.Uint64:
+		return reflect.New(reflect.TypeOf(uint64(0)))
+	case reflect.Uintptr:
+		return reflect.New(reflect.TypeOf(uintptr(0)))
+	default:
+		panic("unknown kind " + k.String())
+	}
+}
+
+type optStructBinding struct {
+	value reflect.Value // struct field
+	temp  reflect.Value // pointer to temporary passed to OptParse
+	pres  *bool         // set if option present; nil for bool fields
+}
+
+// optStructFormat computes the OptParse format and values for the tagged fields of the
+// struct pointed to by v.
+func optStructFormat(v interface{}) (format string, vals []interface{},
+	bindings []optStructBinding, err error) {
+	fields, err := optStructFields(v)
+	if nil != err {
+		return "", nil, nil, err
+	}
+	var templs []string
+	for _, field := range fields {
+		for _, templ := range field.templ {
+			b := optStructBinding{value: field.value, temp: optStructTemp(field.value.Kind())}
+			templs = append(templs, templ)
+			vals = append(vals, b.temp.Interface())
+			if reflect.Bool != field.value.Kind() {
+				b.pres = new(bool)
+				templs = append(templs, optStructPresence(templ))
+				vals = append(vals, b.pres)
+			}
+			bindings = append(bindings, b)
+		}
+	}
+	return strings.Join(templs, " "), vals, bindings, nil
+}
+
+// OptParseStruct parses the FUSE command line arguments in args and stores the
+// resulting values in the fields of the struct pointed to by v. It returns a list of
+// unparsed arguments or nil if an error happens.
+//
+// OptParseStruct is built on top of OptParse. The options accepted by a field are
+// specified in a "fuse" struct tag, using the same syntax and verbs (d,o,x,X,v,s) as
+// the format passed to OptParse. A field may accept multiple options separated by
+// commas; an option may be prefixed by "-o " for readability. An optional "help"
+// struct tag describes the option and is used by OptHelpStruct. Fields without a
+// "fuse" tag are ignored. The allowed field types are bool, integer types and string.
+//
+// Unlike OptParse, fields retain their existing values unless a corresponding option
+// is present in args. This allows default values to be set prior to calling
+// OptParseStruct.
+//
+// For example:
+//
+//	type config struct {
+//	    Foreground bool   `fuse:"-f,--foreground" help:"run in the foreground"`
+//	    CacheSize  int    `fuse:"-o cache_size=%d" help:"cache size in bytes"`
+//	    Umask      uint32 `fuse:"umask=%o" help:"permission mask"`
+//	    Volname    string `fuse:"volname" help:"volume name"`
+//	}
+//	cfg := config{CacheSize: 1 << 20}
+//	outargs, err := OptParseStruct(args, &cfg)
+func OptParseStruct(args []string, v interface{}) (outargs []string, err error) {
+	format, vals, bindings, err := optStructFormat(v)
+	if nil != err {
+		return nil, err
+	}
+	if 0 == len(bindings) {
+		return append([]string{}, args...), nil
+	}
+	outargs, err = OptParse(args, format, vals...)
+	if nil != err {
+		return nil, err
+	}
+	for _, b := range bindings {
+		if nil == b.pres {
+			if b.temp.Elem().Bool() {
+				b.value.SetBool(true)
+			}
+		} else if *b.pres {
+			b.value.Set(b.temp.Elem().Convert(b.value.Type()))
+		}
+	}
+	return outargs, nil
+}
+
+func optHelpTempl(templ string, kind reflect.Kind) string {
+	param := ""
+	if i := strings.Index(templ, "=%"); -1 != i {
+		switch templ[i+2:] {
+		case "d":
+			param = "N"
+		case "o":
+			param = "OCT"
+		case "x", "X":
+			param = "HEX"
+		default:
+			param = "VALUE"
+		}
+		templ = templ[:i+1]
+	} else if strings.HasSuffix(templ, "=") {
+		param = "VALUE"
+	} else if reflect.Bool != kind {
+		templ += "="
+		param = "VALUE"
+	}
+	if !strings.HasPrefix(templ, "-") {
+		templ = "-o " + templ
+	}
+	return templ + param
+}
+
+// OptHelpStruct returns help text for the options accepted by OptParseStruct for the
+// struct pointed to by v. Each option is listed on a separate line followed by the
+// description found in its "help" struct tag.
+func OptHelpStruct(v interface{}) string {
+	fields, err := optStructFields(v)
+	if nil != err {
+		panic(err)
+	}
+	names := make([]string, len(fields))
+	width := 0
+	for i, field := range fields {
+		templs := make([]string, len(field.templ))
+		for j, templ := range field.templ {
+			templs[j] = optHelpTempl(templ, field.value.Kind())
+		}
+		names[i] = strings.Join(templs, " ")
+		if width < len(names[i]) {
+			width = len(names[i])
+		}
+	}
+	var b strings.Builder
+	for i, field := range fields {
+		b.WriteString("    ")
+		b.WriteString(names[i])
+		if "" != field.help {
+			b.WriteString(strings.Repeat(" ", width-len(names[i])+2))
+			b.WriteString(field.help)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,818 @@
+/*
+ * overlay.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	pathutil "path"
+	"strings"
+	"sync"
+)
+
+const (
+	overlayWhiteoutPrefix = ".wh."
+	overlayOpaqueName     = ".wh..wh..opq"
+)
+
+type overlayHandle struct {
+	layer int
+	fh    uint64
+}
+
+type overlayFs struct {
+	layers  []FileSystemInterface
+	lock    sync.RWMutex
+	hlock   sync.Mutex
+	handles map[uint64]overlayHandle
+	nexth   uint64
+}
+
+// Overlay returns a file system that combines multiple file systems (layers) into one,
+// similar to the Linux overlay file system. The first layer is the upper layer; the
+// remaining layers are lower layers, ordered from top to bottom.
+//
+// Files in a layer hide files with the same path in the layers below it; directories
+// are merged. All changes are made to the upper layer: files and directories are copied
+// up from the lower layers when they are modified, and deletions of lower files are
+// recorded as whiteouts in the upper layer. The lower layers are never modified and
+// may be read-only.
+//
+// Whiteouts and opaque directories are stored in the upper layer as regular files,
+// using the aufs naming convention: a file named ".wh.NAME" hides NAME in the lower
+// layers and a file named ".wh..wh..opq" hides the contents of its directory in the
+// lower layers. These files are not visible through the overlay and names that start
+// with ".wh." cannot be created.
+//
+// Renaming a directory that exists in a lower layer fails with -EXDEV (as with the
+// Linux overlay file system without redirect_dir); most programs then fall back to
+// copying. Copy-up breaks hard links between lower files.
+func Overlay(layers ...FileSystemInterface) FileSystemInterface {
+	if 0 == len(layers) {
+		panic("Overlay: no layers")
+	}
+	return &overlayFs{
+		layers:  append([]FileSystemInterface(nil), layers...),
+		handles: map[uint64]overlayHandle{},
+	}
+}
+
+func overlayWhiteout(path string) string {
+	dir, name := pathutil.Split(path)
+	return dir + overlayWhiteoutPrefix + name
+}
+
+func overlayOpaque(path string) string {
+	return pathutil.Join(path, overlayOpaqueName)
+}
+
+func overlayWriteFlags(flags int) bool {
+	return O_RDONLY != flags&O_ACCMODE || 0 != flags&(O_TRUNC|O_APPEND)
+}
+
+func (fs *overlayFs) exists(layer int, path string) bool {
+	stat := Stat_t{}
+	return 0 == fs.layers[layer].Getattr(path, &stat, ^uint64(0))
+}
+
+func (fs *overlayFs) whiteout(layer int, path string) bool {
+	return "/" != path && fs.exists(layer, overlayWhiteout(path))
+}
+
+// mergeEnd returns the end of the range of layers (starting at layer) whose directories
+// at path are merged. The range is limited to end and ends after the last layer that
+// contains the directory.
+func (fs *overlayFs) mergeEnd(path string, layer int, end int) int {
+	last := layer
+	if fs.exists(layer, overlayOpaque(path)) {
+		return last + 1
+	}
+	for i := layer + 1; end > i; i++ {
+		if fs.whiteout(i, path) {
+			break
+		}
+		stat := Stat_t{}
+		if 0 == fs.layers[i].Getattr(path, &stat, ^uint64(0)) {
+			if S_IFDIR != stat.Mode&S_IFMT {
+				break
+			}
+			last = i
+			if fs.exists(i, overlayOpaque(path)) {
+				break
+			}
+		}
+	}
+	return last + 1
+}
+
+// resolve returns the topmost layer that contains path and the attributes of path in
+// that layer. If path is a directory it also returns the end of the range of layers
+// whose directories are merged.
+func (fs *overlayFs) resolve(path string) (errc int, layer int, stat Stat_t, end int) {
+	errc = fs.layers[0].Getattr("/", &stat, ^uint64(0))
+	if 0 != errc {
+		return errc, -1, Stat_t{}, 0
+	}
+	end = fs.mergeEnd("/", 0, len(fs.layers))
+	dir := "/"
+	for _, name := range strings.Split(path, "/") {
+		if "" == name {
+			continue
+		}
+		if S_IFDIR != stat.Mode&S_IFMT {
+			return -ENOTDIR, -1, Stat_t{}, 0
+		}
+		p := pathutil.Join(dir, name)
+		layer = -1
+		for i := 0; end > i; i++ {
+			if 0 == fs.layers[i].Getattr(p, &stat, ^uint64(0)) {
+				layer = i
+				break
+			}
+			if fs.whiteout(i, p) {
+				break
+			}
+		}
+		if -1 == layer {
+			return -ENOENT, -1, Stat_t{}, 0
+		}
+		if S_IFDIR == stat.Mode&S_IFMT {
+			end = fs.mergeEnd(p, layer, end)
+		} else {
+			end = layer + 1
+		}
+		dir = p
+	}
+	return 0, layer, stat, end
+}
+
+type overlayEntry struct {
+	name string
+	stat *Stat_t
+}
+
+// list returns the entries of the directory path in a single layer, including
+// whiteouts.
+func (fs *overlayFs) list(layer int, path string) (int, []overlayEntry) {
+	ents := []overlayEntry{}
+	errc, fh := fs.layers[layer].Opendir(path)
+	if 0 != errc {
+		return errc, nil
+	}
+	defer fs.layers[layer].Releasedir(path, fh)
+	errc = fs.layers[layer].Readdir(path, func(name string, stat *Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			var s *Stat_t
+			if nil != stat {
+				s = &Stat_t{}
+				*s = *stat
+			}
+			ents = append(ents, overlayEntry{name, s})
+		}
+		return true
+	}, 0, fh)
+	return errc, ents
+}
+
+// merge returns the visible entries of the directory path.
+func (fs *overlayFs) merge(path string, layer int, end int) (int, []overlayEntry) {
+	ents := []overlayEntry{}
+	seen := map[string]bool{}
+	for i := layer; end > i; i++ {
+		errc, lents := fs.list(i, path)
+		if 0 != errc {
+			if i == layer {
+				return errc, nil
+			}
+			continue
+		}
+		hidden := []string{}
+		for _, ent := range lents {
+			if strings.HasPrefix(ent.name, overlayWhiteoutPrefix) {
+				if overlayOpaqueName != ent.name {
+					hidden = append(hidden, ent.name[len(overlayWhiteoutPrefix):])
+				}
+				continue
+			}
+			if !seen[ent.name] {
+				seen[ent.name] = true
+				ents = append(ents, ent)
+			}
+		}
+		for _, name := range hidden {
+			seen[name] = true
+		}
+	}
+	return 0, ents
+}
+
+// copyUp copies path and its ancestors to the upper layer if they are not already there.
+func (fs *overlayFs) copyUp(path string) int {
+	errc, layer, stat, _ := fs.resolve(path)
+	if 0 != errc || 0 == layer {
+		return errc
+	}
+	errc = fs.copyUp(pathutil.Dir(path))
+	if 0 != errc {
+		return errc
+	}
+	upper, lower := fs.layers[0], fs.layers[layer]
+	switch stat.Mode & S_IFMT {
+	case S_IFDIR:
+		errc = upper.Mkdir(path, stat.Mode&07777)
+	case S_IFLNK:
+		var target string
+		errc, target = lower.Readlink(path)
+		if 0 == errc {
+			errc = upper.Symlink(target, path)
+		}
+	case S_IFREG:
+		errc = fs.copyUpData(path, layer, stat.Mode&07777)
+	default:
+		errc = upper.Mknod(path, stat.Mode, stat.Rdev)
+	}
+	if 0 != errc {
+		return errc
+	}
+	// metadata is copied on a best effort basis
+	upper.Chown(path, stat.Uid, stat.Gid)
+	if S_IFLNK != stat.Mode&S_IFMT {
+		upper.Chmod(path, stat.Mode&07777)
+	}
+	lower.Listxattr(path, func(name string) bool {
+		if e, value := lower.Getxattr(path, name); 0 == e {
+			upper.Setxattr(path, name, value, 0)
+		}
+		return true
+	})
+	upper.Utimens(path, []Timespec{stat.Atim, stat.Mtim})
+	return 0
+}
+
+func (fs *overlayFs) copyUpData(path string, layer int, mode uint32) int {
+	upper, lower := fs.layers[0], fs.layers[layer]
+	errc, lfh := lower.Open(path, O_RDONLY)
+	if 0 != errc {
+		return errc
+	}
+	defer lower.Release(path, lfh)
+	errc, ufh := upper.Create(path, O_WRONLY|O_CREAT|O_EXCL, mode)
+	if -ENOSYS == errc {
+		errc = upper.Mknod(path, S_IFREG|mode, 0)
+		if 0 == errc {
+			errc, ufh = upper.Open(path, O_WRONLY)
+		}
+	}
+	if 0 != errc {
+		return errc
+	}
+	buff := make([]byte, 64*1024)
+	ofst := int64(0)
+	for {
+		n := lower.Read(path, buff, ofst, lfh)
+		if 0 < n {
+			n = upper.Write(path, buff[:n], ofst, ufh)
+		}
+		if 0 > n {
+			errc = n
+			break
+		}
+		if 0 == n {
+			break
+		}
+		ofst += int64(n)
+	}
+	upper.Release(path, ufh)
+	if 0 != errc {
+		upper.Unlink(path)
+	}
+	return errc
+}
+
+// whiteoutCreate hides path in the lower layers.
+func (fs *overlayFs) whiteoutCreate(path string) int {
+	errc := fs.copyUp(pathutil.Dir(path))
+	if 0 != errc {
+		return errc
+	}
+	return fs.markerCreate(overlayWhiteout(path))
+}
+
+func (fs *overlayFs) markerCreate(path string) int {
+	upper := fs.layers[0]
+	errc, fh := upper.Create(path, O_WRONLY|O_CREAT, 0)
+	if -ENOSYS == errc {
+		return upper.Mknod(path, S_IFREG, 0)
+	}
+	if 0 == errc {
+		upper.Release(path, fh)
+	}
+	return errc
+}
+
+// prepare prepares the upper layer for the creation of path. It returns true in opaque
+// if a new directory at path must be made opaque.
+func (fs *overlayFs) prepare(path string) (errc int, opaque bool) {
+	if strings.HasPrefix(pathutil.Base(path), overlayWhiteoutPrefix) {
+		return -EINVAL, false
+	}
+	if errc, _, _, _ := fs.resolve(path); 0 == errc {
+		return -EEXIST, false
+	} else if -ENOENT != errc {
+		return errc, false
+	}
+	errc = fs.copyUp(pathutil.Dir(path))
+	if 0 != errc {
+		return errc, false
+	}
+	if fs.whiteout(0, path) {
+		errc = fs.layers[0].Unlink(overlayWhiteout(path))
+		return errc, true
+	}
+	return 0, false
+}
+
+// unhide removes whiteouts from the upper layer directory path.
+func (fs *overlayFs) unhide(path string) int {
+	errc, ents := fs.list(0, path)
+	if 0 != errc {
+		return errc
+	}
+	for _, ent := range ents {
+		if strings.HasPrefix(ent.name, overlayWhiteoutPrefix) {
+			errc = fs.layers[0].Unlink(pathutil.Join(path, ent.name))
+			if 0 != errc {
+				return errc
+			}
+		}
+	}
+	return 0
+}
+
+func (fs *overlayFs) newHandle(layer int, fh uint64) uint64 {
+	fs.hlock.Lock()
+	defer fs.hlock.Unlock()
+	fs.nexth++
+	fs.handles[fs.nexth] = overlayHandle{layer, fh}
+	return fs.nexth
+}
+
+func (fs *overlayFs) getHandle(fh uint64) (overlayHandle, bool) {
+	fs.hlock.Lock()
+	defer fs.hlock.Unlock()
+	h, ok := fs.handles[fh]
+	return h, ok
+}
+
+func (fs *overlayFs) Init() {
+	for _, layer := range fs.layers {
+		layer.Init()
+	}
+}
+
+func (fs *overlayFs) Destroy() {
+	for _, layer := range fs.layers {
+		layer.Destroy()
+	}
+}
+
+func (fs *overlayFs) Statfs(path string, stat *Statfs_t) int {
+	return fs.layers[0].Statfs("/", stat)
+}
+
+func (fs *overlayFs) Mknod(path string, mode uint32, dev uint64) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc, _ := fs.prepare(path)
+	if 0 != errc {
+		return errc
+	}
+	return fs.layers[0].Mknod(path, mode, dev)
+}
+
+func (fs *overlayFs) Mkdir(path string, mode uint32) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc, opaque := fs.prepare(path)
+	if 0 != errc {
+		return errc
+	}
+	errc = fs.layers[0].Mkdir(path, mode)
+	if 0 == errc && opaque {
+		errc = fs.markerCreate(overlayOpaque(path))
+		if 0 != errc {
+			fs.layers[0].Rmdir(path)
+			fs.markerCreate(overlayWhiteout(path))
+		}
+	}
+	return errc
+}
+
+func (fs *overlayFs) Unlink(path string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc, layer, stat, _ := fs.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if S_IFDIR == stat.Mode&S_IFMT {
+		return -EISDIR
+	}
+	if 0 == layer {
+		errc = fs.layers[0].Unlink(path)
+		if 0 != errc {
+			return errc
+		}
+	}
+	if errc, _, _, _ := fs.resolve(path); 0 == errc {
+		return fs.whiteoutCreate(path)
+	}
+	return 0
+}
+
+func (fs *overlayFs) Rmdir(path string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc, layer, stat, end := fs.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	if S_IFDIR != stat.Mode&S_IFMT {
+		return -ENOTDIR
+	}
+	errc, ents := fs.merge(path, layer, end)
+	if 0 != errc {
+		return errc
+	}
+	if 0 != len(ents) {
+		return -ENOTEMPTY
+	}
+	if 0 == layer {
+		errc = fs.unhide(path)
+		if 0 == errc {
+			errc = fs.layers[0].Rmdir(path)
+		}
+		if 0 != errc {
+			return errc
+		}
+	}
+	if errc, _, _, _ := fs.resolve(path); 0 == errc {
+		return fs.whiteoutCreate(path)
+	}
+	return 0
+}
+
+func (fs *overlayFs) Link(oldpath string, newpath string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc := fs.copyUp(oldpath)
+	if 0 != errc {
+		return errc
+	}
+	errc, _ = fs.prepare(newpath)
+	if 0 != errc {
+		return errc
+	}
+	return fs.layers[0].Link(oldpath, newpath)
+}
+
+func (fs *overlayFs) Symlink(target string, newpath string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc, _ := fs.prepare(newpath)
+	if 0 != errc {
+		return errc
+	}
+	return fs.layers[0].Symlink(target, newpath)
+}
+
+func (fs *overlayFs) Readlink(path string) (int, string) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, layer, _, _ := fs.resolve(path)
+	if 0 != errc {
+		return errc, ""
+	}
+	return fs.layers[layer].Readlink(path)
+}
+
+func (fs *overlayFs) Rename(oldpath string, newpath string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if strings.HasPrefix(pathutil.Base(newpath), overlayWhiteoutPrefix) {
+		return -EINVAL
+	}
+	errc, layer, stat, end := fs.resolve(oldpath)
+	if 0 != errc {
+		return errc
+	}
+	isdir := S_IFDIR == stat.Mode&S_IFMT
+	if isdir && (0 != layer || 1 < end) {
+		// directory has contents in the lower layers
+		return -EXDEV
+	}
+	if oldpath == newpath {
+		return 0
+	}
+	opaque := false
+	nerrc, nlayer, nstat, nend := fs.resolve(newpath)
+	if 0 == nerrc {
+		if S_IFDIR == nstat.Mode&S_IFMT {
+			if !isdir {
+				return -EISDIR
+			}
+			errc, ents := fs.merge(newpath, nlayer, nend)
+			if 0 != errc {
+				return errc
+			}
+			if 0 != len(ents) {
+				return -ENOTEMPTY
+			}
+			if 0 == nlayer {
+				errc = fs.unhide(newpath)
+				if 0 != errc {
+					return errc
+				}
+			}
+			opaque = 0 != nlayer || 1 < nend
+		} else if isdir {
+			return -ENOTDIR
+		}
+	} else if -ENOENT != nerrc {
+		return nerrc
+	}
+	errc = fs.copyUp(oldpath)
+	if 0 == errc {
+		errc = fs.copyUp(pathutil.Dir(newpath))
+	}
+	if 0 != errc {
+		return errc
+	}
+	if fs.whiteout(0, newpath) {
+		errc = fs.layers[0].Unlink(overlayWhiteout(newpath))
+		if 0 != errc {
+			return errc
+		}
+		opaque = isdir
+	}
+	errc = fs.layers[0].Rename(oldpath, newpath)
+	if 0 != errc {
+		return errc
+	}
+	if opaque {
+		fs.markerCreate(overlayOpaque(newpath))
+	}
+	if errc, _, _, _ := fs.resolve(oldpath); 0 == errc {
+		return fs.whiteoutCreate(oldpath)
+	}
+	return 0
+}
+
+// modify copies path up and calls fn on the upper layer.
+func (fs *overlayFs) modify(path string, fn func(upper FileSystemInterface) int) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc := fs.copyUp(path)
+	if 0 != errc {
+		return errc
+	}
+	return fn(fs.layers[0])
+}
+
+func (fs *overlayFs) Chmod(path string, mode uint32) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return upper.Chmod(path, mode)
+	})
+}
+
+func (fs *overlayFs) Chown(path string, uid uint32, gid uint32) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return upper.Chown(path, uid, gid)
+	})
+}
+
+func (fs *overlayFs) Utimens(path string, tmsp []Timespec) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return upper.Utimens(path, tmsp)
+	})
+}
+
+func (fs *overlayFs) Access(path string, mask uint32) int {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, layer, _, _ := fs.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	return fs.layers[layer].Access(path, mask)
+}
+
+func (fs *overlayFs) Create(path string, flags int, mode uint32) (int, uint64) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	errc, _ := fs.prepare(path)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	errc, fh := fs.layers[0].Create(path, flags, mode)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, fs.newHandle(0, fh)
+}
+
+func (fs *overlayFs) Open(path string, flags int) (int, uint64) {
+	var errc, layer int
+	if overlayWriteFlags(flags) {
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		errc = fs.copyUp(path)
+	} else {
+		fs.lock.RLock()
+		defer fs.lock.RUnlock()
+		errc, layer, _, _ = fs.resolve(path)
+	}
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	errc, fh := fs.layers[layer].Open(path, flags)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, fs.newHandle(layer, fh)
+}
+
+func (fs *overlayFs) Getattr(path string, stat *Stat_t, fh uint64) int {
+	if h, ok := fs.getHandle(fh); ok {
+		return fs.layers[h.layer].Getattr(path, stat, h.fh)
+	}
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, _, s, _ := fs.resolve(path)
+	if 0 == errc {
+		*stat = s
+	}
+	return errc
+}
+
+func (fs *overlayFs) Truncate(path string, size int64, fh uint64) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		if h, ok := fs.getHandle(fh); ok && 0 == h.layer {
+			return upper.Truncate(path, size, h.fh)
+		}
+		return upper.Truncate(path, size, ^uint64(0))
+	})
+}
+
+func (fs *overlayFs) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	h, ok := fs.getHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	return fs.layers[h.layer].Read(path, buff, ofst, h.fh)
+}
+
+func (fs *overlayFs) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	h, ok := fs.getHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	return fs.layers[h.layer].Write(path, buff, ofst, h.fh)
+}
+
+func (fs *overlayFs) Flush(path string, fh uint64) int {
+	h, ok := fs.getHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	return fs.layers[h.layer].Flush(path, h.fh)
+}
+
+func (fs *overlayFs) Release(path string, fh uint64) int {
+	fs.hlock.Lock()
+	h, ok := fs.handles[fh]
+	delete(fs.handles, fh)
+	fs.hlock.Unlock()
+	if !ok {
+		return -EBADF
+	}
+	return fs.layers[h.layer].Release(path, h.fh)
+}
+
+func (fs *overlayFs) Fsync(path string, datasync bool, fh uint64) int {
+	h, ok := fs.getHandle(fh)
+	if !ok {
+		return -EBADF
+	}
+	return fs.layers[h.layer].Fsync(path, datasync, h.fh)
+}
+
+func (fs *overlayFs) Opendir(path string) (int, uint64) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, _, stat, _ := fs.resolve(path)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if S_IFDIR != stat.Mode&S_IFMT {
+		return -ENOTDIR, ^uint64(0)
+	}
+	return 0, 0
+}
+
+func (fs *overlayFs) Readdir(path string,
+	fill func(name string, stat *Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) int {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, layer, stat, end := fs.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	errc, ents := fs.merge(path, layer, end)
+	if 0 != errc {
+		return errc
+	}
+	fill(".", &stat, 0)
+	fill("..", nil, 0)
+	for _, ent := range ents {
+		if !fill(ent.name, ent.stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func (fs *overlayFs) Releasedir(path string, fh uint64) int {
+	return 0
+}
+
+func (fs *overlayFs) Fsyncdir(path string, datasync bool, fh uint64) int {
+	return -ENOSYS
+}
+
+func (fs *overlayFs) Setxattr(path string, name string, value []byte, flags int) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return upper.Setxattr(path, name, value, flags)
+	})
+}
+
+func (fs *overlayFs) Getxattr(path string, name string) (int, []byte) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, layer, _, _ := fs.resolve(path)
+	if 0 != errc {
+		return errc, nil
+	}
+	return fs.layers[layer].Getxattr(path, name)
+}
+
+func (fs *overlayFs) Removexattr(path string, name string) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return upper.Removexattr(path, name)
+	})
+}
+
+func (fs *overlayFs) Listxattr(path string, fill func(name string) bool) int {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	errc, layer, _, _ := fs.resolve(path)
+	if 0 != errc {
+		return errc
+	}
+	return fs.layers[layer].Listxattr(path, fill)
+}
+
+func (fs *overlayFs) Chflags(path string, flags uint32) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return fsChflags(upper, path, flags)
+	})
+}
+
+func (fs *overlayFs) Setcrtime(path string, tmsp Timespec) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return fsSetcrtime(upper, path, tmsp)
+	})
+}
+
+func (fs *overlayFs) Setchgtime(path string, tmsp Timespec) int {
+	return fs.modify(path, func(upper FileSystemInterface) int {
+		return fsSetchgtime(upper, path, tmsp)
+	})
+}
+
+var (
+	_ FileSystemInterface  = (*overlayFs)(nil)
+	_ FileSystemChflags    = (*overlayFs)(nil)
+	_ FileSystemSetcrtime  = (*overlayFs)(nil)
+	_ FileSystemSetchgtime = (*overlayFs)(nil)
+)
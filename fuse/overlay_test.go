@@ -0,0 +1,203 @@
+/*
+ * overlay_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestOverlay() (*testFs, *testFs, FileSystemInterface) {
+	upper, lower := newTestFs(), newTestFs()
+	lower.create("/dir", S_IFDIR|0755, "")
+	lower.create("/dir/a", S_IFREG|0644, "lower a")
+	lower.create("/dir/b", S_IFREG|0644, "lower b")
+	lower.create("/dir/sub", S_IFDIR|0755, "")
+	lower.create("/dir/sub/c", S_IFREG|0644, "lower c")
+	lower.create("/file", S_IFREG|0600, "lower file")
+	upper.create("/dir", S_IFDIR|0755, "")
+	upper.create("/dir/a", S_IFREG|0644, "upper a")
+	upper.create("/dir/u", S_IFREG|0644, "upper u")
+	return upper, lower, Overlay(upper, ReadOnly(lower))
+}
+
+func testOverlayNames(t *testing.T, fs FileSystemInterface, path string, expect ...string) {
+	t.Helper()
+	errc, names := testReaddir(fs, path)
+	if nil == expect {
+		expect = []string{}
+	}
+	if 0 != errc || !reflect.DeepEqual(expect, names) {
+		t.Errorf("%s: %d %v; expected %v", path, errc, names, expect)
+	}
+}
+
+func testOverlayData(t *testing.T, fs FileSystemInterface, path string, expect string) {
+	t.Helper()
+	if errc, data := testReadFile(fs, path); 0 != errc || expect != data {
+		t.Errorf("%s: %d %q; expected %q", path, errc, data, expect)
+	}
+}
+
+func testOverlayErrc(t *testing.T, fs FileSystemInterface, path string, expect int) {
+	t.Helper()
+	stat := Stat_t{}
+	if errc := fs.Getattr(path, &stat, ^uint64(0)); expect != errc {
+		t.Errorf("%s: %d; expected %d", path, errc, expect)
+	}
+}
+
+func TestOverlayLookup(t *testing.T) {
+	_, _, fs := newTestOverlay()
+	testOverlayNames(t, fs, "/", "dir", "file")
+	testOverlayNames(t, fs, "/dir", "a", "b", "sub", "u")
+	testOverlayData(t, fs, "/dir/a", "upper a")
+	testOverlayData(t, fs, "/dir/b", "lower b")
+	testOverlayData(t, fs, "/dir/sub/c", "lower c")
+	testOverlayErrc(t, fs, "/dir/x", -ENOENT)
+	testOverlayErrc(t, fs, "/file/x", -ENOTDIR)
+}
+
+func TestOverlayCopyUp(t *testing.T) {
+	upper, lower, fs := newTestOverlay()
+
+	if errc := testWriteFile(fs, "/dir/sub/c", "UPPER", 0); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayData(t, fs, "/dir/sub/c", "UPPER c")
+	testOverlayData(t, lower, "/dir/sub/c", "lower c")
+	testOverlayData(t, upper, "/dir/sub/c", "UPPER c")
+
+	if errc := fs.Chmod("/file", 0640); 0 != errc {
+		t.Error(errc)
+	}
+	stat := Stat_t{}
+	if fs.Getattr("/file", &stat, ^uint64(0)); S_IFREG|0640 != stat.Mode {
+		t.Errorf("%o", stat.Mode)
+	}
+	if lower.Getattr("/file", &stat, ^uint64(0)); S_IFREG|0600 != stat.Mode {
+		t.Errorf("%o", stat.Mode)
+	}
+	testOverlayData(t, upper, "/file", "lower file")
+
+	if errc := fs.Setxattr("/dir/b", "user.x", []byte("x"), 0); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, _ := lower.Getxattr("/dir/b", "user.x"); -ENOATTR != errc {
+		t.Error(errc)
+	}
+}
+
+func TestOverlayWhiteout(t *testing.T) {
+	upper, _, fs := newTestOverlay()
+
+	if errc := fs.Unlink("/dir/a"); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayErrc(t, fs, "/dir/a", -ENOENT)
+	testOverlayNames(t, fs, "/dir", "b", "sub", "u")
+	testOverlayNames(t, upper, "/dir", ".wh.a", "u")
+
+	if errc := fs.Unlink("/dir/a"); -ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc, _ := fs.Create("/dir/.wh.b", O_RDWR, 0644); -EINVAL != errc {
+		t.Error(errc)
+	}
+
+	// recreate removes the whiteout
+	errc, fh := fs.Create("/dir/a", O_RDWR, 0644)
+	if 0 != errc {
+		t.Error(errc)
+	}
+	fs.Write("/dir/a", []byte("new a"), 0, fh)
+	fs.Release("/dir/a", fh)
+	testOverlayData(t, fs, "/dir/a", "new a")
+	testOverlayNames(t, upper, "/dir", "a", "u")
+
+	if errc := fs.Rmdir("/dir/sub"); -ENOTEMPTY != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Unlink("/dir/sub/c"); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayNames(t, fs, "/dir/sub")
+	if errc := fs.Rmdir("/dir/sub"); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayErrc(t, fs, "/dir/sub", -ENOENT)
+	testOverlayErrc(t, fs, "/dir/sub/c", -ENOENT)
+	testOverlayNames(t, upper, "/dir", ".wh.sub", "a", "u")
+
+	// recreated directory is opaque
+	if errc := fs.Mkdir("/dir/sub", 0755); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayNames(t, fs, "/dir/sub")
+	testOverlayErrc(t, fs, "/dir/sub/c", -ENOENT)
+	testOverlayNames(t, fs, "/dir", "a", "b", "sub", "u")
+}
+
+func TestOverlayRename(t *testing.T) {
+	upper, lower, fs := newTestOverlay()
+
+	if errc := fs.Rename("/dir/b", "/dir/u"); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayData(t, fs, "/dir/u", "lower b")
+	testOverlayErrc(t, fs, "/dir/b", -ENOENT)
+	testOverlayData(t, lower, "/dir/b", "lower b")
+	testOverlayNames(t, upper, "/dir", ".wh.b", "a", "u")
+
+	if errc := fs.Rename("/dir/sub", "/sub"); -EXDEV != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Rename("/dir/a", "/dir/sub"); -EISDIR != errc {
+		t.Error(errc)
+	}
+
+	if errc := fs.Mkdir("/new", 0755); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Rename("/dir/sub/c", "/new/c"); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Rename("/new", "/dir/sub"); 0 != errc {
+		t.Error(errc)
+	}
+	testOverlayNames(t, fs, "/", "dir", "file")
+	testOverlayNames(t, fs, "/dir/sub", "c")
+	testOverlayData(t, fs, "/dir/sub/c", "lower c")
+}
+
+func TestOverlayLayers(t *testing.T) {
+	upper, middle, lower := newTestFs(), newTestFs(), newTestFs()
+	lower.create("/d", S_IFDIR|0755, "")
+	lower.create("/d/x", S_IFREG|0644, "x")
+	lower.create("/d/y", S_IFREG|0644, "y")
+	middle.create("/d", S_IFDIR|0755, "")
+	middle.create("/d/"+overlayOpaqueName, S_IFREG, "")
+	middle.create("/d/z", S_IFREG|0644, "z")
+	middle.create("/e", S_IFREG|0644, "e")
+	lower.create("/e", S_IFDIR|0755, "")
+	lower.create("/e/w", S_IFREG|0644, "w")
+	upper.create("/d", S_IFDIR|0755, "")
+	upper.create("/d/x", S_IFREG|0644, "upper x")
+	fs := Overlay(upper, middle, lower)
+
+	testOverlayNames(t, fs, "/d", "x", "z")
+	testOverlayData(t, fs, "/d/x", "upper x")
+	testOverlayErrc(t, fs, "/d/y", -ENOENT)
+	testOverlayData(t, fs, "/e", "e")
+	testOverlayErrc(t, fs, "/e/w", -ENOTDIR)
+}
@@ -0,0 +1,683 @@
+/*
+ * quota.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	pathutil "path"
+	"strings"
+	"sync"
+)
+
+// QuotaLimits contains the limits of a quota. Zero means no limit.
+type QuotaLimits struct {
+	// Maximum number of bytes in regular files and symbolic links.
+	Bytes int64
+
+	// Maximum number of inodes.
+	Inodes int64
+}
+
+// QuotaUsage contains the resources charged against a quota.
+type QuotaUsage struct {
+	Bytes  int64
+	Inodes int64
+}
+
+// QuotaOptions contains options for a Quota.
+type QuotaOptions struct {
+	// Limits per user ID.
+	Users map[uint32]QuotaLimits
+
+	// Limits for user IDs not in Users.
+	DefaultUser QuotaLimits
+
+	// Limits per top-level directory name.
+	Trees map[string]QuotaLimits
+
+	// Limits for top-level directories not in Trees.
+	DefaultTree QuotaLimits
+
+	// Getcontext returns the user ID of the current operation. If nil the package
+	// function Getcontext is used.
+	Getcontext func() (uid uint32, gid uint32, pid int)
+}
+
+// Quota is a file system that enforces quotas on another file system. It charges the
+// bytes in regular files and symbolic links and the number of inodes against the
+// owner of each file and against the top-level directory that contains it. Operations
+// that would exceed a quota fail with -EDQUOT. Statfs reports the space remaining to
+// the caller as free space.
+//
+// Usage is computed by walking the file system during Init or when Rescan is called;
+// afterwards it is maintained from the operations that pass through the Quota.
+//
+// A file with multiple hard links is charged once, against the top-level directory
+// in which the walk finds it first or in which it was created. Link and Rename fail
+// with -EXDEV if they would place links to the same file in different top-level
+// directories.
+type Quota struct {
+	FileSystemInterface
+	opts  QuotaOptions
+	lock  sync.Mutex
+	users map[uint32]*QuotaUsage
+	trees map[string]*QuotaUsage
+	files map[string]*quotaFile
+	links map[uint64]string // tree charged for files with multiple links
+}
+
+type quotaFile struct {
+	lock sync.Mutex
+	refs int
+}
+
+// NewQuota creates a Quota for the file system fs.
+func NewQuota(fs FileSystemInterface, opts QuotaOptions) *Quota {
+	if nil == opts.Getcontext {
+		opts.Getcontext = Getcontext
+	}
+	return &Quota{
+		FileSystemInterface: fs,
+		opts:                opts,
+		users:               map[uint32]*QuotaUsage{},
+		trees:               map[string]*QuotaUsage{},
+		files:               map[string]*quotaFile{},
+		links:               map[uint64]string{},
+	}
+}
+
+func quotaTree(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(path, '/'); -1 != i {
+		path = path[:i]
+	}
+	return path
+}
+
+func quotaBytes(stat *Stat_t) int64 {
+	switch stat.Mode & S_IFMT {
+	case S_IFREG, S_IFLNK:
+		return stat.Size
+	}
+	return 0
+}
+
+func quotaExceeds(usage *QuotaUsage, limits QuotaLimits, bytes int64, inodes int64) bool {
+	return (0 < bytes && 0 != limits.Bytes && usage.Bytes+bytes > limits.Bytes) ||
+		(0 < inodes && 0 != limits.Inodes && usage.Inodes+inodes > limits.Inodes)
+}
+
+func quotaRemaining(usage *QuotaUsage, limits QuotaLimits) (bytes int64, inodes int64) {
+	bytes, inodes = -1, -1
+	if 0 != limits.Bytes {
+		bytes = limits.Bytes - usage.Bytes
+		if 0 > bytes {
+			bytes = 0
+		}
+	}
+	if 0 != limits.Inodes {
+		inodes = limits.Inodes - usage.Inodes
+		if 0 > inodes {
+			inodes = 0
+		}
+	}
+	return
+}
+
+func (q *Quota) userLimits(uid uint32) QuotaLimits {
+	if limits, ok := q.opts.Users[uid]; ok {
+		return limits
+	}
+	return q.opts.DefaultUser
+}
+
+func (q *Quota) treeLimits(tree string) QuotaLimits {
+	if "" == tree {
+		return QuotaLimits{}
+	}
+	if limits, ok := q.opts.Trees[tree]; ok {
+		return limits
+	}
+	return q.opts.DefaultTree
+}
+
+func (q *Quota) user(uid uint32) *QuotaUsage {
+	usage := q.users[uid]
+	if nil == usage {
+		usage = &QuotaUsage{}
+		q.users[uid] = usage
+	}
+	return usage
+}
+
+func (q *Quota) tree(tree string) *QuotaUsage {
+	usage := q.trees[tree]
+	if nil == usage {
+		usage = &QuotaUsage{}
+		q.trees[tree] = usage
+	}
+	return usage
+}
+
+// charge charges bytes and inodes against the quotas of uid and tree. If check is
+// true and the charge would exceed a quota it fails with -EDQUOT; releases of
+// resources are never checked.
+func (q *Quota) charge(uid uint32, tree string, bytes int64, inodes int64, check bool) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	uusage, tusage := q.user(uid), q.tree(tree)
+	if check &&
+		(quotaExceeds(uusage, q.userLimits(uid), bytes, inodes) ||
+			quotaExceeds(tusage, q.treeLimits(tree), bytes, inodes)) {
+		return -EDQUOT
+	}
+	uusage.Bytes += bytes
+	uusage.Inodes += inodes
+	tusage.Bytes += bytes
+	tusage.Inodes += inodes
+	return 0
+}
+
+// walk calls fn for path and everything below it. Hard-linked files are reported once;
+// seen counts the links to them that were found.
+func (q *Quota) walk(path string, seen map[uint64]uint32, fn func(path string, stat *Stat_t)) {
+	stat := Stat_t{}
+	if 0 != q.FileSystemInterface.Getattr(path, &stat, ^uint64(0)) {
+		return
+	}
+	if S_IFDIR != stat.Mode&S_IFMT && 1 < stat.Nlink {
+		seen[stat.Ino]++
+		if 1 < seen[stat.Ino] {
+			return
+		}
+	}
+	fn(path, &stat)
+	if S_IFDIR != stat.Mode&S_IFMT {
+		return
+	}
+	errc, fh := q.FileSystemInterface.Opendir(path)
+	if 0 != errc {
+		return
+	}
+	names := []string{}
+	q.FileSystemInterface.Readdir(path, func(name string, stat *Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			names = append(names, name)
+		}
+		return true
+	}, 0, fh)
+	q.FileSystemInterface.Releasedir(path, fh)
+	for _, name := range names {
+		q.walk(pathutil.Join(path, name), seen, fn)
+	}
+}
+
+// measure returns the usage of path and everything below it per user ID. Files with
+// multiple links are only included if all their links are below path; their inode
+// numbers are returned in links. If any are not, split is true.
+func (q *Quota) measure(path string) (users map[uint32]QuotaUsage, total QuotaUsage,
+	links []uint64, split bool) {
+	users = map[uint32]QuotaUsage{}
+	seen := map[uint64]uint32{}
+	multi := map[uint64]Stat_t{}
+	add := func(stat *Stat_t) {
+		usage := users[stat.Uid]
+		usage.Bytes += quotaBytes(stat)
+		usage.Inodes++
+		users[stat.Uid] = usage
+		total.Bytes += quotaBytes(stat)
+		total.Inodes++
+	}
+	q.walk(path, seen, func(path string, stat *Stat_t) {
+		if S_IFDIR != stat.Mode&S_IFMT && 1 < stat.Nlink {
+			multi[stat.Ino] = *stat
+			return
+		}
+		add(stat)
+	})
+	for ino, stat := range multi {
+		if seen[ino] < stat.Nlink {
+			split = true
+			continue
+		}
+		add(&stat)
+		links = append(links, ino)
+	}
+	return
+}
+
+// Rescan recomputes the usage of all quotas by walking the file system.
+func (q *Quota) Rescan() {
+	users := map[uint32]*QuotaUsage{}
+	trees := map[string]*QuotaUsage{}
+	links := map[uint64]string{}
+	q.walk("/", map[uint64]uint32{}, func(path string, stat *Stat_t) {
+		if "/" == path {
+			return
+		}
+		if S_IFDIR != stat.Mode&S_IFMT && 1 < stat.Nlink {
+			links[stat.Ino] = quotaTree(path)
+		}
+		uusage := users[stat.Uid]
+		if nil == uusage {
+			uusage = &QuotaUsage{}
+			users[stat.Uid] = uusage
+		}
+		tusage := trees[quotaTree(path)]
+		if nil == tusage {
+			tusage = &QuotaUsage{}
+			trees[quotaTree(path)] = tusage
+		}
+		uusage.Bytes += quotaBytes(stat)
+		uusage.Inodes++
+		tusage.Bytes += quotaBytes(stat)
+		tusage.Inodes++
+	})
+	q.lock.Lock()
+	q.users, q.trees, q.links = users, trees, links
+	q.lock.Unlock()
+}
+
+// UserUsage returns the usage charged against the quota of the user ID uid.
+func (q *Quota) UserUsage(uid uint32) QuotaUsage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return *q.user(uid)
+}
+
+// TreeUsage returns the usage charged against the quota of the top-level directory
+// with the specified name.
+func (q *Quota) TreeUsage(name string) QuotaUsage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return *q.tree(name)
+}
+
+func (q *Quota) Init() {
+	q.FileSystemInterface.Init()
+	q.Rescan()
+}
+
+func (q *Quota) Statfs(path string, stat *Statfs_t) int {
+	errc := q.FileSystemInterface.Statfs(path, stat)
+	if 0 != errc {
+		return errc
+	}
+	uid, _, _ := q.opts.Getcontext()
+	tree := quotaTree(path)
+	q.lock.Lock()
+	ubytes, uinodes := quotaRemaining(q.user(uid), q.userLimits(uid))
+	tbytes, tinodes := quotaRemaining(q.tree(tree), q.treeLimits(tree))
+	q.lock.Unlock()
+	bsize := stat.Frsize
+	if 0 == bsize {
+		bsize = stat.Bsize
+	}
+	if 0 == bsize {
+		bsize = 4096
+	}
+	for _, bytes := range []int64{ubytes, tbytes} {
+		if 0 <= bytes {
+			blocks := uint64(bytes) / bsize
+			if stat.Bfree > blocks {
+				stat.Bfree = blocks
+			}
+			if stat.Bavail > blocks {
+				stat.Bavail = blocks
+			}
+		}
+	}
+	for _, inodes := range []int64{uinodes, tinodes} {
+		if 0 <= inodes {
+			if stat.Ffree > uint64(inodes) {
+				stat.Ffree = uint64(inodes)
+			}
+			if stat.Favail > uint64(inodes) {
+				stat.Favail = uint64(inodes)
+			}
+		}
+	}
+	return 0
+}
+
+// created charges a newly created file against the quotas of its owner, which may
+// differ from the user ID charged before its creation.
+func (q *Quota) created(path string, uid uint32, bytes int64) {
+	stat := Stat_t{}
+	if 0 == q.FileSystemInterface.Getattr(path, &stat, ^uint64(0)) && stat.Uid != uid {
+		tree := quotaTree(path)
+		q.charge(uid, tree, -bytes, -1, false)
+		q.charge(stat.Uid, tree, quotaBytes(&stat), 1, false)
+	}
+}
+
+func (q *Quota) make(path string, bytes int64, op func() int) int {
+	uid, _, _ := q.opts.Getcontext()
+	tree := quotaTree(path)
+	if errc := q.charge(uid, tree, bytes, 1, true); 0 != errc {
+		return errc
+	}
+	errc := op()
+	if 0 != errc {
+		q.charge(uid, tree, -bytes, -1, false)
+		return errc
+	}
+	q.created(path, uid, bytes)
+	return 0
+}
+
+func (q *Quota) Mknod(path string, mode uint32, dev uint64) int {
+	return q.make(path, 0, func() int {
+		return q.FileSystemInterface.Mknod(path, mode, dev)
+	})
+}
+
+func (q *Quota) Mkdir(path string, mode uint32) int {
+	return q.make(path, 0, func() int {
+		return q.FileSystemInterface.Mkdir(path, mode)
+	})
+}
+
+func (q *Quota) Symlink(target string, newpath string) int {
+	return q.make(newpath, int64(len(target)), func() int {
+		return q.FileSystemInterface.Symlink(target, newpath)
+	})
+}
+
+func (q *Quota) Create(path string, flags int, mode uint32) (int, uint64) {
+	fh := ^uint64(0)
+	errc := q.make(path, 0, func() (errc int) {
+		errc, fh = q.FileSystemInterface.Create(path, flags, mode)
+		return
+	})
+	return errc, fh
+}
+
+func (q *Quota) remove(path string, op func() int) int {
+	stat := Stat_t{}
+	errc := q.FileSystemInterface.Getattr(path, &stat, ^uint64(0))
+	if 0 != errc {
+		return errc
+	}
+	errc = op()
+	if 0 == errc && (S_IFDIR == stat.Mode&S_IFMT || 1 >= stat.Nlink) {
+		// credit the tree that the file was charged against
+		q.lock.Lock()
+		tree, ok := q.links[stat.Ino]
+		delete(q.links, stat.Ino)
+		q.lock.Unlock()
+		if !ok {
+			tree = quotaTree(path)
+		}
+		q.charge(stat.Uid, tree, -quotaBytes(&stat), -1, false)
+	}
+	return errc
+}
+
+// Link fails with -EXDEV if newpath is in a different top-level directory than
+// oldpath. A new link is not charged, since the file is already charged.
+func (q *Quota) Link(oldpath string, newpath string) int {
+	tree := quotaTree(oldpath)
+	if tree != quotaTree(newpath) {
+		return -EXDEV
+	}
+	errc := q.FileSystemInterface.Link(oldpath, newpath)
+	if 0 == errc {
+		stat := Stat_t{}
+		if 0 == q.FileSystemInterface.Getattr(newpath, &stat, ^uint64(0)) {
+			q.lock.Lock()
+			if _, ok := q.links[stat.Ino]; !ok {
+				q.links[stat.Ino] = tree
+			}
+			q.lock.Unlock()
+		}
+	}
+	return errc
+}
+
+func (q *Quota) Unlink(path string) int {
+	return q.remove(path, func() int {
+		return q.FileSystemInterface.Unlink(path)
+	})
+}
+
+func (q *Quota) Rmdir(path string) int {
+	return q.remove(path, func() int {
+		return q.FileSystemInterface.Rmdir(path)
+	})
+}
+
+func (q *Quota) Rename(oldpath string, newpath string) int {
+	ostat, nstat := Stat_t{}, Stat_t{}
+	errc := q.FileSystemInterface.Getattr(oldpath, &ostat, ^uint64(0))
+	if 0 != errc {
+		return errc
+	}
+
+	// usage of the file or directory being replaced
+	var rusers map[uint32]QuotaUsage
+	var rtotal QuotaUsage
+	var rlinks []uint64
+	if 0 == q.FileSystemInterface.Getattr(newpath, &nstat, ^uint64(0)) &&
+		ostat.Ino != nstat.Ino {
+		rusers, rtotal, rlinks, _ = q.measure(newpath)
+	}
+
+	// usage moved between top-level directories is charged to the new one in advance
+	otree, ntree := quotaTree(oldpath), quotaTree(newpath)
+	var mtotal QuotaUsage
+	var mlinks []uint64
+	if otree != ntree {
+		var split bool
+		_, mtotal, mlinks, split = q.measure(oldpath)
+		if split {
+			// links to the same file would end up in different trees
+			return -EXDEV
+		}
+		q.lock.Lock()
+		tusage := q.tree(ntree)
+		exceeds := quotaExceeds(tusage, q.treeLimits(ntree),
+			mtotal.Bytes-rtotal.Bytes, mtotal.Inodes-rtotal.Inodes)
+		if !exceeds {
+			tusage.Bytes += mtotal.Bytes
+			tusage.Inodes += mtotal.Inodes
+		}
+		q.lock.Unlock()
+		if exceeds {
+			return -EDQUOT
+		}
+	}
+
+	errc = q.FileSystemInterface.Rename(oldpath, newpath)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if 0 != errc {
+		tusage := q.tree(ntree)
+		if otree != ntree {
+			tusage.Bytes -= mtotal.Bytes
+			tusage.Inodes -= mtotal.Inodes
+		}
+		return errc
+	}
+	if otree != ntree {
+		tusage := q.tree(otree)
+		tusage.Bytes -= mtotal.Bytes
+		tusage.Inodes -= mtotal.Inodes
+		for _, ino := range mlinks {
+			q.links[ino] = ntree
+		}
+	}
+	for _, ino := range rlinks {
+		delete(q.links, ino)
+	}
+	for uid, usage := range rusers {
+		uusage := q.user(uid)
+		uusage.Bytes -= usage.Bytes
+		uusage.Inodes -= usage.Inodes
+	}
+	tusage := q.tree(ntree)
+	tusage.Bytes -= rtotal.Bytes
+	tusage.Inodes -= rtotal.Inodes
+	return 0
+}
+
+func (q *Quota) Chown(path string, uid uint32, gid uint32) int {
+	stat := Stat_t{}
+	errc := q.FileSystemInterface.Getattr(path, &stat, ^uint64(0))
+	if 0 != errc {
+		return errc
+	}
+	if ^uint32(0) == uid || stat.Uid == uid {
+		return q.FileSystemInterface.Chown(path, uid, gid)
+	}
+
+	// usage is charged to the new owner in advance
+	bytes := quotaBytes(&stat)
+	q.lock.Lock()
+	uusage := q.user(uid)
+	exceeds := quotaExceeds(uusage, q.userLimits(uid), bytes, 1)
+	if !exceeds {
+		uusage.Bytes += bytes
+		uusage.Inodes++
+	}
+	q.lock.Unlock()
+	if exceeds {
+		return -EDQUOT
+	}
+
+	errc = q.FileSystemInterface.Chown(path, uid, gid)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if 0 != errc {
+		uusage = q.user(uid)
+	} else {
+		uusage = q.user(stat.Uid)
+	}
+	uusage.Bytes -= bytes
+	uusage.Inodes--
+	return errc
+}
+
+// lockFile serializes operations that change the size of the file at path. It returns
+// a function that unlocks the file.
+func (q *Quota) lockFile(path string) func() {
+	q.lock.Lock()
+	file := q.files[path]
+	if nil == file {
+		file = &quotaFile{}
+		q.files[path] = file
+	}
+	file.refs++
+	q.lock.Unlock()
+	file.lock.Lock()
+	return func() {
+		file.lock.Unlock()
+		q.lock.Lock()
+		file.refs--
+		if 0 == file.refs {
+			delete(q.files, path)
+		}
+		q.lock.Unlock()
+	}
+}
+
+// resize charges the change of the size of a regular file to size against its
+// quotas, performs op and corrects the charge using the file size that op reports.
+// Resizes of a file are serialized, so that the size read before op remains current.
+func (q *Quota) resize(path string, fh uint64,
+	size func(old int64) int64, op func(old int64) (int, int64)) int {
+	defer q.lockFile(path)()
+	stat := Stat_t{}
+	errc := q.FileSystemInterface.Getattr(path, &stat, fh)
+	if 0 != errc {
+		return errc
+	}
+	if S_IFREG != stat.Mode&S_IFMT {
+		rslt, _ := op(stat.Size)
+		return rslt
+	}
+	tree := quotaTree(path)
+	delta := size(stat.Size) - stat.Size
+	if errc := q.charge(stat.Uid, tree, delta, 0, true); 0 != errc {
+		return errc
+	}
+	rslt, nsize := op(stat.Size)
+	q.charge(stat.Uid, tree, nsize-stat.Size-delta, 0, false)
+	return rslt
+}
+
+func (q *Quota) Open(path string, flags int) (int, uint64) {
+	if 0 == flags&O_TRUNC {
+		return q.FileSystemInterface.Open(path, flags)
+	}
+	// with atomic_o_trunc a truncating open does not call Truncate
+	defer q.lockFile(path)()
+	stat := Stat_t{}
+	serrc := q.FileSystemInterface.Getattr(path, &stat, ^uint64(0))
+	errc, fh := q.FileSystemInterface.Open(path, flags)
+	if 0 == errc && 0 == serrc && S_IFREG == stat.Mode&S_IFMT {
+		q.charge(stat.Uid, quotaTree(path), -stat.Size, 0, false)
+	}
+	return errc, fh
+}
+
+func (q *Quota) Truncate(path string, size int64, fh uint64) int {
+	return q.resize(path, fh,
+		func(old int64) int64 {
+			return size
+		},
+		func(old int64) (int, int64) {
+			errc := q.FileSystemInterface.Truncate(path, size, fh)
+			if 0 != errc {
+				return errc, old
+			}
+			return 0, size
+		})
+}
+
+func (q *Quota) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	return q.resize(path, fh,
+		func(old int64) int64 {
+			if end := ofst + int64(len(buff)); old < end {
+				return end
+			}
+			return old
+		},
+		func(old int64) (int, int64) {
+			n := q.FileSystemInterface.Write(path, buff, ofst, fh)
+			if end := ofst + int64(n); 0 < n && old < end {
+				return n, end
+			}
+			return n, old
+		})
+}
+
+func (q *Quota) Chflags(path string, flags uint32) int {
+	return fsChflags(q.FileSystemInterface, path, flags)
+}
+
+func (q *Quota) Setcrtime(path string, tmsp Timespec) int {
+	return fsSetcrtime(q.FileSystemInterface, path, tmsp)
+}
+
+func (q *Quota) Setchgtime(path string, tmsp Timespec) int {
+	return fsSetchgtime(q.FileSystemInterface, path, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*Quota)(nil)
+	_ FileSystemChflags    = (*Quota)(nil)
+	_ FileSystemSetcrtime  = (*Quota)(nil)
+	_ FileSystemSetchgtime = (*Quota)(nil)
+)
@@ -0,0 +1,241 @@
+/*
+ * quota_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/a", S_IFDIR|0755, "")
+	tfs.create("/a/f", S_IFREG|0644, "0123456789")
+	tfs.Chown("/a/f", 1000, 1000)
+	uid := uint32(1000)
+	fs := NewQuota(tfs, QuotaOptions{
+		Users:       map[uint32]QuotaLimits{1000: {Bytes: 20, Inodes: 3}},
+		Trees:       map[string]QuotaLimits{"b": {Bytes: 15}},
+		DefaultTree: QuotaLimits{Inodes: 10},
+		Getcontext: func() (uint32, uint32, int) {
+			return uid, uid, 1
+		},
+	})
+	fs.Init()
+
+	if u := fs.UserUsage(1000); (QuotaUsage{10, 1}) != u {
+		t.Error(u)
+	}
+	if u := fs.TreeUsage("a"); (QuotaUsage{10, 2}) != u {
+		t.Error(u)
+	}
+
+	if errc := testWriteFile(fs, "/a/f", "012345678901234", 0); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := testWriteFile(fs, "/a/f", "0123456789", 15); -EDQUOT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Truncate("/a/f", 30, ^uint64(0)); -EDQUOT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Truncate("/a/f", 5, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+	if u := fs.UserUsage(1000); (QuotaUsage{5, 1}) != u {
+		t.Error(u)
+	}
+
+	// testFs does not honor the caller's user ID; new files are owned by root
+	errc, fh := fs.Create("/a/g", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Release("/a/g", fh)
+	if u := fs.UserUsage(0); (QuotaUsage{0, 2}) != u {
+		t.Error(u)
+	}
+	if errc := fs.Chown("/a/g", 1000, ^uint32(0)); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Mkdir("/a/d", 0755); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Chown("/a/d", 1000, ^uint32(0)); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Mknod("/a/h", S_IFREG|0644, 0); -EDQUOT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Symlink("target", "/a/h"); -EDQUOT != errc {
+		t.Error(errc)
+	}
+	if u := fs.UserUsage(1000); (QuotaUsage{5, 3}) != u {
+		t.Error(u)
+	}
+
+	stat := Statfs_t{}
+	if errc := fs.Statfs("/a", &stat); 0 != errc || 0 != stat.Ffree || 0 != stat.Favail {
+		t.Error(errc, stat)
+	}
+
+	uid = 0
+	if errc := fs.Mkdir("/b", 0755); 0 != errc {
+		t.Error(errc)
+	}
+	errc, fh = fs.Create("/b/x", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if n := fs.Write("/b/x", []byte(strings.Repeat("x", 16)), 0, fh); -EDQUOT != n {
+		t.Error(n)
+	}
+	if n := fs.Write("/b/x", []byte(strings.Repeat("x", 15)), 0, fh); 15 != n {
+		t.Error(n)
+	}
+	fs.Release("/b/x", fh)
+	if errc := fs.Statfs("/b/x", &stat); 0 != errc || 0 != stat.Bavail || 0 != stat.Bfree {
+		t.Error(errc, stat)
+	}
+
+	if errc := fs.Rename("/a/f", "/b/f"); -EDQUOT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Rename("/b/x", "/a/x"); 0 != errc {
+		t.Error(errc)
+	}
+	if u := fs.TreeUsage("b"); (QuotaUsage{0, 1}) != u {
+		t.Error(u)
+	}
+	if errc := fs.Rename("/a/x", "/a/f"); 0 != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Unlink("/a/g"); 0 != errc {
+		t.Error(errc)
+	}
+	if u := fs.UserUsage(1000); (QuotaUsage{0, 1}) != u {
+		t.Error(u)
+	}
+
+	users := map[uint32]QuotaUsage{0: fs.UserUsage(0), 1000: fs.UserUsage(1000)}
+	trees := map[string]QuotaUsage{"a": fs.TreeUsage("a"), "b": fs.TreeUsage("b")}
+	fs.Rescan()
+	for uid, u := range users {
+		if v := fs.UserUsage(uid); u != v {
+			t.Error(uid, u, v)
+		}
+	}
+	for tree, u := range trees {
+		if v := fs.TreeUsage(tree); u != v {
+			t.Error(tree, u, v)
+		}
+	}
+}
+
+func TestQuotaResize(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/a", S_IFDIR|0755, "")
+	tfs.create("/a/f", S_IFREG|0644, "0123456789")
+	fs := NewQuota(tfs, QuotaOptions{
+		Getcontext: func() (uint32, uint32, int) {
+			return 0, 0, 1
+		},
+	})
+	fs.Init()
+
+	errc, fh := fs.Open("/a/f", O_RDWR|O_TRUNC)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if u := fs.TreeUsage("a"); (QuotaUsage{0, 2}) != u {
+		t.Error(u)
+	}
+
+	// concurrent appending writes are charged exactly once
+	const n = 32
+	var wg sync.WaitGroup
+	for i := 0; n > i; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fs.Write("/a/f", []byte("0123456789"), int64(10*i), fh)
+		}(i)
+	}
+	wg.Wait()
+	fs.Release("/a/f", fh)
+	if u := fs.TreeUsage("a"); (QuotaUsage{10 * n, 2}) != u {
+		t.Error(u)
+	}
+	if 0 != len(fs.files) {
+		t.Error(len(fs.files))
+	}
+}
+
+func TestQuotaLink(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/a", S_IFDIR|0755, "")
+	tfs.create("/b", S_IFDIR|0755, "")
+	tfs.create("/a/f", S_IFREG|0644, "0123456789")
+	fs := NewQuota(tfs, QuotaOptions{
+		Getcontext: func() (uint32, uint32, int) {
+			return 0, 0, 1
+		},
+	})
+	fs.Init()
+
+	if errc := fs.Link("/a/f", "/b/f"); -EXDEV != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Link("/a/f", "/a/g"); 0 != errc {
+		t.Error(errc)
+	}
+	if u := fs.TreeUsage("a"); (QuotaUsage{10, 2}) != u {
+		t.Error(u)
+	}
+	if errc := fs.Rename("/a/g", "/b/g"); -EXDEV != errc {
+		t.Error(errc)
+	}
+
+	// a tree that contains all links to a file may move
+	if errc := fs.Rename("/a", "/c"); 0 != errc {
+		t.Error(errc)
+	}
+	if u := fs.TreeUsage("c"); (QuotaUsage{10, 2}) != u {
+		t.Error(u)
+	}
+	fs.Unlink("/c/f")
+	if u := fs.TreeUsage("c"); (QuotaUsage{10, 2}) != u {
+		t.Error(u)
+	}
+	fs.Unlink("/c/g")
+	if u := fs.TreeUsage("c"); (QuotaUsage{0, 1}) != u {
+		t.Error(u)
+	}
+
+	// links across trees that predate the Quota are credited where they were charged
+	tfs.create("/c/h", S_IFREG|0644, "01234")
+	tfs.Link("/c/h", "/b/h")
+	fs.Rescan()
+	if u := fs.TreeUsage("b"); (QuotaUsage{5, 2}) != u {
+		t.Error(u)
+	}
+	fs.Unlink("/b/h")
+	fs.Unlink("/c/h")
+	if u := fs.TreeUsage("b"); (QuotaUsage{0, 1}) != u {
+		t.Error(u)
+	}
+	if u := fs.TreeUsage("c"); (QuotaUsage{0, 1}) != u {
+		t.Error(u)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * readonly.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+type readOnlyFs struct {
+	FileSystemInterface
+}
+
+// ReadOnly returns a file system that forwards read operations to fs and fails all
+// operations that would modify fs with -EROFS. Getattr reports files without write
+// permissions. Files may only be opened for reading.
+//
+// The returned file system implements FileSystemOpenEx, FileSystemOpendirEx,
+// FileSystemReleaseEx, FileSystemGetpath, FileSystemChflags, FileSystemSetcrtime and
+// FileSystemSetchgtime.
+func ReadOnly(fs FileSystemInterface) FileSystemInterface {
+	return &readOnlyFs{fs}
+}
+
+func readOnlyFlags(flags int) bool {
+	return O_RDONLY == flags&O_ACCMODE && 0 == flags&(O_CREAT|O_TRUNC|O_APPEND)
+}
+
+func (fs *readOnlyFs) Mknod(path string, mode uint32, dev uint64) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Mkdir(path string, mode uint32) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Unlink(path string) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Rmdir(path string) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Link(oldpath string, newpath string) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Symlink(target string, newpath string) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Rename(oldpath string, newpath string) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Chmod(path string, mode uint32) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Chown(path string, uid uint32, gid uint32) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Utimens(path string, tmsp []Timespec) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Access(path string, mask uint32) int {
+	if 0 != mask&W_OK {
+		return -EROFS
+	}
+	return fs.FileSystemInterface.Access(path, mask)
+}
+
+func (fs *readOnlyFs) Create(path string, flags int, mode uint32) (int, uint64) {
+	return -EROFS, ^uint64(0)
+}
+
+func (fs *readOnlyFs) Open(path string, flags int) (int, uint64) {
+	if !readOnlyFlags(flags) {
+		return -EROFS, ^uint64(0)
+	}
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
+func (fs *readOnlyFs) Getattr(path string, stat *Stat_t, fh uint64) int {
+	errc := fs.FileSystemInterface.Getattr(path, stat, fh)
+	if 0 == errc {
+		stat.Mode &^= 0222
+	}
+	return errc
+}
+
+func (fs *readOnlyFs) Truncate(path string, size int64, fh uint64) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Setxattr(path string, name string, value []byte, flags int) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Removexattr(path string, name string) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) CreateEx(path string, mode uint32, fi *FileInfo_t) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) OpenEx(path string, fi *FileInfo_t) int {
+	if !readOnlyFlags(fi.Flags) {
+		return -EROFS
+	}
+	return fsOpenEx(fs.FileSystemInterface, path, fi)
+}
+
+func (fs *readOnlyFs) OpendirEx(path string, fi *FileInfo_t) int {
+	return fsOpendirEx(fs.FileSystemInterface, path, fi)
+}
+
+func (fs *readOnlyFs) FlushEx(path string, fi *FileInfo_t) int {
+	return fsFlushEx(fs.FileSystemInterface, path, fi)
+}
+
+func (fs *readOnlyFs) ReleaseEx(path string, fi *FileInfo_t) int {
+	return fsReleaseEx(fs.FileSystemInterface, path, fi)
+}
+
+func (fs *readOnlyFs) Getpath(path string, fh uint64) (int, string) {
+	return fsGetpath(fs.FileSystemInterface, path, fh)
+}
+
+func (fs *readOnlyFs) Chflags(path string, flags uint32) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Setcrtime(path string, tmsp Timespec) int {
+	return -EROFS
+}
+
+func (fs *readOnlyFs) Setchgtime(path string, tmsp Timespec) int {
+	return -EROFS
+}
+
+var (
+	_ FileSystemOpenEx     = (*readOnlyFs)(nil)
+	_ FileSystemOpendirEx  = (*readOnlyFs)(nil)
+	_ FileSystemReleaseEx  = (*readOnlyFs)(nil)
+	_ FileSystemGetpath    = (*readOnlyFs)(nil)
+	_ FileSystemChflags    = (*readOnlyFs)(nil)
+	_ FileSystemSetcrtime  = (*readOnlyFs)(nil)
+	_ FileSystemSetchgtime = (*readOnlyFs)(nil)
+)
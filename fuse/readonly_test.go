@@ -0,0 +1,86 @@
+/*
+ * readonly_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	tfs.create("/dir/file", S_IFREG|0644, "hello")
+	fs := ReadOnly(tfs)
+
+	stat := Stat_t{}
+	if errc := fs.Getattr("/dir/file", &stat, ^uint64(0)); 0 != errc || S_IFREG|0444 != stat.Mode {
+		t.Errorf("%d %o", errc, stat.Mode)
+	}
+	if errc, data := testReadFile(fs, "/dir/file"); 0 != errc || "hello" != data {
+		t.Error(errc, data)
+	}
+	if errc, names := testReaddir(fs, "/dir"); 0 != errc || 1 != len(names) {
+		t.Error(errc, names)
+	}
+	if 0 != fs.Access("/dir/file", R_OK) || -EROFS != fs.Access("/dir/file", W_OK) {
+		t.Error()
+	}
+
+	errcs := []int{
+		fs.Mknod("/x", S_IFREG|0644, 0),
+		fs.Mkdir("/x", 0755),
+		fs.Unlink("/dir/file"),
+		fs.Rmdir("/dir"),
+		fs.Link("/dir/file", "/x"),
+		fs.Symlink("/dir/file", "/x"),
+		fs.Rename("/dir/file", "/x"),
+		fs.Chmod("/dir/file", 0777),
+		fs.Chown("/dir/file", 0, 0),
+		fs.Utimens("/dir/file", []Timespec{{}, {}}),
+		fs.Truncate("/dir/file", 0, ^uint64(0)),
+		fs.Write("/dir/file", []byte("x"), 0, 0),
+		fs.Setxattr("/dir/file", "user.x", []byte("x"), 0),
+		fs.Removexattr("/dir/file", "user.x"),
+		fs.(FileSystemChflags).Chflags("/dir/file", 0),
+		fs.(FileSystemSetcrtime).Setcrtime("/dir/file", Timespec{}),
+		fs.(FileSystemSetchgtime).Setchgtime("/dir/file", Timespec{}),
+		fs.(FileSystemOpenEx).CreateEx("/x", 0644, &FileInfo_t{}),
+	}
+	for i, errc := range errcs {
+		if -EROFS != errc {
+			t.Error(i, errc)
+		}
+	}
+	if errc, _ := fs.Create("/x", O_RDWR, 0644); -EROFS != errc {
+		t.Error(errc)
+	}
+	for _, flags := range []int{O_WRONLY, O_RDWR, O_RDONLY | O_TRUNC, O_RDONLY | O_APPEND} {
+		if errc, _ := fs.Open("/dir/file", flags); -EROFS != errc {
+			t.Error(flags, errc)
+		}
+		if errc := fs.(FileSystemOpenEx).OpenEx("/dir/file", &FileInfo_t{Flags: flags}); -EROFS != errc {
+			t.Error(flags, errc)
+		}
+	}
+	fi := FileInfo_t{Flags: O_RDONLY}
+	if errc := fs.(FileSystemOpenEx).OpenEx("/dir/file", &fi); 0 != errc || 0 == fi.Fh {
+		t.Error(errc, fi.Fh)
+	}
+
+	for _, op := range []string{"Mknod", "Mkdir", "Unlink", "Rmdir", "Rename", "Chmod", "Chown",
+		"Utimens", "Truncate", "Write", "Setxattr", "Removexattr", "Create"} {
+		if 0 != tfs.count(op) {
+			t.Error(op)
+		}
+	}
+}
@@ -0,0 +1,640 @@
+/*
+ * recorder.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OpRecord is the record of a file system operation written by a Recorder.
+type OpRecord struct {
+	// Sequence number of the record.
+	Seq uint64
+
+	// Operation name (e.g. "Read", "Getattr").
+	Op string
+
+	// Operation arguments. Path2 is the new path of Link and Rename and the target of
+	// Symlink. Data is the data of Write and the value of Setxattr. Size is the buffer
+	// size of Read, the size of Truncate and the number of entries that the fill
+	// function of Readdir accepted; Flags is 1 if the fill function rejected an entry.
+	// Tmsp contains the times of Utimens, Setcrtime and Setchgtime.
+	Path  string
+	Path2 string
+	Name  string
+	Flags int
+	Mode  uint32
+	Uid   uint32
+	Gid   uint32
+	Dev   uint64
+	Ofst  int64
+	Size  int64
+	Fh    uint64
+	Tmsp  []Timespec
+	Data  []byte
+
+	// Operation results. Errc is the error code or the number of bytes transferred by
+	// Read and Write. Digest is a digest of the data returned by Read, Readlink,
+	// Readdir, Getxattr and Listxattr. Stat contains the attributes returned by Getattr.
+	Errc   int
+	RetFh  uint64
+	Digest []byte
+	Stat   *Stat_t
+
+	// Start time of the operation relative to the creation of the Recorder and its
+	// duration.
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// Recorder is a file system that records the operations performed on another file
+// system with their arguments, results and timing. The records are written to an
+// io.Writer in a compact binary format and can be replayed against a file system
+// with Replay.
+//
+// Records are written when operations complete; concurrent operations are recorded in
+// the order in which they complete.
+type Recorder struct {
+	FileSystemInterface
+	lock  sync.Mutex
+	enc   *gob.Encoder
+	epoch time.Time
+	seq   uint64
+	err   error
+}
+
+// NewRecorder creates a Recorder for the file system fs that writes records to w.
+func NewRecorder(fs FileSystemInterface, w io.Writer) *Recorder {
+	return &Recorder{
+		FileSystemInterface: fs,
+		enc:                 gob.NewEncoder(w),
+		epoch:               time.Now(),
+	}
+}
+
+// Err returns the first error encountered while writing records, if any.
+func (rec *Recorder) Err() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.err
+}
+
+func recordDigest(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:16]
+}
+
+func recordNamesDigest(names []string) []byte {
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)[:16]
+}
+
+// write completes the record r of an operation that started at start and writes it.
+func (rec *Recorder) write(r *OpRecord, start time.Time) {
+	r.Duration = time.Since(start)
+	r.Start = start.Sub(rec.epoch)
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.seq++
+	r.Seq = rec.seq
+	if err := rec.enc.Encode(r); nil != err && nil == rec.err {
+		rec.err = err
+	}
+}
+
+func (rec *Recorder) Init() {
+	start := time.Now()
+	rec.FileSystemInterface.Init()
+	rec.write(&OpRecord{Op: "Init"}, start)
+}
+
+func (rec *Recorder) Destroy() {
+	start := time.Now()
+	rec.FileSystemInterface.Destroy()
+	rec.write(&OpRecord{Op: "Destroy"}, start)
+}
+
+func (rec *Recorder) Statfs(path string, stat *Statfs_t) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Statfs(path, stat)
+	rec.write(&OpRecord{Op: "Statfs", Path: path, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Mknod(path string, mode uint32, dev uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Mknod(path, mode, dev)
+	rec.write(&OpRecord{Op: "Mknod", Path: path, Mode: mode, Dev: dev, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Mkdir(path string, mode uint32) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Mkdir(path, mode)
+	rec.write(&OpRecord{Op: "Mkdir", Path: path, Mode: mode, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Unlink(path string) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Unlink(path)
+	rec.write(&OpRecord{Op: "Unlink", Path: path, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Rmdir(path string) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Rmdir(path)
+	rec.write(&OpRecord{Op: "Rmdir", Path: path, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Link(oldpath string, newpath string) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Link(oldpath, newpath)
+	rec.write(&OpRecord{Op: "Link", Path: oldpath, Path2: newpath, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Symlink(target string, newpath string) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Symlink(target, newpath)
+	rec.write(&OpRecord{Op: "Symlink", Path: newpath, Path2: target, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Readlink(path string) (int, string) {
+	start := time.Now()
+	errc, target := rec.FileSystemInterface.Readlink(path)
+	rec.write(&OpRecord{Op: "Readlink", Path: path, Errc: errc,
+		Digest: recordDigest([]byte(target))}, start)
+	return errc, target
+}
+
+func (rec *Recorder) Rename(oldpath string, newpath string) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Rename(oldpath, newpath)
+	rec.write(&OpRecord{Op: "Rename", Path: oldpath, Path2: newpath, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Chmod(path string, mode uint32) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Chmod(path, mode)
+	rec.write(&OpRecord{Op: "Chmod", Path: path, Mode: mode, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Chown(path string, uid uint32, gid uint32) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Chown(path, uid, gid)
+	rec.write(&OpRecord{Op: "Chown", Path: path, Uid: uid, Gid: gid, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Utimens(path string, tmsp []Timespec) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Utimens(path, tmsp)
+	rec.write(&OpRecord{Op: "Utimens", Path: path, Tmsp: append([]Timespec(nil), tmsp...),
+		Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Access(path string, mask uint32) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Access(path, mask)
+	rec.write(&OpRecord{Op: "Access", Path: path, Mode: mask, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Create(path string, flags int, mode uint32) (int, uint64) {
+	start := time.Now()
+	errc, fh := rec.FileSystemInterface.Create(path, flags, mode)
+	rec.write(&OpRecord{Op: "Create", Path: path, Flags: flags, Mode: mode,
+		Errc: errc, RetFh: fh}, start)
+	return errc, fh
+}
+
+func (rec *Recorder) Open(path string, flags int) (int, uint64) {
+	start := time.Now()
+	errc, fh := rec.FileSystemInterface.Open(path, flags)
+	rec.write(&OpRecord{Op: "Open", Path: path, Flags: flags, Errc: errc, RetFh: fh}, start)
+	return errc, fh
+}
+
+func (rec *Recorder) Getattr(path string, stat *Stat_t, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Getattr(path, stat, fh)
+	r := &OpRecord{Op: "Getattr", Path: path, Fh: fh, Errc: errc}
+	if 0 == errc {
+		r.Stat = &Stat_t{}
+		*r.Stat = *stat
+	}
+	rec.write(r, start)
+	return errc
+}
+
+func (rec *Recorder) Truncate(path string, size int64, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Truncate(path, size, fh)
+	rec.write(&OpRecord{Op: "Truncate", Path: path, Size: size, Fh: fh, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	start := time.Now()
+	n := rec.FileSystemInterface.Read(path, buff, ofst, fh)
+	r := &OpRecord{Op: "Read", Path: path, Ofst: ofst, Size: int64(len(buff)), Fh: fh, Errc: n}
+	if 0 <= n && n <= len(buff) {
+		r.Digest = recordDigest(buff[:n])
+	}
+	rec.write(r, start)
+	return n
+}
+
+func (rec *Recorder) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	start := time.Now()
+	n := rec.FileSystemInterface.Write(path, buff, ofst, fh)
+	rec.write(&OpRecord{Op: "Write", Path: path, Ofst: ofst, Fh: fh,
+		Data: append([]byte{}, buff...), Errc: n}, start)
+	return n
+}
+
+func (rec *Recorder) Flush(path string, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Flush(path, fh)
+	rec.write(&OpRecord{Op: "Flush", Path: path, Fh: fh, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Release(path string, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Release(path, fh)
+	rec.write(&OpRecord{Op: "Release", Path: path, Fh: fh, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Fsync(path string, datasync bool, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Fsync(path, datasync, fh)
+	r := &OpRecord{Op: "Fsync", Path: path, Fh: fh, Errc: errc}
+	if datasync {
+		r.Flags = 1
+	}
+	rec.write(r, start)
+	return errc
+}
+
+func (rec *Recorder) Opendir(path string) (int, uint64) {
+	start := time.Now()
+	errc, fh := rec.FileSystemInterface.Opendir(path)
+	rec.write(&OpRecord{Op: "Opendir", Path: path, Errc: errc, RetFh: fh}, start)
+	return errc, fh
+}
+
+func (rec *Recorder) Readdir(path string,
+	fill func(name string, stat *Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) int {
+	start := time.Now()
+	names := []string{}
+	full := 0
+	errc := rec.FileSystemInterface.Readdir(path,
+		func(name string, stat *Stat_t, ofst int64) bool {
+			if !fill(name, stat, ofst) {
+				full = 1
+				return false
+			}
+			names = append(names, name)
+			return true
+		},
+		ofst,
+		fh)
+	rec.write(&OpRecord{Op: "Readdir", Path: path, Flags: full, Ofst: ofst,
+		Size: int64(len(names)), Fh: fh, Errc: errc, Digest: recordNamesDigest(names)}, start)
+	return errc
+}
+
+func (rec *Recorder) Releasedir(path string, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Releasedir(path, fh)
+	rec.write(&OpRecord{Op: "Releasedir", Path: path, Fh: fh, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Fsyncdir(path string, datasync bool, fh uint64) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Fsyncdir(path, datasync, fh)
+	r := &OpRecord{Op: "Fsyncdir", Path: path, Fh: fh, Errc: errc}
+	if datasync {
+		r.Flags = 1
+	}
+	rec.write(r, start)
+	return errc
+}
+
+func (rec *Recorder) Setxattr(path string, name string, value []byte, flags int) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Setxattr(path, name, value, flags)
+	rec.write(&OpRecord{Op: "Setxattr", Path: path, Name: name, Flags: flags,
+		Data: append([]byte{}, value...), Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Getxattr(path string, name string) (int, []byte) {
+	start := time.Now()
+	errc, value := rec.FileSystemInterface.Getxattr(path, name)
+	rec.write(&OpRecord{Op: "Getxattr", Path: path, Name: name, Errc: errc,
+		Digest: recordDigest(value)}, start)
+	return errc, value
+}
+
+func (rec *Recorder) Removexattr(path string, name string) int {
+	start := time.Now()
+	errc := rec.FileSystemInterface.Removexattr(path, name)
+	rec.write(&OpRecord{Op: "Removexattr", Path: path, Name: name, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Listxattr(path string, fill func(name string) bool) int {
+	start := time.Now()
+	names := []string{}
+	errc := rec.FileSystemInterface.Listxattr(path, func(name string) bool {
+		names = append(names, name)
+		return fill(name)
+	})
+	rec.write(&OpRecord{Op: "Listxattr", Path: path, Errc: errc,
+		Digest: recordNamesDigest(names)}, start)
+	return errc
+}
+
+func (rec *Recorder) Chflags(path string, flags uint32) int {
+	start := time.Now()
+	errc := fsChflags(rec.FileSystemInterface, path, flags)
+	rec.write(&OpRecord{Op: "Chflags", Path: path, Flags: int(flags), Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Setcrtime(path string, tmsp Timespec) int {
+	start := time.Now()
+	errc := fsSetcrtime(rec.FileSystemInterface, path, tmsp)
+	rec.write(&OpRecord{Op: "Setcrtime", Path: path, Tmsp: []Timespec{tmsp}, Errc: errc}, start)
+	return errc
+}
+
+func (rec *Recorder) Setchgtime(path string, tmsp Timespec) int {
+	start := time.Now()
+	errc := fsSetchgtime(rec.FileSystemInterface, path, tmsp)
+	rec.write(&OpRecord{Op: "Setchgtime", Path: path, Tmsp: []Timespec{tmsp}, Errc: errc}, start)
+	return errc
+}
+
+// ReplayOptions contains options for Replay.
+type ReplayOptions struct {
+	// If true the recorded intervals between operations are reproduced.
+	Timing bool
+
+	// If true attributes returned by Getattr are compared in addition to error codes
+	// and data digests. Only the file type, permissions, size and link count are
+	// compared.
+	CompareStat bool
+}
+
+// ReplayDiff describes a difference between a recorded and a replayed operation.
+type ReplayDiff struct {
+	Seq      uint64
+	Op       string
+	Path     string
+	Field    string
+	Recorded string
+	Replayed string
+}
+
+func (diff ReplayDiff) String() string {
+	return "#" + strconv.FormatUint(diff.Seq, 10) + " " + diff.Op + " " + diff.Path + ": " +
+		diff.Field + ": recorded " + diff.Recorded + ", replayed " + diff.Replayed
+}
+
+type replayer struct {
+	fs      FileSystemInterface
+	opts    ReplayOptions
+	handles map[uint64]uint64
+	diffs   []ReplayDiff
+}
+
+func replayErrc(errc int) string {
+	if 0 <= errc {
+		return strconv.Itoa(errc)
+	}
+	return Error(errc).Error()
+}
+
+func replayDigest(digest []byte) string {
+	const hex = "0123456789abcdef"
+	s := make([]byte, 0, 2*len(digest))
+	for _, b := range digest {
+		s = append(s, hex[b>>4], hex[b&15])
+	}
+	return string(s)
+}
+
+func (rp *replayer) diff(r *OpRecord, field string, recorded string, replayed string) {
+	if recorded != replayed {
+		rp.diffs = append(rp.diffs, ReplayDiff{r.Seq, r.Op, r.Path, field, recorded, replayed})
+	}
+}
+
+// fh maps a recorded file handle to a replayed one.
+func (rp *replayer) fh(fh uint64) uint64 {
+	if rfh, ok := rp.handles[fh]; ok {
+		return rfh
+	}
+	return fh
+}
+
+func (rp *replayer) replay(r *OpRecord) {
+	fs := rp.fs
+	errc := 0
+	var digest []byte
+	switch r.Op {
+	case "Init":
+		fs.Init()
+	case "Destroy":
+		fs.Destroy()
+	case "Statfs":
+		errc = fs.Statfs(r.Path, &Statfs_t{})
+	case "Mknod":
+		errc = fs.Mknod(r.Path, r.Mode, r.Dev)
+	case "Mkdir":
+		errc = fs.Mkdir(r.Path, r.Mode)
+	case "Unlink":
+		errc = fs.Unlink(r.Path)
+	case "Rmdir":
+		errc = fs.Rmdir(r.Path)
+	case "Link":
+		errc = fs.Link(r.Path, r.Path2)
+	case "Symlink":
+		errc = fs.Symlink(r.Path2, r.Path)
+	case "Readlink":
+		var target string
+		errc, target = fs.Readlink(r.Path)
+		digest = recordDigest([]byte(target))
+	case "Rename":
+		errc = fs.Rename(r.Path, r.Path2)
+	case "Chmod":
+		errc = fs.Chmod(r.Path, r.Mode)
+	case "Chown":
+		errc = fs.Chown(r.Path, r.Uid, r.Gid)
+	case "Utimens":
+		errc = fs.Utimens(r.Path, r.Tmsp)
+	case "Access":
+		errc = fs.Access(r.Path, r.Mode)
+	case "Chflags":
+		errc = fsChflags(fs, r.Path, uint32(r.Flags))
+	case "Setcrtime", "Setchgtime":
+		tmsp := Timespec{}
+		if 0 < len(r.Tmsp) {
+			tmsp = r.Tmsp[0]
+		}
+		if "Setcrtime" == r.Op {
+			errc = fsSetcrtime(fs, r.Path, tmsp)
+		} else {
+			errc = fsSetchgtime(fs, r.Path, tmsp)
+		}
+	case "Create", "Open", "Opendir":
+		var fh uint64
+		switch r.Op {
+		case "Create":
+			errc, fh = fs.Create(r.Path, r.Flags, r.Mode)
+		case "Open":
+			errc, fh = fs.Open(r.Path, r.Flags)
+		case "Opendir":
+			errc, fh = fs.Opendir(r.Path)
+		}
+		if 0 == errc && 0 == r.Errc {
+			rp.handles[r.RetFh] = fh
+		}
+	case "Getattr":
+		stat := Stat_t{}
+		errc = fs.Getattr(r.Path, &stat, rp.fh(r.Fh))
+		if rp.opts.CompareStat && 0 == errc && nil != r.Stat {
+			rp.diff(r, "mode", strconv.FormatUint(uint64(r.Stat.Mode), 8),
+				strconv.FormatUint(uint64(stat.Mode), 8))
+			rp.diff(r, "size", strconv.FormatInt(r.Stat.Size, 10),
+				strconv.FormatInt(stat.Size, 10))
+			rp.diff(r, "nlink", strconv.FormatUint(uint64(r.Stat.Nlink), 10),
+				strconv.FormatUint(uint64(stat.Nlink), 10))
+		}
+	case "Truncate":
+		errc = fs.Truncate(r.Path, r.Size, rp.fh(r.Fh))
+	case "Read":
+		buff := make([]byte, r.Size)
+		errc = fs.Read(r.Path, buff, r.Ofst, rp.fh(r.Fh))
+		if 0 <= errc && errc <= len(buff) {
+			digest = recordDigest(buff[:errc])
+		}
+	case "Write":
+		errc = fs.Write(r.Path, r.Data, r.Ofst, rp.fh(r.Fh))
+	case "Flush":
+		errc = fs.Flush(r.Path, rp.fh(r.Fh))
+	case "Release", "Releasedir":
+		if "Release" == r.Op {
+			errc = fs.Release(r.Path, rp.fh(r.Fh))
+		} else {
+			errc = fs.Releasedir(r.Path, rp.fh(r.Fh))
+		}
+		delete(rp.handles, r.Fh)
+	case "Fsync":
+		errc = fs.Fsync(r.Path, 0 != r.Flags, rp.fh(r.Fh))
+	case "Fsyncdir":
+		errc = fs.Fsyncdir(r.Path, 0 != r.Flags, rp.fh(r.Fh))
+	case "Readdir":
+		names := []string{}
+		errc = fs.Readdir(r.Path, func(name string, stat *Stat_t, ofst int64) bool {
+			// stop where the recorded fill function stopped accepting entries
+			if 0 != r.Flags && int64(len(names)) >= r.Size {
+				return false
+			}
+			names = append(names, name)
+			return true
+		}, r.Ofst, rp.fh(r.Fh))
+		digest = recordNamesDigest(names)
+	case "Setxattr":
+		errc = fs.Setxattr(r.Path, r.Name, r.Data, r.Flags)
+	case "Getxattr":
+		var value []byte
+		errc, value = fs.Getxattr(r.Path, r.Name)
+		digest = recordDigest(value)
+	case "Removexattr":
+		errc = fs.Removexattr(r.Path, r.Name)
+	case "Listxattr":
+		names := []string{}
+		errc = fs.Listxattr(r.Path, func(name string) bool {
+			names = append(names, name)
+			return true
+		})
+		digest = recordNamesDigest(names)
+	default:
+		rp.diff(r, "op", r.Op, "unknown")
+		return
+	}
+	rp.diff(r, "errc", replayErrc(r.Errc), replayErrc(errc))
+	if nil != r.Digest && nil != digest && 0 <= r.Errc && r.Errc == errc {
+		rp.diff(r, "data", replayDigest(r.Digest), replayDigest(digest))
+	}
+}
+
+// Replay reads the operations recorded by a Recorder from r and performs them on the
+// file system fs in the order in which they were recorded. File handles returned by
+// fs are substituted for the recorded ones. Replay returns the differences between
+// the recorded and replayed results: error codes, byte counts, digests of returned
+// data and optionally file attributes.
+func Replay(fs FileSystemInterface, r io.Reader, opts ReplayOptions) ([]ReplayDiff, error) {
+	rp := &replayer{fs: fs, opts: opts, handles: map[uint64]uint64{}, diffs: []ReplayDiff{}}
+	dec := gob.NewDecoder(r)
+	epoch := time.Now()
+	for {
+		rec := OpRecord{}
+		if err := dec.Decode(&rec); nil != err {
+			if io.EOF == err {
+				break
+			}
+			return rp.diffs, err
+		}
+		if opts.Timing {
+			if d := rec.Start - time.Since(epoch); 0 < d {
+				time.Sleep(d)
+			}
+		}
+		rp.replay(&rec)
+	}
+	return rp.diffs, nil
+}
+
+var (
+	_ FileSystemInterface  = (*Recorder)(nil)
+	_ FileSystemChflags    = (*Recorder)(nil)
+	_ FileSystemSetcrtime  = (*Recorder)(nil)
+	_ FileSystemSetchgtime = (*Recorder)(nil)
+)
@@ -0,0 +1,148 @@
+/*
+ * recorder_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func testRecorderFs(data string) *testFs {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	tfs.create("/dir/file", S_IFREG|0644, data)
+	return tfs
+}
+
+func testRecorderOps(fs FileSystemInterface) {
+	fs.Init()
+	testReaddir(fs, "/dir")
+	testReadFile(fs, "/dir/file")
+	errc, fh := fs.Create("/dir/new", O_RDWR, 0644)
+	if 0 == errc {
+		fs.Write("/dir/new", []byte("hello"), 0, fh)
+		stat := Stat_t{}
+		fs.Getattr("/dir/new", &stat, fh)
+		fs.Flush("/dir/new", fh)
+		fs.Release("/dir/new", fh)
+	}
+	fs.Setxattr("/dir/new", "user.x", []byte("x"), 0)
+	fs.Getxattr("/dir/new", "user.x")
+	fs.Symlink("new", "/dir/link")
+	fs.Readlink("/dir/link")
+	fs.Rename("/dir/new", "/dir/renamed")
+	testReadFile(fs, "/dir/renamed")
+	fsChflags(fs, "/dir/renamed", 1)
+	fsSetcrtime(fs, "/dir/renamed", Timespec{Sec: 1})
+	fsSetchgtime(fs, "/dir/renamed", Timespec{Sec: 2})
+	fs.Unlink("/dir/nonexistent")
+	fs.Destroy()
+}
+
+func TestRecorder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	fs := NewRecorder(testRecorderFs("hello"), buf)
+	testRecorderOps(fs)
+	if nil != fs.Err() {
+		t.Fatal(fs.Err())
+	}
+	data := buf.Bytes()
+
+	recs := map[string]OpRecord{}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	for {
+		r := OpRecord{}
+		if nil != dec.Decode(&r) {
+			break
+		}
+		recs[r.Op] = r
+	}
+	if r := recs["Chflags"]; "/dir/renamed" != r.Path || 1 != r.Flags {
+		t.Error(r)
+	}
+	if r := recs["Setcrtime"]; 1 != len(r.Tmsp) || 1 != r.Tmsp[0].Sec {
+		t.Error(r)
+	}
+	if r := recs["Setchgtime"]; 1 != len(r.Tmsp) || 2 != r.Tmsp[0].Sec {
+		t.Error(r)
+	}
+
+	diffs, err := Replay(testRecorderFs("hello"), bytes.NewReader(data),
+		ReplayOptions{CompareStat: true})
+	if nil != err || 0 != len(diffs) {
+		t.Error(err, diffs)
+	}
+
+	tfs := testRecorderFs("world")
+	tfs.create("/dir/link", S_IFREG|0644, "")
+	diffs, err = Replay(tfs, bytes.NewReader(data), ReplayOptions{})
+	if nil != err {
+		t.Error(err)
+	}
+	expect := []string{
+		"Readdir /dir data",
+		"Read /dir/file data",
+		"Symlink /dir/link errc",
+		"Readlink /dir/link errc",
+	}
+	if len(expect) != len(diffs) {
+		t.Fatal(diffs)
+	}
+	for i, diff := range diffs {
+		if expect[i] != diff.Op+" "+diff.Path+" "+diff.Field {
+			t.Error(i, diff)
+		}
+	}
+	if "errc" == diffs[2].Field && ("0" != diffs[2].Recorded || "-fuse.EEXIST" != diffs[2].Replayed) {
+		t.Error(diffs[2])
+	}
+
+	if _, err := Replay(tfs, bytes.NewReader(data[:len(data)/2]), ReplayOptions{}); nil == err {
+		t.Error()
+	}
+}
+
+func TestRecorderReaddirPartial(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tfs := testRecorderFs("hello")
+	tfs.create("/dir/other", S_IFREG|0644, "")
+	fs := NewRecorder(tfs, buf)
+	names := []string{}
+	fs.Readdir("/dir", func(name string, stat *Stat_t, ofst int64) bool {
+		if 3 == len(names) {
+			// the kernel buffer is full
+			return false
+		}
+		names = append(names, name)
+		return true
+	}, 0, 0)
+	if 3 != len(names) {
+		t.Fatal(names)
+	}
+
+	r := OpRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&r); nil != err ||
+		3 != r.Size || 1 != r.Flags {
+		t.Error(err, r)
+	}
+
+	// the replayed listing stops where the recorded one did
+	tfs = testRecorderFs("hello")
+	tfs.create("/dir/other", S_IFREG|0644, "")
+	tfs.create("/dir/zzz", S_IFREG|0644, "")
+	diffs, err := Replay(tfs, bytes.NewReader(buf.Bytes()), ReplayOptions{})
+	if nil != err || 0 != len(diffs) {
+		t.Error(err, diffs)
+	}
+}
@@ -0,0 +1,509 @@
+/*
+ * testfs_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	pathutil "path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type testNode struct {
+	stat Stat_t
+	data []byte
+	link string
+	xatr map[string][]byte
+}
+
+// testFs is a simple in-memory file system used to test file system wrappers. Files
+// are keyed by path; file handles are inode numbers. It counts calls by operation.
+type testFs struct {
+	FileSystemBase
+	lock  sync.Mutex
+	nodes map[string]*testNode
+	inos  map[uint64]*testNode
+	ino   uint64
+	calls map[string]int
+}
+
+func newTestFs() *testFs {
+	fs := &testFs{
+		nodes: map[string]*testNode{},
+		inos:  map[uint64]*testNode{},
+		calls: map[string]int{},
+	}
+	fs.newNode("/", S_IFDIR|0755)
+	return fs
+}
+
+func (fs *testFs) newNode(path string, mode uint32) *testNode {
+	fs.ino++
+	node := &testNode{stat: Stat_t{Ino: fs.ino, Mode: mode, Nlink: 1}, xatr: map[string][]byte{}}
+	fs.nodes[path] = node
+	fs.inos[fs.ino] = node
+	return node
+}
+
+func (fs *testFs) enter(op string) func() {
+	fs.lock.Lock()
+	fs.calls[op]++
+	return fs.lock.Unlock
+}
+
+// count returns the number of calls to op.
+func (fs *testFs) count(op string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.calls[op]
+}
+
+// create adds a file with the specified path, mode and contents.
+func (fs *testFs) create(path string, mode uint32, data string) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	node := fs.newNode(path, mode)
+	node.data = []byte(data)
+	node.stat.Size = int64(len(data))
+}
+
+// lookup returns the node for path and checks that its parent is a directory.
+func (fs *testFs) lookup(path string) (int, *testNode) {
+	if "/" != path {
+		prnt := fs.nodes[pathutil.Dir(path)]
+		if nil == prnt {
+			return -ENOENT, nil
+		}
+		if S_IFDIR != prnt.stat.Mode&S_IFMT {
+			return -ENOTDIR, nil
+		}
+	}
+	node := fs.nodes[path]
+	if nil == node {
+		return -ENOENT, nil
+	}
+	return 0, node
+}
+
+func (fs *testFs) make(path string, mode uint32) (int, *testNode) {
+	if errc, _ := fs.lookup(pathutil.Dir(path)); 0 != errc {
+		return errc, nil
+	}
+	if nil != fs.nodes[path] {
+		return -EEXIST, nil
+	}
+	return 0, fs.newNode(path, mode)
+}
+
+func (fs *testFs) children(path string) []string {
+	names := []string{}
+	for p := range fs.nodes {
+		if "/" != p && path == pathutil.Dir(p) {
+			names = append(names, pathutil.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fs *testFs) Statfs(path string, stat *Statfs_t) int {
+	defer fs.enter("Statfs")()
+	*stat = Statfs_t{Bsize: 4096, Frsize: 4096, Blocks: 1000, Bfree: 500, Bavail: 500,
+		Files: 100, Ffree: 100 - uint64(len(fs.nodes)), Namemax: 255}
+	return 0
+}
+
+func (fs *testFs) Mknod(path string, mode uint32, dev uint64) int {
+	defer fs.enter("Mknod")()
+	errc, _ := fs.make(path, mode)
+	return errc
+}
+
+func (fs *testFs) Mkdir(path string, mode uint32) int {
+	defer fs.enter("Mkdir")()
+	errc, _ := fs.make(path, S_IFDIR|mode&07777)
+	return errc
+}
+
+func (fs *testFs) Unlink(path string) int {
+	defer fs.enter("Unlink")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	if S_IFDIR == node.stat.Mode&S_IFMT {
+		return -EISDIR
+	}
+	node.stat.Nlink--
+	delete(fs.nodes, path)
+	return 0
+}
+
+func (fs *testFs) Rmdir(path string) int {
+	defer fs.enter("Rmdir")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	if S_IFDIR != node.stat.Mode&S_IFMT {
+		return -ENOTDIR
+	}
+	if 0 != len(fs.children(path)) {
+		return -ENOTEMPTY
+	}
+	delete(fs.nodes, path)
+	return 0
+}
+
+func (fs *testFs) Link(oldpath string, newpath string) int {
+	defer fs.enter("Link")()
+	errc, node := fs.lookup(oldpath)
+	if 0 != errc {
+		return errc
+	}
+	if S_IFDIR == node.stat.Mode&S_IFMT {
+		return -EPERM
+	}
+	if errc, _ := fs.lookup(pathutil.Dir(newpath)); 0 != errc {
+		return errc
+	}
+	if nil != fs.nodes[newpath] {
+		return -EEXIST
+	}
+	node.stat.Nlink++
+	fs.nodes[newpath] = node
+	return 0
+}
+
+func (fs *testFs) Symlink(target string, newpath string) int {
+	defer fs.enter("Symlink")()
+	errc, node := fs.make(newpath, S_IFLNK|0777)
+	if 0 == errc {
+		node.link = target
+	}
+	return errc
+}
+
+func (fs *testFs) Readlink(path string) (int, string) {
+	defer fs.enter("Readlink")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc, ""
+	}
+	if S_IFLNK != node.stat.Mode&S_IFMT {
+		return -EINVAL, ""
+	}
+	return 0, node.link
+}
+
+func (fs *testFs) Rename(oldpath string, newpath string) int {
+	defer fs.enter("Rename")()
+	errc, node := fs.lookup(oldpath)
+	if 0 != errc {
+		return errc
+	}
+	if errc, _ := fs.lookup(pathutil.Dir(newpath)); 0 != errc {
+		return errc
+	}
+	if oldpath == newpath {
+		return 0
+	}
+	if strings.HasPrefix(newpath, oldpath+"/") {
+		return -EINVAL
+	}
+	if dest := fs.nodes[newpath]; nil != dest {
+		if S_IFDIR == dest.stat.Mode&S_IFMT {
+			if S_IFDIR != node.stat.Mode&S_IFMT {
+				return -EISDIR
+			}
+			if 0 != len(fs.children(newpath)) {
+				return -ENOTEMPTY
+			}
+		} else if S_IFDIR == node.stat.Mode&S_IFMT {
+			return -ENOTDIR
+		} else if dest != node {
+			dest.stat.Nlink--
+		}
+	}
+	moved := map[string]*testNode{}
+	for p, n := range fs.nodes {
+		if p == oldpath || strings.HasPrefix(p, oldpath+"/") {
+			delete(fs.nodes, p)
+			moved[newpath+p[len(oldpath):]] = n
+		}
+	}
+	for p, n := range moved {
+		fs.nodes[p] = n
+	}
+	return 0
+}
+
+func (fs *testFs) Chmod(path string, mode uint32) int {
+	defer fs.enter("Chmod")()
+	errc, node := fs.lookup(path)
+	if 0 == errc {
+		node.stat.Mode = node.stat.Mode&S_IFMT | mode&07777
+	}
+	return errc
+}
+
+func (fs *testFs) Chown(path string, uid uint32, gid uint32) int {
+	defer fs.enter("Chown")()
+	errc, node := fs.lookup(path)
+	if 0 == errc {
+		if ^uint32(0) != uid {
+			node.stat.Uid = uid
+		}
+		if ^uint32(0) != gid {
+			node.stat.Gid = gid
+		}
+	}
+	return errc
+}
+
+func (fs *testFs) Utimens(path string, tmsp []Timespec) int {
+	defer fs.enter("Utimens")()
+	errc, node := fs.lookup(path)
+	if 0 == errc && 2 == len(tmsp) {
+		node.stat.Atim, node.stat.Mtim = tmsp[0], tmsp[1]
+	}
+	return errc
+}
+
+func (fs *testFs) Access(path string, mask uint32) int {
+	defer fs.enter("Access")()
+	errc, _ := fs.lookup(path)
+	return errc
+}
+
+func (fs *testFs) Create(path string, flags int, mode uint32) (int, uint64) {
+	defer fs.enter("Create")()
+	errc, node := fs.make(path, S_IFREG|mode&07777)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, node.stat.Ino
+}
+
+func (fs *testFs) Open(path string, flags int) (int, uint64) {
+	defer fs.enter("Open")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if 0 != flags&O_TRUNC {
+		node.data, node.stat.Size = nil, 0
+	}
+	return 0, node.stat.Ino
+}
+
+func (fs *testFs) Getattr(path string, stat *Stat_t, fh uint64) int {
+	defer fs.enter("Getattr")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	*stat = node.stat
+	return 0
+}
+
+func (fs *testFs) Truncate(path string, size int64, fh uint64) int {
+	defer fs.enter("Truncate")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	data := make([]byte, size)
+	copy(data, node.data)
+	node.data, node.stat.Size = data, size
+	return 0
+}
+
+func (fs *testFs) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	defer fs.enter("Read")()
+	node := fs.inos[fh]
+	if nil == node {
+		return -EBADF
+	}
+	if ofst >= int64(len(node.data)) {
+		return 0
+	}
+	return copy(buff, node.data[ofst:])
+}
+
+func (fs *testFs) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	defer fs.enter("Write")()
+	node := fs.inos[fh]
+	if nil == node {
+		return -EBADF
+	}
+	if endofst := ofst + int64(len(buff)); endofst > int64(len(node.data)) {
+		data := make([]byte, endofst)
+		copy(data, node.data)
+		node.data, node.stat.Size = data, endofst
+	}
+	return copy(node.data[ofst:], buff)
+}
+
+func (fs *testFs) Flush(path string, fh uint64) int {
+	defer fs.enter("Flush")()
+	return 0
+}
+
+func (fs *testFs) Release(path string, fh uint64) int {
+	defer fs.enter("Release")()
+	return 0
+}
+
+func (fs *testFs) Fsync(path string, datasync bool, fh uint64) int {
+	defer fs.enter("Fsync")()
+	return 0
+}
+
+func (fs *testFs) Opendir(path string) (int, uint64) {
+	defer fs.enter("Opendir")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if S_IFDIR != node.stat.Mode&S_IFMT {
+		return -ENOTDIR, ^uint64(0)
+	}
+	return 0, node.stat.Ino
+}
+
+func (fs *testFs) Readdir(path string,
+	fill func(name string, stat *Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) int {
+	defer fs.enter("Readdir")()
+	errc, _ := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	fill(".", nil, 0)
+	fill("..", nil, 0)
+	for _, name := range fs.children(path) {
+		stat := fs.nodes[pathutil.Join(path, name)].stat
+		if !fill(name, &stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func (fs *testFs) Releasedir(path string, fh uint64) int {
+	defer fs.enter("Releasedir")()
+	return 0
+}
+
+func (fs *testFs) Setxattr(path string, name string, value []byte, flags int) int {
+	defer fs.enter("Setxattr")()
+	errc, node := fs.lookup(path)
+	if 0 == errc {
+		node.xatr[name] = append([]byte{}, value...)
+	}
+	return errc
+}
+
+func (fs *testFs) Getxattr(path string, name string) (int, []byte) {
+	defer fs.enter("Getxattr")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc, nil
+	}
+	value, ok := node.xatr[name]
+	if !ok {
+		return -ENOATTR, nil
+	}
+	return 0, value
+}
+
+func (fs *testFs) Removexattr(path string, name string) int {
+	defer fs.enter("Removexattr")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	if _, ok := node.xatr[name]; !ok {
+		return -ENOATTR
+	}
+	delete(node.xatr, name)
+	return 0
+}
+
+func (fs *testFs) Listxattr(path string, fill func(name string) bool) int {
+	defer fs.enter("Listxattr")()
+	errc, node := fs.lookup(path)
+	if 0 != errc {
+		return errc
+	}
+	names := []string{}
+	for name := range node.xatr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !fill(name) {
+			return -ERANGE
+		}
+	}
+	return 0
+}
+
+// testReaddir returns the names listed by Readdir, excluding "." and "..".
+func testReaddir(fs FileSystemInterface, path string) (int, []string) {
+	errc, fh := fs.Opendir(path)
+	if 0 != errc {
+		return errc, nil
+	}
+	defer fs.Releasedir(path, fh)
+	names := []string{}
+	errc = fs.Readdir(path, func(name string, stat *Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			names = append(names, name)
+		}
+		return true
+	}, 0, fh)
+	sort.Strings(names)
+	return errc, names
+}
+
+// testReadFile returns the contents of the file at path.
+func testReadFile(fs FileSystemInterface, path string) (int, string) {
+	errc, fh := fs.Open(path, O_RDONLY)
+	if 0 != errc {
+		return errc, ""
+	}
+	defer fs.Release(path, fh)
+	buff := make([]byte, 4096)
+	n := fs.Read(path, buff, 0, fh)
+	if 0 > n {
+		return n, ""
+	}
+	return 0, string(buff[:n])
+}
+
+// testWriteFile writes data at offset ofst of the file at path.
+func testWriteFile(fs FileSystemInterface, path string, data string, ofst int64) int {
+	errc, fh := fs.Open(path, O_RDWR)
+	if 0 != errc {
+		return errc
+	}
+	defer fs.Release(path, fh)
+	n := fs.Write(path, []byte(data), ofst, fh)
+	if 0 > n {
+		return n
+	}
+	return 0
+}
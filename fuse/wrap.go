@@ -0,0 +1,94 @@
+/*
+ * wrap.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+// The functions in this file call the optional interfaces of a wrapped file system in
+// the same way that the host does, falling back to the FileSystemInterface methods when
+// the file system does not implement them. This allows a file system wrapper to
+// implement the optional interfaces regardless of the file system that it wraps.
+//
+// Interfaces that change how the host drives a file system (FileSystemNode,
+// FileSystemLookup, FileSystemReadBuf and FileSystemPassthrough) are not forwarded by
+// wrappers.
+
+func fsOpenEx(fs FileSystemInterface, path string, fi *FileInfo_t) int {
+	if intf, ok := fs.(FileSystemOpenEx); ok {
+		return intf.OpenEx(path, fi)
+	}
+	errc, fh := fs.Open(path, fi.Flags)
+	fi.Fh = fh
+	return errc
+}
+
+func fsCreateEx(fs FileSystemInterface, path string, mode uint32, fi *FileInfo_t) int {
+	if intf, ok := fs.(FileSystemOpenEx); ok {
+		return intf.CreateEx(path, mode, fi)
+	}
+	errc, fh := fs.Create(path, fi.Flags, mode)
+	fi.Fh = fh
+	return errc
+}
+
+func fsOpendirEx(fs FileSystemInterface, path string, fi *FileInfo_t) int {
+	if intf, ok := fs.(FileSystemOpendirEx); ok {
+		return intf.OpendirEx(path, fi)
+	}
+	errc, fh := fs.Opendir(path)
+	fi.Fh = fh
+	return errc
+}
+
+func fsFlushEx(fs FileSystemInterface, path string, fi *FileInfo_t) int {
+	if intf, ok := fs.(FileSystemReleaseEx); ok {
+		return intf.FlushEx(path, fi)
+	}
+	return fs.Flush(path, fi.Fh)
+}
+
+func fsReleaseEx(fs FileSystemInterface, path string, fi *FileInfo_t) int {
+	if intf, ok := fs.(FileSystemReleaseEx); ok {
+		return intf.ReleaseEx(path, fi)
+	}
+	return fs.Release(path, fi.Fh)
+}
+
+func fsGetpath(fs FileSystemInterface, path string, fh uint64) (int, string) {
+	if intf, ok := fs.(FileSystemGetpath); ok {
+		return intf.Getpath(path, fh)
+	}
+	return -ENOSYS, ""
+}
+
+func fsChflags(fs FileSystemInterface, path string, flags uint32) int {
+	if intf, ok := fs.(FileSystemChflags); ok {
+		return intf.Chflags(path, flags)
+	}
+	// say we did it!
+	return 0
+}
+
+func fsSetcrtime(fs FileSystemInterface, path string, tmsp Timespec) int {
+	if intf, ok := fs.(FileSystemSetcrtime); ok {
+		return intf.Setcrtime(path, tmsp)
+	}
+	// say we did it!
+	return 0
+}
+
+func fsSetchgtime(fs FileSystemInterface, path string, tmsp Timespec) int {
+	if intf, ok := fs.(FileSystemSetchgtime); ok {
+		return intf.Setchgtime(path, tmsp)
+	}
+	// say we did it!
+	return 0
+}
@@ -0,0 +1,676 @@
+/*
+ * writecache.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WriteCacheOptions contains options for WriteCache.
+type WriteCacheOptions struct {
+	// Maximum number of dirty bytes held across all files. Zero means 64 MiB.
+	MaxDirty int64
+
+	// Interval at which dirty data is written back. Zero disables periodic write-back.
+	FlushInterval time.Duration
+
+	// Directory in which dirty data is held. If empty dirty data is held in memory;
+	// otherwise it is held in temporary files created in Dir.
+	Dir string
+}
+
+type wcExtent struct {
+	ofst int64
+	size int64
+	data []byte // nil if held in a temporary file
+}
+
+type wcFile struct {
+	lock    sync.Mutex
+	path    string
+	extents []wcExtent // sorted, non-adjacent
+	temp    *os.File
+	wfh     uint64 // file handle used for write-back
+	writers map[*wcHandle]bool
+	nopen   int
+	err     int       // write-back error not yet reported
+	retain  *wcHandle // released writer kept open to retry write-back
+}
+
+type wcHandle struct {
+	file *wcFile
+	fh   uint64
+}
+
+type writeCacheFs struct {
+	dirty int64 // atomic; first for alignment
+	FileSystemInterface
+	opts    WriteCacheOptions
+	lock    sync.Mutex
+	files   map[string]*wcFile
+	handles map[uint64]*wcHandle
+	retains map[*wcFile]bool
+	nexth   uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// WriteCache returns a file system that buffers writes to fs. Written data is held as
+// dirty data and is coalesced into larger writes, which are issued when the file is
+// flushed, synchronized or released, when the periodic write-back timer fires, or when
+// the amount of dirty data exceeds its limit. Reads and Getattr through the returned
+// file system reflect dirty data.
+//
+// Write-back errors are reported by the next Flush, Fsync or Release of the file. When
+// the dirty data limit is exceeded and dirty data cannot be written back, Write fails
+// with the write-back error.
+//
+// Since the kernel ignores errors from Release, a write-back failure when the last
+// file handle opened for writing is released is reported to close(2) through Flush.
+// The dirty data is then retained along with the underlying file handle, which is not
+// released until write-back succeeds. Write-back is retried when the periodic
+// write-back timer fires, when the dirty data limit is exceeded and on Destroy; dirty
+// data that still cannot be written back on Destroy is discarded.
+//
+// Dirty data is only written back through file handles that were opened for writing;
+// a file system that does not allow writes through a file handle after the file has been
+// unlinked will lose dirty data of unlinked files.
+func WriteCache(fs FileSystemInterface, opts WriteCacheOptions) FileSystemInterface {
+	if 0 >= opts.MaxDirty {
+		opts.MaxDirty = 64 * 1024 * 1024
+	}
+	return &writeCacheFs{
+		FileSystemInterface: fs,
+		opts:                opts,
+		files:               map[string]*wcFile{},
+		handles:             map[uint64]*wcHandle{},
+		retains:             map[*wcFile]bool{},
+	}
+}
+
+// write adds buff at ofst to the dirty data of file. It returns the change in the
+// number of dirty bytes.
+func (file *wcFile) write(buff []byte, ofst int64, dir string) (int64, int) {
+	end := ofst + int64(len(buff))
+	lo, hi := ofst, end
+	i := 0
+	for ; len(file.extents) > i && file.extents[i].ofst+file.extents[i].size < ofst; i++ {
+	}
+	j := i
+	for ; len(file.extents) > j && file.extents[j].ofst <= end; j++ {
+		if lo > file.extents[j].ofst {
+			lo = file.extents[j].ofst
+		}
+		if e := file.extents[j].ofst + file.extents[j].size; hi < e {
+			hi = e
+		}
+	}
+	ext := wcExtent{ofst: lo, size: hi - lo}
+	if "" == dir {
+		k := i
+		if i < j && lo == file.extents[i].ofst {
+			// grow the first extent in place so that sequential writes are amortized
+			ext.data = file.extents[i].data
+			if n := int(hi - lo); len(ext.data) < n {
+				ext.data = append(ext.data, make([]byte, n-len(ext.data))...)
+			}
+			k++
+		} else {
+			ext.data = make([]byte, hi-lo)
+		}
+		for _, e := range file.extents[k:j] {
+			copy(ext.data[e.ofst-lo:], e.data)
+		}
+		copy(ext.data[ofst-lo:], buff)
+	} else {
+		if nil == file.temp {
+			temp, err := ioutil.TempFile(dir, "cgofuse-wc-")
+			if nil != err {
+				return 0, -EIO
+			}
+			os.Remove(temp.Name())
+			file.temp = temp
+		}
+		if _, err := file.temp.WriteAt(buff, ofst); nil != err {
+			return 0, -EIO
+		}
+	}
+	delta := ext.size
+	for _, e := range file.extents[i:j] {
+		delta -= e.size
+	}
+	if i == j {
+		file.extents = append(file.extents, wcExtent{})
+		copy(file.extents[i+1:], file.extents[i:])
+	} else {
+		file.extents = append(file.extents[:i+1], file.extents[j:]...)
+	}
+	file.extents[i] = ext
+	return delta, 0
+}
+
+// read copies the dirty data in the range [ofst, ofst+len(buff)) to buff.
+func (file *wcFile) read(buff []byte, ofst int64) {
+	end := ofst + int64(len(buff))
+	for _, e := range file.extents {
+		lo, hi := e.ofst, e.ofst+e.size
+		if lo < ofst {
+			lo = ofst
+		}
+		if hi > end {
+			hi = end
+		}
+		if lo >= hi {
+			continue
+		}
+		if nil != e.data {
+			copy(buff[lo-ofst:hi-ofst], e.data[lo-e.ofst:])
+		} else {
+			file.temp.ReadAt(buff[lo-ofst:hi-ofst], lo)
+		}
+	}
+}
+
+func (file *wcFile) end() int64 {
+	if 0 == len(file.extents) {
+		return 0
+	}
+	e := file.extents[len(file.extents)-1]
+	return e.ofst + e.size
+}
+
+// flush writes the dirty data of file back to fs. It must be called with the file
+// locked.
+func (wc *writeCacheFs) flush(file *wcFile) int {
+	var buff []byte
+	for 0 < len(file.extents) {
+		e := &file.extents[0]
+		for 0 < e.size {
+			data := e.data
+			if nil == data {
+				if nil == buff {
+					buff = make([]byte, 1024*1024)
+				}
+				data = buff
+				if int64(len(data)) > e.size {
+					data = data[:e.size]
+				}
+				if _, err := file.temp.ReadAt(data, e.ofst); nil != err {
+					return -EIO
+				}
+			}
+			n := wc.FileSystemInterface.Write(file.path, data, e.ofst, file.wfh)
+			if 0 > n {
+				return n
+			}
+			if 0 == n {
+				return -EIO
+			}
+			e.ofst += int64(n)
+			e.size -= int64(n)
+			if nil != e.data {
+				e.data = e.data[n:]
+			}
+			wc.addDirty(-int64(n))
+		}
+		file.extents = file.extents[1:]
+	}
+	file.extents = nil
+	if nil != file.temp {
+		file.temp.Truncate(0)
+	}
+	return 0
+}
+
+// flushReport flushes file and returns any unreported write-back error.
+func (wc *writeCacheFs) flushReport(file *wcFile) int {
+	errc := wc.flush(file)
+	if 0 == errc {
+		errc = file.err
+	}
+	file.err = 0
+	return errc
+}
+
+func (wc *writeCacheFs) addDirty(delta int64) int64 {
+	return atomic.AddInt64(&wc.dirty, delta)
+}
+
+// reduce writes back dirty data until size additional bytes fit under the limit.
+func (wc *writeCacheFs) reduce(size int64) int {
+	wc.lock.Lock()
+	files := make([]*wcFile, 0, len(wc.handles))
+	seen := map[*wcFile]bool{}
+	for file := range wc.retains {
+		seen[file] = true
+		files = append(files, file)
+	}
+	for _, h := range wc.handles {
+		if !seen[h.file] {
+			seen[h.file] = true
+			files = append(files, h.file)
+		}
+	}
+	wc.lock.Unlock()
+	errc := 0
+	for _, file := range files {
+		if wc.addDirty(0)+size <= wc.opts.MaxDirty && nil == file.retain {
+			continue
+		}
+		file.lock.Lock()
+		settled := false
+		if e := wc.flush(file); 0 != e {
+			file.err = e
+			errc = e
+		} else {
+			settled = wc.settle(file)
+		}
+		file.lock.Unlock()
+		if settled {
+			wc.unretain(file)
+		}
+	}
+	if wc.addDirty(0)+size <= wc.opts.MaxDirty {
+		return 0
+	}
+	if 0 == errc {
+		errc = -ENOSPC
+	}
+	return errc
+}
+
+func (wc *writeCacheFs) flushAll() {
+	wc.reduce(wc.opts.MaxDirty + 1)
+}
+
+// settle releases the file handle retained for write-back once file has no dirty data.
+// It must be called with the file locked; if it returns true, unretain must be called
+// after the file is unlocked.
+func (wc *writeCacheFs) settle(file *wcFile) bool {
+	h := file.retain
+	if nil == h || 0 != len(file.extents) {
+		return false
+	}
+	file.retain = nil
+	wc.FileSystemInterface.Release(file.path, h.fh)
+	if 0 == file.nopen && nil != file.temp {
+		file.temp.Close()
+		file.temp = nil
+	}
+	return true
+}
+
+func (wc *writeCacheFs) unretain(file *wcFile) {
+	wc.lock.Lock()
+	delete(wc.retains, file)
+	if wc.files[file.path] == file {
+		file.lock.Lock()
+		last := 0 == file.nopen && nil == file.retain
+		file.lock.Unlock()
+		if last {
+			delete(wc.files, file.path)
+		}
+	}
+	wc.lock.Unlock()
+}
+
+func (wc *writeCacheFs) findFile(path string) *wcFile {
+	wc.lock.Lock()
+	defer wc.lock.Unlock()
+	return wc.files[path]
+}
+
+func (wc *writeCacheFs) getHandle(fh uint64) *wcHandle {
+	wc.lock.Lock()
+	defer wc.lock.Unlock()
+	return wc.handles[fh]
+}
+
+func (wc *writeCacheFs) newHandle(path string, fh uint64, flags int) uint64 {
+	wc.lock.Lock()
+	defer wc.lock.Unlock()
+	file := wc.files[path]
+	if nil == file {
+		file = &wcFile{path: path, writers: map[*wcHandle]bool{}}
+		wc.files[path] = file
+	}
+	h := &wcHandle{file, fh}
+	file.lock.Lock()
+	file.nopen++
+	if O_RDONLY != flags&O_ACCMODE {
+		file.writers[h] = true
+		file.wfh = fh
+	}
+	file.lock.Unlock()
+	wc.nexth++
+	wc.handles[wc.nexth] = h
+	return wc.nexth
+}
+
+func (wc *writeCacheFs) Init() {
+	wc.FileSystemInterface.Init()
+	if 0 < wc.opts.FlushInterval {
+		wc.stop = make(chan struct{})
+		wc.done = make(chan struct{})
+		go func(stop, done chan struct{}) {
+			defer close(done)
+			t := time.NewTicker(wc.opts.FlushInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					wc.flushAll()
+				case <-stop:
+					return
+				}
+			}
+		}(wc.stop, wc.done)
+	}
+}
+
+func (wc *writeCacheFs) Destroy() {
+	if nil != wc.stop {
+		close(wc.stop)
+		<-wc.done
+		wc.stop = nil
+	}
+	wc.flushAll()
+	wc.lock.Lock()
+	files := make([]*wcFile, 0, len(wc.retains))
+	for file := range wc.retains {
+		files = append(files, file)
+	}
+	wc.lock.Unlock()
+	for _, file := range files {
+		// write-back has failed for good: discard the dirty data
+		file.lock.Lock()
+		for _, e := range file.extents {
+			wc.addDirty(-e.size)
+		}
+		file.extents = nil
+		settled := wc.settle(file)
+		file.lock.Unlock()
+		if settled {
+			wc.unretain(file)
+		}
+	}
+	wc.FileSystemInterface.Destroy()
+}
+
+func (wc *writeCacheFs) Unlink(path string) int {
+	errc := wc.FileSystemInterface.Unlink(path)
+	if 0 == errc {
+		wc.lock.Lock()
+		delete(wc.files, path)
+		wc.lock.Unlock()
+	}
+	return errc
+}
+
+func (wc *writeCacheFs) Rename(oldpath string, newpath string) int {
+	errc := wc.FileSystemInterface.Rename(oldpath, newpath)
+	if 0 == errc {
+		wc.lock.Lock()
+		delete(wc.files, newpath)
+		for path, file := range wc.files {
+			if path == oldpath || strings.HasPrefix(path, oldpath+"/") {
+				delete(wc.files, path)
+				path = newpath + path[len(oldpath):]
+				wc.files[path] = file
+				file.lock.Lock()
+				file.path = path
+				file.lock.Unlock()
+			}
+		}
+		wc.lock.Unlock()
+	}
+	return errc
+}
+
+func (wc *writeCacheFs) Create(path string, flags int, mode uint32) (int, uint64) {
+	errc, fh := wc.FileSystemInterface.Create(path, flags, mode)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, wc.newHandle(path, fh, flags)
+}
+
+func (wc *writeCacheFs) Open(path string, flags int) (int, uint64) {
+	if 0 != flags&O_TRUNC {
+		if file := wc.findFile(path); nil != file {
+			file.lock.Lock()
+			errc := wc.flushReport(file)
+			file.lock.Unlock()
+			if 0 != errc {
+				return errc, ^uint64(0)
+			}
+		}
+	}
+	errc, fh := wc.FileSystemInterface.Open(path, flags)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	return 0, wc.newHandle(path, fh, flags)
+}
+
+func (wc *writeCacheFs) Getattr(path string, stat *Stat_t, fh uint64) int {
+	var file *wcFile
+	if h := wc.getHandle(fh); nil != h {
+		file, fh = h.file, h.fh
+	} else {
+		file, fh = wc.findFile(path), ^uint64(0)
+	}
+	errc := wc.FileSystemInterface.Getattr(path, stat, fh)
+	if 0 == errc && nil != file {
+		file.lock.Lock()
+		if end := file.end(); stat.Size < end {
+			stat.Size = end
+		}
+		file.lock.Unlock()
+	}
+	return errc
+}
+
+func (wc *writeCacheFs) Truncate(path string, size int64, fh uint64) int {
+	var file *wcFile
+	if h := wc.getHandle(fh); nil != h {
+		file, fh = h.file, h.fh
+	} else {
+		file, fh = wc.findFile(path), ^uint64(0)
+	}
+	if nil != file {
+		file.lock.Lock()
+		defer file.lock.Unlock()
+		errc := wc.flushReport(file)
+		if 0 != errc {
+			return errc
+		}
+	}
+	return wc.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (wc *writeCacheFs) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	h := wc.getHandle(fh)
+	if nil == h {
+		return -EBADF
+	}
+	file := h.file
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	n := wc.FileSystemInterface.Read(path, buff, ofst, h.fh)
+	if 0 > n || 0 == len(file.extents) {
+		return n
+	}
+	if n < len(buff) {
+		// short read: extend with dirty data beyond the end of file
+		size := file.end() - ofst
+		if size > int64(len(buff)) {
+			size = int64(len(buff))
+		}
+		for i := n; int64(i) < size; i++ {
+			buff[i] = 0
+		}
+		if int64(n) < size {
+			n = int(size)
+		}
+	}
+	file.read(buff[:n], ofst)
+	return n
+}
+
+func (wc *writeCacheFs) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	h := wc.getHandle(fh)
+	if nil == h {
+		return -EBADF
+	}
+	file := h.file
+	size := int64(len(buff))
+	if size > wc.opts.MaxDirty {
+		// too large to cache: write through
+		file.lock.Lock()
+		defer file.lock.Unlock()
+		file.wfh = h.fh
+		if errc := wc.flushReport(file); 0 != errc {
+			return errc
+		}
+		return wc.FileSystemInterface.Write(path, buff, ofst, h.fh)
+	}
+	if wc.addDirty(0)+size > wc.opts.MaxDirty {
+		if errc := wc.reduce(size); 0 != errc {
+			return errc
+		}
+	}
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	file.wfh = h.fh
+	delta, errc := file.write(buff, ofst, wc.opts.Dir)
+	if 0 != errc {
+		return errc
+	}
+	wc.addDirty(delta)
+	return len(buff)
+}
+
+func (wc *writeCacheFs) Flush(path string, fh uint64) int {
+	h := wc.getHandle(fh)
+	if nil == h {
+		return -EBADF
+	}
+	h.file.lock.Lock()
+	errc := wc.flushReport(h.file)
+	h.file.lock.Unlock()
+	if 0 != errc {
+		return errc
+	}
+	return wc.FileSystemInterface.Flush(path, h.fh)
+}
+
+func (wc *writeCacheFs) Fsync(path string, datasync bool, fh uint64) int {
+	h := wc.getHandle(fh)
+	if nil == h {
+		return -EBADF
+	}
+	h.file.lock.Lock()
+	errc := wc.flushReport(h.file)
+	h.file.lock.Unlock()
+	if 0 != errc {
+		return errc
+	}
+	return wc.FileSystemInterface.Fsync(path, datasync, h.fh)
+}
+
+func (wc *writeCacheFs) Release(path string, fh uint64) int {
+	wc.lock.Lock()
+	h := wc.handles[fh]
+	delete(wc.handles, fh)
+	wc.lock.Unlock()
+	if nil == h {
+		return -EBADF
+	}
+	file := h.file
+	file.lock.Lock()
+	errc := 0
+	retain, settled := false, false
+	if file.writers[h] {
+		errc = wc.flushReport(file)
+		if 0 == errc {
+			settled = wc.settle(file)
+		}
+		delete(file.writers, h)
+		if file.wfh == h.fh {
+			for w := range file.writers {
+				file.wfh = w.fh
+				break
+			}
+		}
+		if 0 == len(file.writers) && 0 != len(file.extents) && nil == file.retain {
+			// no other handle remains through which dirty data could be written back:
+			// keep this one open and retry later
+			file.retain = h
+			retain = true
+		} else if 0 == len(file.writers) && nil != file.retain {
+			file.wfh = file.retain.fh
+		}
+	}
+	file.nopen--
+	last := 0 == file.nopen && nil == file.retain
+	if last && nil != file.temp {
+		file.temp.Close()
+		file.temp = nil
+	}
+	file.lock.Unlock()
+	if last {
+		wc.lock.Lock()
+		if wc.files[file.path] == file {
+			delete(wc.files, file.path)
+		}
+		wc.lock.Unlock()
+	}
+	if settled {
+		wc.unretain(file)
+	}
+	if retain {
+		wc.lock.Lock()
+		wc.retains[file] = true
+		wc.lock.Unlock()
+		return errc
+	}
+	if e := wc.FileSystemInterface.Release(path, h.fh); 0 == errc {
+		errc = e
+	}
+	return errc
+}
+
+func (wc *writeCacheFs) Chflags(path string, flags uint32) int {
+	return fsChflags(wc.FileSystemInterface, path, flags)
+}
+
+func (wc *writeCacheFs) Setcrtime(path string, tmsp Timespec) int {
+	return fsSetcrtime(wc.FileSystemInterface, path, tmsp)
+}
+
+func (wc *writeCacheFs) Setchgtime(path string, tmsp Timespec) int {
+	return fsSetchgtime(wc.FileSystemInterface, path, tmsp)
+}
+
+var (
+	_ FileSystemInterface  = (*writeCacheFs)(nil)
+	_ FileSystemChflags    = (*writeCacheFs)(nil)
+	_ FileSystemSetcrtime  = (*writeCacheFs)(nil)
+	_ FileSystemSetchgtime = (*writeCacheFs)(nil)
+)
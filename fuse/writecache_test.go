@@ -0,0 +1,271 @@
+/*
+ * writecache_test.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Cgofuse.
+ *
+ * It is licensed under the MIT license. The full license text can be found
+ * in the License.txt file at the root of this project.
+ */
+
+package fuse
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testFailWriteFs struct {
+	*testFs
+	errc int32
+}
+
+func (fs *testFailWriteFs) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	if errc := atomic.LoadInt32(&fs.errc); 0 != errc {
+		return int(errc)
+	}
+	return fs.testFs.Write(path, buff, ofst, fh)
+}
+
+func testWriteCache(t *testing.T, opts WriteCacheOptions) {
+	tfs := newTestFs()
+	tfs.create("/file", S_IFREG|0644, "0123456789")
+	fs := WriteCache(tfs, opts)
+
+	errc, fh := fs.Open("/file", O_RDWR)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	for i, s := range []string{"ab", "cd", "ef"} {
+		if n := fs.Write("/file", []byte(s), int64(8+2*i), fh); 2 != n {
+			t.Error(n)
+		}
+	}
+	if 0 != tfs.count("Write") {
+		t.Error(tfs.count("Write"))
+	}
+	stat := Stat_t{}
+	if errc := fs.Getattr("/file", &stat, ^uint64(0)); 0 != errc || 14 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	buff := make([]byte, 20)
+	if n := fs.Read("/file", buff, 0, fh); 14 != n || "01234567abcdef" != string(buff[:n]) {
+		t.Error(n, string(buff[:n]))
+	}
+	if n := fs.Write("/file", []byte("X"), 20, fh); 1 != n {
+		t.Error(n)
+	}
+	if n := fs.Read("/file", buff, 10, fh); 11 != n || "cdef\x00\x00\x00\x00\x00\x00X" != string(buff[:n]) {
+		t.Errorf("%d %q", n, buff[:n])
+	}
+	if errc := fs.Fsync("/file", false, fh); 0 != errc {
+		t.Error(errc)
+	}
+	if 2 != tfs.count("Write") {
+		t.Error(tfs.count("Write"))
+	}
+	if errc, data := testReadFile(tfs, "/file"); 0 != errc || "01234567abcdef\x00\x00\x00\x00\x00\x00X" != data {
+		t.Errorf("%d %q", errc, data)
+	}
+	if n := fs.Write("/file", []byte("Z"), 0, fh); 1 != n {
+		t.Error(n)
+	}
+	if errc := fs.Release("/file", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(tfs, "/file"); 0 != errc || 'Z' != data[0] {
+		t.Errorf("%d %q", errc, data)
+	}
+}
+
+func TestWriteCache(t *testing.T) {
+	testWriteCache(t, WriteCacheOptions{})
+}
+
+func TestWriteCacheDir(t *testing.T) {
+	testWriteCache(t, WriteCacheOptions{Dir: t.TempDir()})
+}
+
+func TestWriteCacheExtents(t *testing.T) {
+	file := &wcFile{}
+	for i := 0; 1000 > i; i++ {
+		if delta, errc := file.write([]byte{byte(i)}, int64(i), ""); 0 != errc || 1 != delta {
+			t.Fatal(delta, errc)
+		}
+	}
+	// sequential writes extend a single extent whose buffer grows geometrically
+	if 1 != len(file.extents) || 1000 != file.extents[0].size || 2000 < cap(file.extents[0].data) {
+		t.Fatal(len(file.extents), cap(file.extents[0].data))
+	}
+
+	file.write([]byte("ab"), 2000, "")
+	file.write([]byte("cd"), 1500, "")
+	if 3 != len(file.extents) || 1500 != file.extents[1].ofst || 2000 != file.extents[2].ofst {
+		t.Fatal(file.extents)
+	}
+	if delta, _ := file.write([]byte("xy"), 999, ""); 1 != delta || 3 != len(file.extents) {
+		t.Error(delta, len(file.extents))
+	}
+	// a write that reaches the next extent merges them
+	if delta, _ := file.write(make([]byte, 500), 1001, ""); 499 != delta || 2 != len(file.extents) {
+		t.Error(delta, len(file.extents))
+	}
+	buff := make([]byte, 6)
+	file.read(buff, 997)
+	if "\xe5\xe6xy\x00\x00" != string(buff) {
+		t.Errorf("%q", buff)
+	}
+	buff = make([]byte, 6)
+	file.read(buff, 1499)
+	if "\x00\x00d\x00\x00\x00" != string(buff) {
+		t.Errorf("%q", buff)
+	}
+}
+
+func TestWriteCacheLimit(t *testing.T) {
+	tfs := newTestFs()
+	fs := WriteCache(tfs, WriteCacheOptions{MaxDirty: 4})
+
+	errc, fh := fs.Create("/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	for i := 0; 6 > i; i++ {
+		if n := fs.Write("/file", []byte("ab"), int64(2*i), fh); 2 != n {
+			t.Error(n)
+		}
+	}
+	if 2 != tfs.count("Write") {
+		t.Error(tfs.count("Write"))
+	}
+	if n := fs.Write("/file", []byte("0123456789"), 12, fh); 10 != n {
+		t.Error(n)
+	}
+	if errc := fs.Release("/file", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(tfs, "/file"); 0 != errc || "abababababab0123456789" != data {
+		t.Error(errc, data)
+	}
+}
+
+func TestWriteCacheError(t *testing.T) {
+	tfs := &testFailWriteFs{testFs: newTestFs()}
+	fs := WriteCache(tfs, WriteCacheOptions{MaxDirty: 4})
+
+	errc, fh := fs.Create("/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	atomic.StoreInt32(&tfs.errc, int32(-EIO))
+	if n := fs.Write("/file", []byte("abcd"), 0, fh); 4 != n {
+		t.Error(n)
+	}
+	if n := fs.Write("/file", []byte("e"), 4, fh); -EIO != n {
+		t.Error(n)
+	}
+	if errc := fs.Flush("/file", fh); -EIO != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Fsync("/file", false, fh); -EIO != errc {
+		t.Error(errc)
+	}
+	atomic.StoreInt32(&tfs.errc, 0)
+	if errc := fs.Fsync("/file", false, fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(tfs, "/file"); 0 != errc || "abcd" != data {
+		t.Error(errc, data)
+	}
+	fs.Release("/file", fh)
+}
+
+func TestWriteCacheRetain(t *testing.T) {
+	tfs := &testFailWriteFs{testFs: newTestFs()}
+	fs := WriteCache(tfs, WriteCacheOptions{})
+
+	errc, fh := fs.Create("/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if n := fs.Write("/file", []byte("abcd"), 0, fh); 4 != n {
+		t.Error(n)
+	}
+	atomic.StoreInt32(&tfs.errc, int32(-EIO))
+	if errc := fs.Flush("/file", fh); -EIO != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Release("/file", fh); -EIO != errc {
+		t.Error(errc)
+	}
+
+	// dirty data and the underlying file handle are retained
+	if 0 != tfs.count("Release") {
+		t.Error(tfs.count("Release"))
+	}
+	stat := Stat_t{}
+	if errc := fs.Getattr("/file", &stat, ^uint64(0)); 0 != errc || 4 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+
+	atomic.StoreInt32(&tfs.errc, 0)
+	fs.(*writeCacheFs).flushAll()
+	if 1 != tfs.count("Release") {
+		t.Error(tfs.count("Release"))
+	}
+	if errc, data := testReadFile(tfs, "/file"); 0 != errc || "abcd" != data {
+		t.Error(errc, data)
+	}
+	wc := fs.(*writeCacheFs)
+	if 0 != len(wc.files) || 0 != len(wc.retains) || 0 != wc.dirty {
+		t.Error(len(wc.files), len(wc.retains), wc.dirty)
+	}
+}
+
+func TestWriteCacheTimer(t *testing.T) {
+	tfs := newTestFs()
+	fs := WriteCache(tfs, WriteCacheOptions{FlushInterval: 10 * time.Millisecond})
+	fs.Init()
+	defer fs.Destroy()
+
+	errc, fh := fs.Create("/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	defer fs.Release("/file", fh)
+	fs.Write("/file", []byte("hello"), 0, fh)
+	for i := 0; 100 > i && 0 == tfs.count("Write"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if errc, data := testReadFile(tfs, "/file"); 0 != errc || "hello" != data {
+		t.Error(errc, data)
+	}
+}
+
+func TestWriteCacheRename(t *testing.T) {
+	tfs := newTestFs()
+	tfs.create("/dir", S_IFDIR|0755, "")
+	fs := WriteCache(tfs, WriteCacheOptions{})
+
+	errc, fh := fs.Create("/dir/file", O_RDWR, 0644)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Write("/dir/file", []byte("hello"), 0, fh)
+	if errc := fs.Rename("/dir", "/new"); 0 != errc {
+		t.Error(errc)
+	}
+	stat := Stat_t{}
+	if errc := fs.Getattr("/new/file", &stat, ^uint64(0)); 0 != errc || 5 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Release("/new/file", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, data := testReadFile(tfs, "/new/file"); 0 != errc || "hello" != data {
+		t.Error(errc, data)
+	}
+}